@@ -0,0 +1,447 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"slices"
+	"sync"
+	"syscall"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/SerjRamone/metrius/internal/aggregator"
+	collect "github.com/SerjRamone/metrius/internal/collector"
+	"github.com/SerjRamone/metrius/internal/config"
+	"github.com/SerjRamone/metrius/internal/metrics"
+	"github.com/SerjRamone/metrius/internal/relabel"
+	"github.com/SerjRamone/metrius/internal/sender"
+	"github.com/SerjRamone/metrius/internal/telemetry"
+	"github.com/SerjRamone/metrius/pkg/logger"
+)
+
+// config fields which are applied on restart only
+var restartFields = []string{
+	"RateLimit", "SpoolDir", "SpoolMaxSize", "SpoolMaxAge", "PushAddress", "PushSocket", "Config", "ConfigWatch",
+}
+
+// metricsCollector stores polled and pushed collections
+type metricsCollector interface {
+	Collect()
+	Add(metrics.Collection)
+	Export() []metrics.Collection
+}
+
+// reconfigurable is a sender which client and limits can be replaced, it closes previous client
+type reconfigurable interface {
+	Reconfigure(sender.APIClient, ...sender.Option)
+}
+
+// agent holds components which are rebuilt when config is reloaded
+type agent struct {
+	collector metricsCollector
+	sender    reconfigurable
+
+	pollTicker       *time.Ticker
+	additionalTicker *time.Ticker
+	reportTicker     *time.Ticker
+
+	// reloadMu serializes reloads, fields below are changed under both locks
+	reloadMu sync.Mutex
+	mu       sync.Mutex
+	conf     config.Agent
+	client   sender.APIClient
+	sources  map[string]collect.Source
+	export   func() []metrics.Collection
+
+	// exec collector is restarted on changes
+	execCancel context.CancelFunc
+	execDone   chan struct{}
+}
+
+// newAgent creates agent components by conf
+func newAgent(conf config.Agent, collector metricsCollector, s reconfigurable, client sender.APIClient) (*agent, error) {
+	a := &agent{
+		conf:             conf,
+		collector:        collector,
+		sender:           s,
+		client:           client,
+		pollTicker:       time.NewTicker(time.Duration(conf.PollInterval)),
+		additionalTicker: time.NewTicker(time.Duration(conf.ReportInterval)),
+		reportTicker:     time.NewTicker(time.Duration(conf.ReportInterval)),
+		sources: map[string]collect.Source{
+			"telemetry": telemetry.Source(),
+		},
+	}
+	if source, ok := s.(collect.Source); ok {
+		a.sources["sender"] = source
+	}
+
+	cgroup, err := newCgroup(conf)
+	if err != nil {
+		return nil, err
+	}
+	if cgroup != nil {
+		a.sources["cgroup"] = cgroup
+	}
+
+	if a.export, err = newExport(conf, collector); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// newCgroup creates cgroup v2 resource metrics source if it is enabled
+func newCgroup(conf config.Agent) (collect.Source, error) {
+	if !conf.Cgroup && conf.CgroupPath == "" {
+		return nil, nil
+	}
+	cgroup, err := collect.NewCgroup("", conf.CgroupPath)
+	if err != nil {
+		return nil, fmt.Errorf("NewCgroup() error: %w", err)
+	}
+	return cgroup, nil
+}
+
+// newExec creates collector of external commands if they are set
+func newExec(conf config.Agent) (*execRunner, error) {
+	if len(conf.Exec) == 0 {
+		return nil, nil
+	}
+	commands := make([]collect.ExecCommand, 0, len(conf.Exec))
+	for _, cmd := range conf.Exec {
+		commands = append(commands, collect.ExecCommand{
+			Name:     cmd.Name,
+			Command:  cmd.Command,
+			Interval: time.Duration(cmd.Interval),
+			Timeout:  time.Duration(cmd.Timeout),
+		})
+	}
+	execCollector, err := collect.NewExec(commands, conf.ExecConcurrency)
+	if err != nil {
+		return nil, fmt.Errorf("NewExec() error: %w", err)
+	}
+	return &execRunner{Source: execCollector, run: execCollector.Run}, nil
+}
+
+// execRunner is exec collector with its run function
+type execRunner struct {
+	collect.Source
+	run func(context.Context, func(metrics.Collection))
+}
+
+// newExport creates export function: collector export with aggregation and relabeling
+func newExport(conf config.Agent, collector metricsCollector) (func() []metrics.Collection, error) {
+	export := collector.Export
+
+	// collapse polled collections before sending
+	if conf.Aggregate {
+		agg, err := aggregator.New(conf.AggregateMode, conf.Aggregations)
+		if err != nil {
+			return nil, fmt.Errorf("aggregator.New() error: %w", err)
+		}
+		next := export
+		export = func() []metrics.Collection {
+			return agg.Aggregate(next())
+		}
+	}
+
+	// filter and rewrite metrics
+	if len(conf.Relabel) > 0 {
+		rules := make([]relabel.Rule, 0, len(conf.Relabel))
+		for _, r := range conf.Relabel {
+			rules = append(rules, relabel.Rule(r))
+		}
+		relabeler, err := relabel.New(rules)
+		if err != nil {
+			return nil, fmt.Errorf("relabel.New() error: %w", err)
+		}
+		next := export
+		export = func() []metrics.Collection {
+			return relabeler.Apply(next())
+		}
+	}
+	return export, nil
+}
+
+// senderOptions returns sender options which can be changed on reload
+func senderOptions(conf config.Agent) []sender.Option {
+	return []sender.Option{
+		sender.WithRateLimit(conf.RequestsPerSec, conf.BytesPerSec),
+		sender.WithMaxPayload(conf.MaxPayloadSize),
+	}
+}
+
+// collect polls collector and sources
+func (a *agent) collect() {
+	start := time.Now()
+	a.collector.Collect()
+	telemetry.CollectDuration("runtime", time.Since(start))
+
+	a.mu.Lock()
+	sources := make(map[string]collect.Source, len(a.sources))
+	for name, source := range a.sources {
+		sources[name] = source
+	}
+	a.mu.Unlock()
+
+	for name, source := range sources {
+		start = time.Now()
+		c, err := source.Collect()
+		telemetry.CollectDuration(name, time.Since(start))
+		if err != nil {
+			logger.Error("collect metrics from source error", zap.String("source", name), zap.Error(err))
+			continue
+		}
+		a.collector.Add(c)
+	}
+}
+
+// exportBatch returns collections prepared for sending
+func (a *agent) exportBatch() []metrics.Collection {
+	a.mu.Lock()
+	export := a.export
+	a.mu.Unlock()
+	return export()
+}
+
+// produce puts exported batches to jobCh until ctx is done.
+// Batch which was exported but not queued is returned to be sent with the last export,
+// it is not put back to collector, so it is not aggregated and relabelled twice.
+func (a *agent) produce(ctx context.Context, jobCh chan<- []metrics.Collection) []metrics.Collection {
+	for {
+		select {
+		case <-a.reportTicker.C:
+			collections := a.exportBatch()
+			if len(collections) == 0 {
+				continue
+			}
+			select {
+			case jobCh <- collections:
+			case <-ctx.Done():
+				// workers are busy
+				return collections
+			}
+
+		case <-ctx.Done():
+			logger.Info("sender recived done signal")
+			return nil
+		}
+	}
+}
+
+// shutdownTimeout returns max time of the last flush
+func (a *agent) shutdownTimeout() time.Duration {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return time.Duration(a.conf.ShutdownTimeout)
+}
+
+// startExec runs exec collector until ctx is done or it is replaced, a.mu must be held
+func (a *agent) startExec(ctx context.Context, runner *execRunner) {
+	if runner == nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	a.execCancel, a.execDone = cancel, done
+	a.sources["exec"] = runner.Source
+	go func() {
+		defer close(done)
+		runner.run(ctx, a.collector.Add)
+	}()
+}
+
+// stopExec stops exec collector and waits for running commands, a.mu must be held
+func (a *agent) stopExec() {
+	if a.execCancel == nil {
+		return
+	}
+	a.execCancel()
+	<-a.execDone
+	a.execCancel, a.execDone = nil, nil
+	delete(a.sources, "exec")
+}
+
+// close stops exec collector, tickers and closes client
+func (a *agent) close() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.stopExec()
+	a.pollTicker.Stop()
+	a.additionalTicker.Stop()
+	a.reportTicker.Stop()
+	if closer, ok := a.client.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			logger.Error("client closing error", zap.Error(err))
+		}
+	}
+}
+
+// watch reloads config on SIGHUP and, if ConfigWatch is set, on config file changes
+func (a *agent) watch(ctx context.Context) {
+	hupCh := make(chan os.Signal, 1)
+	signal.Notify(hupCh, syscall.SIGHUP)
+	defer signal.Stop(hupCh)
+
+	a.mu.Lock()
+	path, period := a.conf.Config, time.Duration(a.conf.ConfigWatch)
+	a.mu.Unlock()
+
+	var watchCh <-chan time.Time
+	var modTime time.Time
+	if path != "" && period > 0 {
+		ticker := time.NewTicker(period)
+		defer ticker.Stop()
+		watchCh = ticker.C
+		if info, err := os.Stat(path); err == nil {
+			modTime = info.ModTime()
+		}
+	}
+
+	for {
+		select {
+		case <-hupCh:
+			logger.Info("SIGHUP recived, reloading config")
+			a.reload(ctx)
+		case <-watchCh:
+			info, err := os.Stat(path)
+			if err != nil {
+				logger.Error("config file stat error", zap.Error(err))
+				continue
+			}
+			if info.ModTime().Equal(modTime) {
+				continue
+			}
+			modTime = info.ModTime()
+			logger.Info("config file changed, reloading config")
+			a.reload(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// reload reads config again and applies it. Invalid config is not applied.
+func (a *agent) reload(ctx context.Context) {
+	a.reloadMu.Lock()
+	defer a.reloadMu.Unlock()
+
+	next, err := a.conf.Reload()
+	if err != nil {
+		logger.Error("config reload error, keeping current config", zap.Error(err))
+		return
+	}
+	if err = a.apply(ctx, next); err != nil {
+		logger.Error("config apply error, keeping current config", zap.Error(err))
+	}
+}
+
+// apply applies changed fields of next config, a.reloadMu must be held.
+// New components are created first, so nothing is changed if any of them fails.
+func (a *agent) apply(ctx context.Context, next config.Agent) error {
+	changed := a.conf.Changed(next)
+	if len(changed) == 0 {
+		logger.Info("config not changed")
+		return nil
+	}
+	has := func(fields ...string) bool {
+		for _, f := range fields {
+			if slices.Contains(changed, f) {
+				return true
+			}
+		}
+		return false
+	}
+
+	// these are applied on restart only
+	for _, f := range restartFields {
+		if has(f) {
+			logger.Warn("config field change requires restart", zap.String("field", f))
+		}
+	}
+	next.RateLimit, next.Config, next.ConfigWatch = a.conf.RateLimit, a.conf.Config, a.conf.ConfigWatch
+	next.SpoolDir, next.SpoolMaxSize, next.SpoolMaxAge = a.conf.SpoolDir, a.conf.SpoolMaxSize, a.conf.SpoolMaxAge
+	next.PushAddress, next.PushSocket = a.conf.PushAddress, a.conf.PushSocket
+
+	client := a.client
+	if has("ServerAddress", "ServerType", "HashKey", "AgentID", "CryptoKey", "Destinations", "DestinationsMode", "Output", "OutputFormat",
+		"TLS", "TLSCA", "TLSCert", "TLSKey", "TLSServerName", "Token") {
+		c, err := newAPIClient(next)
+		if err != nil {
+			return fmt.Errorf("can't create API client: %w", err)
+		}
+		client = c
+	}
+	closeNew := func() {
+		if closer, ok := client.(io.Closer); ok && client != a.client {
+			closer.Close()
+		}
+	}
+
+	export := a.export
+	if has("Aggregate", "AggregateMode", "Aggregations", "Relabel") {
+		e, err := newExport(next, a.collector)
+		if err != nil {
+			closeNew()
+			return err
+		}
+		export = e
+	}
+
+	cgroupChanged := has("Cgroup", "CgroupPath")
+	var cgroup collect.Source
+	if cgroupChanged {
+		c, err := newCgroup(next)
+		if err != nil {
+			closeNew()
+			return err
+		}
+		cgroup = c
+	}
+
+	execChanged := has("Exec", "ExecConcurrency")
+	var runner *execRunner
+	if execChanged {
+		r, err := newExec(next)
+		if err != nil {
+			closeNew()
+			return err
+		}
+		runner = r
+	}
+
+	// all components are valid, apply them.
+	// Batches in flight are sent by previous client, queued ones are sent by new client.
+	a.sender.Reconfigure(client, senderOptions(next)...)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.client = client
+	a.export = export
+	if cgroupChanged {
+		delete(a.sources, "cgroup")
+		if cgroup != nil {
+			a.sources["cgroup"] = cgroup
+		}
+	}
+	if execChanged {
+		a.stopExec()
+		a.startExec(ctx, runner)
+	}
+	if has("PollInterval") {
+		a.pollTicker.Reset(time.Duration(next.PollInterval))
+	}
+	if has("ReportInterval") {
+		a.additionalTicker.Reset(time.Duration(next.ReportInterval))
+		a.reportTicker.Reset(time.Duration(next.ReportInterval))
+	}
+
+	a.conf = next
+	logger.Info("config reloaded", zap.Strings("changed", changed), zap.Object("config", &a.conf))
+	return nil
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"io"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/SerjRamone/metrius/internal/config"
+	"github.com/SerjRamone/metrius/internal/metrics"
+	"github.com/SerjRamone/metrius/internal/sender"
+)
+
+// stubCollector records added collections
+type stubCollector struct {
+	mu    sync.Mutex
+	added []metrics.Collection
+}
+
+func (c *stubCollector) Collect() {}
+
+func (c *stubCollector) Add(collection metrics.Collection) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.added = append(c.added, collection)
+}
+
+func (c *stubCollector) Export() []metrics.Collection {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	added := c.added
+	c.added = nil
+	return added
+}
+
+func TestAgent_Produce(t *testing.T) {
+	collector := &stubCollector{}
+	batch := []metrics.Collection{{{Name: "Alloc", Type: "gauge", Value: 1}}}
+	exported := make(chan struct{}, 1)
+	a := &agent{
+		collector:    collector,
+		reportTicker: time.NewTicker(time.Millisecond),
+		export: func() []metrics.Collection {
+			select {
+			case exported <- struct{}{}:
+			default:
+			}
+			return batch
+		},
+	}
+	defer a.reportTicker.Stop()
+
+	// workers are busy, nobody reads jobs
+	ctx, cancel := context.WithCancel(context.Background())
+	pending := make(chan []metrics.Collection)
+	go func() { pending <- a.produce(ctx, make(chan []metrics.Collection)) }()
+	<-exported
+	cancel()
+
+	// exported batch is returned as is, it doesn't pass collector again
+	assert.Equal(t, batch, <-pending)
+	assert.Empty(t, collector.Export())
+}
+
+// stubSender records clients of reconfigurations
+type stubSender struct {
+	clients []sender.APIClient
+}
+
+func (s *stubSender) Reconfigure(client sender.APIClient, _ ...sender.Option) {
+	s.clients = append(s.clients, client)
+}
+
+func TestAgent_Apply(t *testing.T) {
+	base := config.Agent{
+		ServerAddress:  "localhost:8080",
+		ServerType:     "http",
+		ReportInterval: config.Duration(time.Second),
+		PollInterval:   config.Duration(time.Second),
+		RateLimit:      1,
+		OutputFormat:   sender.FormatJSON,
+	}
+	output := filepath.Join(t.TempDir(), "metrics.json")
+
+	tests := []struct {
+		name           string
+		modify         func(*config.Agent)
+		wantErr        bool
+		wantClientSwap bool
+		// wantConf is applied to base to get expected config
+		wantConf func(*config.Agent)
+	}{
+		{
+			name:           "Test#1. Client swap",
+			modify:         func(c *config.Agent) { c.Output = output },
+			wantClientSwap: true,
+			wantConf:       func(c *config.Agent) { c.Output = output },
+		},
+		{
+			name: "Test#2. Restart only fields",
+			modify: func(c *config.Agent) {
+				c.RateLimit = 4
+				c.SpoolDir = t.TempDir()
+				c.ConfigWatch = config.Duration(time.Second)
+			},
+			wantConf: func(*config.Agent) {},
+		},
+		{
+			name: "Test#3. Runtime fields",
+			modify: func(c *config.Agent) {
+				c.PollInterval = config.Duration(time.Minute)
+				c.RequestsPerSec = 5
+			},
+			wantConf: func(c *config.Agent) {
+				c.PollInterval = config.Duration(time.Minute)
+				c.RequestsPerSec = 5
+			},
+		},
+		{
+			name: "Test#4. Invalid next config",
+			modify: func(c *config.Agent) {
+				c.Output = output
+				c.Relabel = []config.RelabelRule{{Action: "replace", Match: ".*"}}
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := sender.NewWriterClient(io.Discard, sender.FormatJSON)
+			require.NoError(t, err)
+			s := &stubSender{}
+			a, err := newAgent(base, &stubCollector{}, s, client)
+			require.NoError(t, err)
+			defer a.close()
+
+			next := base
+			tt.modify(&next)
+			err = a.apply(context.Background(), next)
+			if tt.wantErr {
+				assert.Error(t, err)
+				// nothing is changed
+				assert.Equal(t, base, a.conf)
+				assert.Equal(t, client, a.client)
+				assert.Empty(t, s.clients)
+				return
+			}
+			require.NoError(t, err)
+
+			want := base
+			tt.wantConf(&want)
+			assert.Equal(t, want, a.conf)
+			require.Len(t, s.clients, 1)
+			assert.Equal(t, a.client, s.clients[0])
+			if tt.wantClientSwap {
+				assert.NotEqual(t, client, a.client)
+			} else {
+				assert.Equal(t, client, a.client)
+			}
+		})
+	}
+}
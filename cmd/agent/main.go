@@ -2,21 +2,31 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/shirou/gopsutil/v3/cpu"
 	"github.com/shirou/gopsutil/v3/mem"
 	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
 
 	collect "github.com/SerjRamone/metrius/internal/collector"
 	"github.com/SerjRamone/metrius/internal/config"
 	"github.com/SerjRamone/metrius/internal/metrics"
+	"github.com/SerjRamone/metrius/internal/push"
 	"github.com/SerjRamone/metrius/internal/sender"
+	"github.com/SerjRamone/metrius/internal/spool"
+	"github.com/SerjRamone/metrius/internal/telemetry"
+	"github.com/SerjRamone/metrius/internal/tlsconfig"
 	"github.com/SerjRamone/metrius/pkg/logger"
 )
 
@@ -27,71 +37,122 @@ var (
 )
 
 func main() {
-	printTags()
-
 	conf, err := config.NewAgent()
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
 	if err != nil {
 		log.Fatal("config parse error: ", err)
 	}
+	if conf.PrintConfig {
+		if err = conf.Print(os.Stdout); err != nil {
+			log.Fatal("config print error: ", err)
+		}
+		return
+	}
+
+	printTags()
 
 	if err = logger.Init("info"); err != nil {
 		log.Fatal("can't init logger")
 	}
 
 	logger.Info("loaded config", zap.Object("config", &conf))
+	telemetry.SetBuildInfo(buildVersion, buildCommit)
 
-	var pubKey []byte
-	// check path and read key
-	if conf.CryptoKey != "" {
-		pubKey, err = os.ReadFile(conf.CryptoKey)
+	// catch signals
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
+	defer stop()
+
+	if err = run(ctx, conf); err != nil {
+		logger.Fatal("agent error", zap.Error(err))
+	}
+	logger.Info("shutting down")
+}
+
+// run starts agent and blocks until ctx is done or any component fails.
+// On shutdown collectors are stopped first, then the last export is sent by workers
+// within conf.ShutdownTimeout.
+func run(ctx context.Context, conf config.Agent) error {
+	senderOpts := senderOptions(conf)
+	if conf.SpoolDir != "" {
+		s, err := spool.New(conf.SpoolDir, conf.SpoolMaxSize, time.Duration(conf.SpoolMaxAge))
 		if err != nil {
-			logger.Error("reading keyfile error", zap.Error(err))
-			return
+			return fmt.Errorf("spool.New() error: %w", err)
 		}
+		senderOpts = append(senderOpts, sender.WithSpool(s))
 	}
-	sender, err := sender.NewMetricsSender(conf.ServerAddress, conf.HashKey, pubKey, conf.ServerType)
+	client, err := newAPIClient(conf)
 	if err != nil {
-		logger.Error("NewMetricsSender() error", zap.Error(err))
-		return
+		return fmt.Errorf("can't create API client: %w", err)
 	}
+	sender := sender.New(client, senderOpts...)
 	collector := collect.New()
 
-	// closing channel
-	doneCh := make(chan struct{})
+	// components which are rebuilt on config reload
+	a, err := newAgent(conf, collector, sender, client)
+	if err != nil {
+		if closer, ok := client.(io.Closer); ok {
+			closer.Close()
+		}
+		return err
+	}
+	defer a.close()
+
+	// collectors and producer of jobs stop when ctx is done or one of them fails
+	collectors, collectCtx := errgroup.WithContext(ctx)
 
-	// catch signals
-	sigCh := make(chan os.Signal, 1)
-	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
+	// external commands
+	runner, err := newExec(conf)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	a.startExec(collectCtx, runner)
+	a.mu.Unlock()
 
-	// chan for jobs for senders
-	jobCh := make(chan []metrics.Collection)
+	// reload config on SIGHUP and file changes
+	collectors.Go(func() error {
+		a.watch(collectCtx)
+		return nil
+	})
 
-	// run workers
-	for w := 1; w <= conf.RateLimit; w++ {
-		go sender.Worker(doneCh, jobCh)
+	// receive metrics from local applications
+	if conf.PushAddress != "" || conf.PushSocket != "" {
+		pushServer := push.NewServer(conf.PushAddress, conf.PushSocket, collector.Add)
+		if err = pushServer.Up(); err != nil {
+			return fmt.Errorf("push server start error: %w", err)
+		}
+		collectors.Go(func() error {
+			<-collectCtx.Done()
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			if err := pushServer.Down(shutdownCtx); err != nil {
+				logger.Error("push server shutting down error", zap.Error(err))
+			}
+			return nil
+		})
 	}
 
 	// collect metrics
-	go func() {
-		ticker := time.NewTicker(time.Duration(conf.PollInterval) * time.Second)
+	collectors.Go(func() error {
 		for {
 			select {
-			case <-ticker.C:
-				collector.Collect()
+			case <-a.pollTicker.C:
+				a.collect()
 
-			case <-doneCh:
+			case <-collectCtx.Done():
 				logger.Info("collector recived done signal")
-				return
+				return nil
 			}
 		}
-	}()
+	})
 
 	// additional metrics
-	go func() {
-		ticker := time.NewTicker(time.Duration(conf.ReportInterval) * time.Second)
+	collectors.Go(func() error {
 		for {
 			select {
-			case <-ticker.C:
+			case <-a.additionalTicker.C:
 				logger.Info("collect additional metrics")
 				v, err := mem.VirtualMemory()
 				if err != nil {
@@ -108,43 +169,128 @@ func main() {
 					metrics.CollectionItem{Name: "FreeMemory", Type: "gauge", Value: float64(v.Free)},
 					metrics.CollectionItem{Name: "CPUutilization1", Type: "gauge", Value: cpu[0]},
 				}
-				collections := []metrics.Collection{c}
-				jobCh <- collections
+				collector.Add(c)
 
-			case <-doneCh:
+			case <-collectCtx.Done():
 				logger.Info("additional metrics recived done signal")
-				return
+				return nil
 			}
 		}
-	}()
+	})
+
+	// chan for jobs for senders
+	jobCh := make(chan []metrics.Collection, conf.RateLimit)
+	telemetry.SetQueueDepth(func() int { return len(jobCh) })
+
+	// batch which was not queued before shutdown, it is sent with the last export
+	var pending []metrics.Collection
 
 	// put jobs
-	go func() {
-		defer close(jobCh)
-		ticker := time.NewTicker(time.Duration(conf.ReportInterval) * time.Second)
-		for {
-			select {
-			case <-ticker.C:
-				if collections := collector.Export(); len(collections) > 0 {
-					jobCh <- collections
-				}
+	collectors.Go(func() error {
+		pending = a.produce(collectCtx, jobCh)
+		return nil
+	})
 
-			case <-doneCh:
-				logger.Info("sender recived done signal")
-				if collections := collector.Export(); len(collections) > 0 {
-					jobCh <- collections
-				}
-				return
-			}
+	// sending is not interrupted by signal, only by shutdown deadline
+	sendCtx, sendCancel := context.WithCancel(context.Background())
+	defer sendCancel()
+
+	// run workers
+	var workers sync.WaitGroup
+	for w := 1; w <= conf.RateLimit; w++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			sender.Worker(sendCtx, jobCh)
+		}()
+	}
+
+	err = collectors.Wait()
+	if err != nil {
+		logger.Error("agent component error", zap.Error(err))
+	}
+
+	// flush the last export within deadline
+	timeout := a.shutdownTimeout()
+	logger.Info("flushing metrics", zap.Duration("timeout", timeout))
+	deadline := time.AfterFunc(timeout, sendCancel)
+	defer deadline.Stop()
+	if collections := append(pending, a.exportBatch()...); len(collections) > 0 {
+		select {
+		case jobCh <- collections:
+		case <-sendCtx.Done():
+			logger.Error("shutdown deadline exceeded, last metrics are not sent")
 		}
-	}()
+	}
+	close(jobCh)
+	workers.Wait()
 
-	<-sigCh
-	close(doneCh)
-	// waiting gorutines to stop
-	// maybe need to use WaitGroup
-	time.Sleep(1 * time.Second)
-	logger.Info("shutting down")
+	return err
+}
+
+// newAPIClient creates client for single server or for destinations list,
+// or client writing to output in dry-run mode
+func newAPIClient(conf config.Agent) (sender.APIClient, error) {
+	if conf.Output != "" {
+		if conf.Output == "-" {
+			return sender.NewWriterClient(os.Stdout, conf.OutputFormat)
+		}
+		f, err := os.OpenFile(conf.Output, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, fmt.Errorf("opening output <%s> error: %w", conf.Output, err)
+		}
+		client, err := sender.NewWriterClient(f, conf.OutputFormat)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		return client, nil
+	}
+
+	opts := []sender.ClientOption{sender.WithAgentID(conf.AgentID), sender.WithToken(conf.Token)}
+	if conf.TLSEnabled() {
+		// client certificate is reloaded when its files are changed
+		certs, err := tlsconfig.NewReloader(conf.TLSCert, conf.TLSKey, conf.TLSCA)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, sender.WithTLS(certs.ClientConfig(conf.TLSServerName)))
+	}
+
+	if len(conf.Destinations) == 0 {
+		pubKey, err := readKey(conf.CryptoKey)
+		if err != nil {
+			return nil, err
+		}
+		return sender.NewAPIClient(conf.ServerAddress, conf.HashKey, pubKey, conf.ServerType, opts...)
+	}
+
+	destinations := make([]sender.Destination, 0, len(conf.Destinations))
+	for _, d := range conf.Destinations {
+		pubKey, err := readKey(d.CryptoKey)
+		if err != nil {
+			return nil, err
+		}
+		destinations = append(destinations, sender.Destination{
+			Address: d.Address,
+			Type:    d.Type,
+			HashKey: d.HashKey,
+			PubKey:  pubKey,
+		})
+	}
+	return sender.NewMultiClient(conf.DestinationsMode, destinations, opts...)
+}
+
+// readKey reads key file if path is set
+func readKey(path string) ([]byte, error) {
+	if path == "" {
+		return nil, nil
+	}
+	key, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading keyfile <%s> error: %w", path, err)
+	}
+	return key, nil
 }
 
 func printTags() {
@@ -3,13 +3,15 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
+	"flag"
 	"fmt"
 	"log"
-	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"reflect"
 	"syscall"
 	"time"
 
@@ -18,10 +20,14 @@ import (
 	_ "github.com/golang-migrate/migrate/v4/source/file"
 	"go.uber.org/zap"
 
+	"github.com/SerjRamone/metrius/internal/auth"
 	"github.com/SerjRamone/metrius/internal/config"
 	"github.com/SerjRamone/metrius/internal/handlers"
+	"github.com/SerjRamone/metrius/internal/middlewares"
 	"github.com/SerjRamone/metrius/internal/server"
 	"github.com/SerjRamone/metrius/internal/storage"
+	"github.com/SerjRamone/metrius/internal/tenant"
+	"github.com/SerjRamone/metrius/internal/tlsconfig"
 	"github.com/SerjRamone/metrius/pkg/logger"
 )
 
@@ -32,18 +38,29 @@ var (
 )
 
 func main() {
+	conf, err := config.NewServer()
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatal("config parse error: ", err)
+	}
+	if conf.PrintConfig {
+		if err = conf.Print(os.Stdout); err != nil {
+			log.Fatal("config print error: ", err)
+		}
+		return
+	}
+
 	printTags()
 
-	if err := run(); err != nil {
+	if err := run(conf); err != nil {
 		log.Fatal(err)
 	}
 }
 
-func run() error {
-	conf, err := config.NewServer()
-	if err != nil {
-		return err
-	}
+func run(conf config.Server) error {
+	var err error
 
 	flagLogLevel := "info"
 	if err = logger.Init(flagLogLevel); err != nil {
@@ -77,25 +94,25 @@ func run() error {
 		backuper = storage.NewFileBackuper(backupFile)
 
 		// init MemStorage
-		stor = storage.NewMemStorage(conf.StoreInterval, backuper)
+		stor = storage.NewMemStorage(int(time.Duration(conf.StoreInterval).Seconds()), backuper)
 	}
 
-	var privKey []byte
-	if conf.CryptoKey != "" {
-		privKey, err = os.ReadFile(conf.CryptoKey)
-		if err != nil {
-			logger.Error("reading keyfile error", zap.Error(err))
-			return err
-		}
+	// nonces of signed requests are remembered across reloads
+	guard := middlewares.NewReplayGuard(time.Duration(conf.SignMaxSkew), conf.NonceCacheSize, conf.SignLegacy)
+	security, err := newSecurity(conf, guard)
+	if err != nil {
+		return err
 	}
+	// sign keys, private key, IP filter and tokens are replaced on SIGHUP
+	sec := middlewares.NewSecurityHolder(security)
 
-	var trustedSubnet *net.IPNet
-	if conf.TrustedSubnet != "" {
-		_, trustedSubnet, err = net.ParseCIDR(conf.TrustedSubnet)
-		if err != nil {
-			logger.Error("invalid CIDR subnet string format", zap.String("subnet", conf.TrustedSubnet), zap.Error(err))
+	var certs *tlsconfig.Reloader
+	var tlsConf *tls.Config
+	if conf.TLSCert != "" {
+		if certs, err = tlsconfig.NewReloader(conf.TLSCert, conf.TLSKey, conf.TLSClientCA); err != nil {
 			return err
 		}
+		tlsConf = certs.ServerConfig()
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
@@ -103,12 +120,20 @@ func run() error {
 	// catch signals
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
+	hupCh := make(chan os.Signal, 1)
+	signal.Notify(hupCh, syscall.SIGHUP)
+
+	// quotas of tenants are checked on writes, backups and closing use storage itself
+	served := stor
+	if quotas := newQuotas(conf); quotas != nil {
+		served = storage.NewQuotaStorage(stor, quotas)
+	}
 
 	var serv server.Server
 	if conf.Type == "http" {
-		serv = server.NewHTTPServer(conf.Address, handlers.Router(stor, conf.HashKey, privKey, trustedSubnet))
+		serv = server.NewHTTPServer(conf.Address, handlers.Router(served, sec), tlsConf)
 	} else if conf.Type == "grpc" {
-		serv = server.NewGRPCServer(conf.Address, stor)
+		serv = server.NewGRPCServer(conf.Address, served, sec, tlsConf)
 	} else {
 		logger.Error("invalid server type", zap.String("type", conf.Type))
 		cancel()
@@ -126,8 +151,8 @@ func run() error {
 	if conf.StoreInterval != 0 {
 		if v, ok := stor.(storage.MemStorage); ok {
 			go func() {
-				logger.Info("backuper started", zap.Int("StoreInterval", conf.StoreInterval))
-				ticker := time.NewTicker(time.Duration(conf.StoreInterval) * time.Second)
+				logger.Info("backuper started", zap.Stringer("StoreInterval", conf.StoreInterval))
+				ticker := time.NewTicker(time.Duration(conf.StoreInterval))
 				for {
 					<-ticker.C
 
@@ -148,46 +173,148 @@ func run() error {
 	}()
 
 	// waiting signals or context done
-	select {
-	case <-sigCh:
-		logger.Info("shutting down")
-
-		timeout := 3 * time.Second
-		shutdownCtx, cancel := context.WithTimeout(ctx, timeout)
-		defer cancel()
-
-		if err := serv.Down(shutdownCtx); err != nil {
-			logger.Error("server shutting down error", zap.Error(err))
-		} else {
-			logger.Info("server shut down gracefully")
-		}
+	for {
+		select {
+		case <-hupCh:
+			logger.Info("SIGHUP recived, reloading config")
+			conf = reload(conf, sec, guard)
+			if certs != nil {
+				if err := certs.Reload(); err != nil {
+					logger.Error("TLS certificates reload error, keeping current ones", zap.Error(err))
+				}
+			}
+		case <-sigCh:
+			logger.Info("shutting down")
 
-		// backup metrics
-		if v, ok := stor.(storage.MemStorage); ok {
-			if err := v.Backup(shutdownCtx); err != nil {
-				logger.Error("backup error", zap.Error(err))
+			timeout := 3 * time.Second
+			shutdownCtx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+
+			if err := serv.Down(shutdownCtx); err != nil {
+				logger.Error("server shutting down error", zap.Error(err))
+			} else {
+				logger.Info("server shut down gracefully")
 			}
-		}
 
-		// close resources
-		if backupFile != nil {
-			if err := backupFile.Close(); err != nil {
-				logger.Error("backup file close error", zap.Error(err))
+			// backup metrics
+			if v, ok := stor.(storage.MemStorage); ok {
+				if err := v.Backup(shutdownCtx); err != nil {
+					logger.Error("backup error", zap.Error(err))
+				}
 			}
-		}
 
-		if v, ok := stor.(storage.SQLStorage); ok {
-			if err := v.DBClose(); err != nil {
-				logger.Error("db closing error", zap.Error(err))
+			// close resources
+			if backupFile != nil {
+				if err := backupFile.Close(); err != nil {
+					logger.Error("backup file close error", zap.Error(err))
+				}
 			}
+
+			if v, ok := stor.(storage.SQLStorage); ok {
+				if err := v.DBClose(); err != nil {
+					logger.Error("db closing error", zap.Error(err))
+				}
+			}
+			return nil
+
+		case <-ctx.Done():
+			logger.Error("context error", zap.Error(ctx.Err()))
+			return ctx.Err()
 		}
+	}
+}
+
+// newSecurity reads private key and validates security settings of conf
+func newSecurity(conf config.Server, guard *middlewares.ReplayGuard) (middlewares.Security, error) {
+	var privKey []byte
+	if conf.CryptoKey != "" {
+		var err error
+		privKey, err = os.ReadFile(conf.CryptoKey)
+		if err != nil {
+			return middlewares.Security{}, fmt.Errorf("reading keyfile <%s> error: %w", conf.CryptoKey, err)
+		}
+	}
+	ipFilter, err := middlewares.NewIPFilter(conf.TrustedSubnet, conf.DeniedSubnets, conf.TrustedProxies)
+	if err != nil {
+		return middlewares.Security{}, err
+	}
+	s, err := middlewares.NewSecurity(conf.HashKey, privKey, ipFilter)
+	if err != nil {
+		return s, err
+	}
+	s.Replay = guard
+	s.SignEnforce = conf.SignEnforce
 
-	case <-ctx.Done():
-		logger.Error("context error", zap.Error(ctx.Err()))
-		return ctx.Err()
+	if len(conf.AgentKeys) > 0 {
+		keys := make(map[string][]middlewares.AgentKey, len(conf.AgentKeys))
+		for _, k := range conf.AgentKeys {
+			keys[k.Agent] = append(keys[k.Agent], middlewares.AgentKey{Key: k.Key, NotBefore: k.NotBefore, NotAfter: k.NotAfter})
+		}
+		if s.Keys, err = middlewares.NewKeyStore(keys); err != nil {
+			return s, err
+		}
 	}
 
-	return nil
+	tokens := make([]auth.Token, 0, len(conf.Tokens))
+	for _, t := range conf.Tokens {
+		tokens = append(tokens, auth.Token{Token: t.Token, Subject: t.Subject, Tenant: t.Tenant, Scopes: t.Scopes})
+	}
+	jwtKey, err := readFile(conf.JWTPublicKey)
+	if err != nil {
+		return s, err
+	}
+	if s.Auth, err = auth.NewAuthenticator(tokens, conf.JWTSecret, jwtKey); err != nil {
+		return s, err
+	}
+	return s, nil
+}
+
+// newQuotas returns quotas of tenants, it returns nil if nothing is limited
+func newQuotas(conf config.Server) *tenant.Quotas {
+	quotas := make(map[string]tenant.Quota, len(conf.TenantQuotas))
+	for _, q := range conf.TenantQuotas {
+		quotas[q.Tenant] = tenant.Quota{MaxSeries: q.MaxSeries, Rate: q.Rate, Burst: q.Burst}
+	}
+	return tenant.NewQuotas(tenant.Quota{MaxSeries: conf.TenantMaxSeries, Rate: conf.TenantRate, Burst: conf.TenantBurst}, quotas)
+}
+
+// readFile reads file if path is set
+func readFile(path string) ([]byte, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading file <%s> error: %w", path, err)
+	}
+	return data, nil
+}
+
+// reload reads config again and replaces sign keys, private key, IP filter and tokens.
+// Invalid config is not applied, other fields are applied on restart only.
+func reload(conf config.Server, sec *middlewares.SecurityHolder, guard *middlewares.ReplayGuard) config.Server {
+	next, err := conf.Reload()
+	if err != nil {
+		logger.Error("config reload error, keeping current config", zap.Error(err))
+		return conf
+	}
+	security, err := newSecurity(next, guard)
+	if err != nil {
+		logger.Error("config validation error, keeping current config", zap.Error(err))
+		return conf
+	}
+	sec.Store(security)
+
+	applied := conf
+	applied.HashKey, applied.CryptoKey, applied.TrustedSubnet = next.HashKey, next.CryptoKey, next.TrustedSubnet
+	applied.DeniedSubnets, applied.TrustedProxies = next.DeniedSubnets, next.TrustedProxies
+	applied.Tokens, applied.JWTSecret, applied.JWTPublicKey = next.Tokens, next.JWTSecret, next.JWTPublicKey
+	applied.SignEnforce, applied.AgentKeys = next.SignEnforce, next.AgentKeys
+	if !reflect.DeepEqual(applied, next) {
+		logger.Warn("config changes except keys, subnets, trusted proxies, tokens and SignEnforce require restart")
+	}
+	logger.Info("config reloaded", zap.Object("config", &applied))
+	return applied
 }
 
 // runPgMigrations runs Postgres migrations
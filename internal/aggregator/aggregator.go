@@ -0,0 +1,123 @@
+// Package aggregator collapses collections polled during report interval
+// into a single collection before sending
+package aggregator
+
+import (
+	"fmt"
+
+	"github.com/SerjRamone/metrius/internal/metrics"
+)
+
+// Aggregation modes for gauges
+const (
+	Last = "last"
+	Min  = "min"
+	Max  = "max"
+	Avg  = "avg"
+)
+
+// aggregator collapses gauges by configured mode and sums counters
+type aggregator struct {
+	defaultMode string
+	modes       map[string]string
+}
+
+// New creates aggregator. defaultMode is applied to gauges without own mode in modes.
+func New(defaultMode string, modes map[string]string) (*aggregator, error) {
+	if defaultMode == "" {
+		defaultMode = Last
+	}
+	if !validMode(defaultMode) {
+		return nil, fmt.Errorf("unknown aggregation mode: %s", defaultMode)
+	}
+	for name, mode := range modes {
+		if !validMode(mode) {
+			return nil, fmt.Errorf("unknown aggregation mode for <%s>: %s", name, mode)
+		}
+	}
+	return &aggregator{
+		defaultMode: defaultMode,
+		modes:       modes,
+	}, nil
+}
+
+// validMode checks gauge aggregation mode
+func validMode(mode string) bool {
+	switch mode {
+	case Last, Min, Max, Avg:
+		return true
+	}
+	return false
+}
+
+// state stores intermediate aggregation values of one metrics
+type state struct {
+	item  metrics.CollectionItem
+	min   float64
+	max   float64
+	sum   float64
+	count int
+}
+
+// Aggregate collapses collections into one collection.
+// Counters are summed, gauges are reduced by mode.
+// Order of metrics is the order of their first appearance.
+func (a *aggregator) Aggregate(collections []metrics.Collection) []metrics.Collection {
+	type key struct{ name, mType string }
+	states := map[key]*state{}
+	order := make([]key, 0, 64)
+
+	for _, c := range collections {
+		for _, item := range c {
+			k := key{item.Name, item.Type}
+			s, ok := states[k]
+			if !ok {
+				s = &state{item: item, min: item.Value, max: item.Value}
+				states[k] = s
+				order = append(order, k)
+			} else {
+				s.min = min(s.min, item.Value)
+				s.max = max(s.max, item.Value)
+			}
+			s.sum += item.Value
+			s.count++
+			// the last value
+			s.item.Value = item.Value
+		}
+	}
+
+	if len(order) == 0 {
+		return nil
+	}
+
+	result := make(metrics.Collection, 0, len(order))
+	for _, k := range order {
+		s := states[k]
+		item := s.item
+		if item.Type == "counter" {
+			item.Value = s.sum
+		} else {
+			item.Value = a.reduce(s)
+		}
+		result = append(result, item)
+	}
+	return []metrics.Collection{result}
+}
+
+// reduce returns gauge value by mode
+func (a *aggregator) reduce(s *state) float64 {
+	mode, ok := a.modes[s.item.Name]
+	if !ok {
+		mode = a.defaultMode
+	}
+	switch mode {
+	case Min:
+		return s.min
+	case Max:
+		return s.max
+	case Avg:
+		return s.sum / float64(s.count)
+	default:
+		return s.item.Value
+	}
+}
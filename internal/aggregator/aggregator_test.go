@@ -0,0 +1,67 @@
+package aggregator
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/SerjRamone/metrius/internal/metrics"
+)
+
+func TestAggregate(t *testing.T) {
+	collections := []metrics.Collection{
+		{
+			{Name: "Alloc", Type: "gauge", Value: 10},
+			{Name: "RandomValue", Type: "gauge", Value: 0.2},
+			{Name: "HeapIdle", Type: "gauge", Value: 5},
+			{Name: "PollCount", Type: "counter", Value: 1},
+		},
+		{
+			{Name: "Alloc", Type: "gauge", Value: 30},
+			{Name: "RandomValue", Type: "gauge", Value: 0.4},
+			{Name: "HeapIdle", Type: "gauge", Value: 1},
+			{Name: "PollCount", Type: "counter", Value: 1},
+		},
+		{
+			{Name: "Alloc", Type: "gauge", Value: 20},
+			{Name: "RandomValue", Type: "gauge", Value: 0.9},
+			{Name: "HeapIdle", Type: "gauge", Value: 3},
+			{Name: "PollCount", Type: "counter", Value: 1},
+			{Name: "Requests", Type: "counter", Value: 5},
+		},
+	}
+
+	a, err := New(Last, map[string]string{"RandomValue": Max, "HeapIdle": Min})
+	require.NoError(t, err)
+
+	assert.Equal(t, []metrics.Collection{{
+		{Name: "Alloc", Type: "gauge", Value: 20},
+		{Name: "RandomValue", Type: "gauge", Value: 0.9},
+		{Name: "HeapIdle", Type: "gauge", Value: 1},
+		{Name: "PollCount", Type: "counter", Value: 3},
+		{Name: "Requests", Type: "counter", Value: 5},
+	}}, a.Aggregate(collections))
+
+	a, err = New(Avg, nil)
+	require.NoError(t, err)
+	result := a.Aggregate(collections)
+	require.Len(t, result, 1)
+	assert.Equal(t, metrics.CollectionItem{Name: "Alloc", Type: "gauge", Value: 20}, result[0][0])
+	assert.Equal(t, float64(3), result[0][3].Value)
+}
+
+func TestAggregate_Empty(t *testing.T) {
+	a, err := New("", nil)
+	require.NoError(t, err)
+	assert.Nil(t, a.Aggregate(nil))
+	assert.Nil(t, a.Aggregate([]metrics.Collection{{}}))
+}
+
+func TestNew_InvalidMode(t *testing.T) {
+	_, err := New("median", nil)
+	assert.Error(t, err)
+
+	_, err = New(Last, map[string]string{"Alloc": "sum"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,213 @@
+// Package auth authenticates clients by bearer tokens and checks their scopes.
+//
+// Tokens are static tokens from server config or JWTs signed with HMAC secret
+// or with private key, which public key is known to server. Scope of JWT is
+// a space-separated "scope" claim, tenant of JWT is a "tenant" claim.
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/SerjRamone/metrius/internal/tenant"
+)
+
+// scopes of clients, admin scope grants all scopes
+const (
+	ScopeRead  = "read"
+	ScopeWrite = "write"
+	ScopeAdmin = "admin"
+)
+
+// errors of authentication
+var (
+	ErrNoToken      = errors.New("bearer token required")
+	ErrInvalidToken = errors.New("invalid bearer token")
+	ErrForbidden    = errors.New("insufficient scope")
+)
+
+// jwtLeeway is an allowed clock skew of JWT time claims
+const jwtLeeway = time.Minute
+
+// Principal is an authenticated client bound to tenant
+type Principal struct {
+	Subject string
+	Tenant  string
+	Scopes  []string
+}
+
+// Has returns true if principal has scope
+func (p Principal) Has(scope string) bool {
+	return slices.Contains(p.Scopes, scope) || slices.Contains(p.Scopes, ScopeAdmin)
+}
+
+// Token is a static token with its principal, empty tenant means the default tenant
+type Token struct {
+	Token   string
+	Subject string
+	Tenant  string
+	Scopes  []string
+}
+
+// Authenticator checks bearer tokens
+type Authenticator struct {
+	// tokens are kept by hash, so lookup time doesn't depend on token bytes
+	tokens map[[sha256.Size]byte]Principal
+	secret []byte
+	pubKey crypto.PublicKey
+}
+
+// NewAuthenticator creates authenticator of static tokens and JWTs signed with secret or
+// private key of PEM-encoded public key. It returns nil if nothing is set, auth is disabled then.
+func NewAuthenticator(tokens []Token, secret string, pubKey []byte) (*Authenticator, error) {
+	if len(tokens) == 0 && secret == "" && len(pubKey) == 0 {
+		return nil, nil
+	}
+	a := &Authenticator{
+		tokens: make(map[[sha256.Size]byte]Principal, len(tokens)),
+		secret: []byte(secret),
+	}
+	for _, t := range tokens {
+		if t.Token == "" {
+			return nil, errors.New("static token is empty, subject: " + t.Subject)
+		}
+		if err := ValidateScopes(t.Scopes); err != nil {
+			return nil, err
+		}
+		p := Principal{Subject: t.Subject, Tenant: tenantOrDefault(t.Tenant), Scopes: t.Scopes}
+		if err := tenant.Validate(p.Tenant); err != nil {
+			return nil, err
+		}
+		a.tokens[sha256.Sum256([]byte(t.Token))] = p
+	}
+	if len(pubKey) > 0 {
+		block, _ := pem.Decode(pubKey)
+		if block == nil {
+			return nil, errors.New("JWT public key is not PEM-encoded")
+		}
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("parsing JWT public key error: %w", err)
+		}
+		a.pubKey = key
+	}
+	return a, nil
+}
+
+// ValidateScopes checks that scopes are known
+func ValidateScopes(scopes []string) error {
+	if len(scopes) == 0 {
+		return errors.New("scopes are empty")
+	}
+	for _, s := range scopes {
+		if s != ScopeRead && s != ScopeWrite && s != ScopeAdmin {
+			return fmt.Errorf("unknown scope <%s>", s)
+		}
+	}
+	return nil
+}
+
+// Authenticate returns principal of token, static tokens are checked first
+func (a *Authenticator) Authenticate(token string) (Principal, error) {
+	if token == "" {
+		return Principal{}, ErrNoToken
+	}
+	if p, ok := a.tokens[sha256.Sum256([]byte(token))]; ok {
+		return p, nil
+	}
+	if len(a.secret) == 0 && a.pubKey == nil {
+		return Principal{}, ErrInvalidToken
+	}
+
+	var c claims
+	_, err := jwt.ParseWithClaims(token, &c, a.key,
+		jwt.WithValidMethods([]string{"HS256", "HS384", "HS512", "RS256", "RS384", "RS512", "ES256", "ES384", "ES512", "EdDSA"}),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(jwtLeeway),
+	)
+	if err != nil {
+		return Principal{}, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+	scopes := strings.Fields(c.Scope)
+	if err = ValidateScopes(scopes); err != nil {
+		return Principal{}, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+	t := tenantOrDefault(c.Tenant)
+	if err = tenant.Validate(t); err != nil {
+		return Principal{}, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+	return Principal{Subject: c.Subject, Tenant: t, Scopes: scopes}, nil
+}
+
+// Authorize authenticates token and checks that principal has scope
+func (a *Authenticator) Authorize(token, scope string) (Principal, error) {
+	p, err := a.Authenticate(token)
+	if err != nil {
+		return p, err
+	}
+	if !p.Has(scope) {
+		return p, ErrForbidden
+	}
+	return p, nil
+}
+
+// key returns key of JWT by its algorithm, HMAC tokens can't be verified by public key and vice versa
+func (a *Authenticator) key(t *jwt.Token) (any, error) {
+	if _, ok := t.Method.(*jwt.SigningMethodHMAC); ok {
+		if len(a.secret) == 0 {
+			return nil, errors.New("HMAC tokens are not accepted")
+		}
+		return a.secret, nil
+	}
+	if a.pubKey == nil {
+		return nil, errors.New("asymmetric tokens are not accepted")
+	}
+	return a.pubKey, nil
+}
+
+// claims of JWT
+type claims struct {
+	jwt.RegisteredClaims
+	Scope  string `json:"scope"`
+	Tenant string `json:"tenant"`
+}
+
+// tenantOrDefault returns the default tenant if t is empty
+func tenantOrDefault(t string) string {
+	if t == "" {
+		return tenant.Default
+	}
+	return t
+}
+
+// BearerToken returns token of Authorization header value
+func BearerToken(header string) string {
+	const prefix = "Bearer "
+	if len(header) < len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+		return ""
+	}
+	return strings.TrimSpace(header[len(prefix):])
+}
+
+type principalKey struct{}
+
+// WithPrincipal returns context with principal and its tenant
+func WithPrincipal(ctx context.Context, p Principal) context.Context {
+	return tenant.NewContext(context.WithValue(ctx, principalKey{}, p), p.Tenant)
+}
+
+// FromContext returns principal of context
+func FromContext(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(Principal)
+	return p, ok
+}
@@ -0,0 +1,161 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/SerjRamone/metrius/internal/tenant"
+)
+
+func TestAuthenticator_Authorize(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	require.NoError(t, err)
+	pubKey := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+
+	a, err := NewAuthenticator([]Token{
+		{Token: "reader-token", Subject: "dashboard", Scopes: []string{ScopeRead}},
+		{Token: "admin-token", Subject: "ops", Scopes: []string{ScopeAdmin}},
+		{Token: "team-token", Subject: "team", Tenant: "team-b", Scopes: []string{ScopeRead}},
+	}, "jwt-secret", pubKey)
+	require.NoError(t, err)
+
+	sign := func(method jwt.SigningMethod, key any, claims jwt.MapClaims) string {
+		token, err := jwt.NewWithClaims(method, claims).SignedString(key)
+		require.NoError(t, err)
+		return token
+	}
+	exp := time.Now().Add(time.Hour).Unix()
+
+	tests := []struct {
+		name    string
+		token   string
+		scope   string
+		wantErr error
+		subject string
+		tenant  string
+	}{
+		{name: "Test#1. Static token", token: "reader-token", scope: ScopeRead, subject: "dashboard"},
+		{name: "Test#2. Static token without scope", token: "reader-token", scope: ScopeWrite, wantErr: ErrForbidden},
+		{name: "Test#3. Admin token", token: "admin-token", scope: ScopeWrite, subject: "ops"},
+		{name: "Test#4. Unknown token", token: "unknown", scope: ScopeRead, wantErr: ErrInvalidToken},
+		{name: "Test#5. No token", token: "", scope: ScopeRead, wantErr: ErrNoToken},
+		{
+			name:    "Test#6. HMAC JWT",
+			token:   sign(jwt.SigningMethodHS256, []byte("jwt-secret"), jwt.MapClaims{"sub": "agent-1", "scope": "read write", "exp": exp}),
+			scope:   ScopeWrite,
+			subject: "agent-1",
+		},
+		{
+			name:    "Test#7. ECDSA JWT",
+			token:   sign(jwt.SigningMethodES256, key, jwt.MapClaims{"sub": "agent-2", "scope": "write", "exp": exp}),
+			scope:   ScopeWrite,
+			subject: "agent-2",
+		},
+		{
+			name:    "Test#8. JWT with other secret",
+			token:   sign(jwt.SigningMethodHS256, []byte("other"), jwt.MapClaims{"scope": "write", "exp": exp}),
+			scope:   ScopeWrite,
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name:    "Test#9. Expired JWT",
+			token:   sign(jwt.SigningMethodHS256, []byte("jwt-secret"), jwt.MapClaims{"scope": "write", "exp": time.Now().Add(-time.Hour).Unix()}),
+			scope:   ScopeWrite,
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name:    "Test#10. JWT without expiration",
+			token:   sign(jwt.SigningMethodHS256, []byte("jwt-secret"), jwt.MapClaims{"scope": "write"}),
+			scope:   ScopeWrite,
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name:    "Test#11. JWT with unknown scope",
+			token:   sign(jwt.SigningMethodHS256, []byte("jwt-secret"), jwt.MapClaims{"scope": "root", "exp": exp}),
+			scope:   ScopeWrite,
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name:    "Test#12. JWT without scope",
+			token:   sign(jwt.SigningMethodHS256, []byte("jwt-secret"), jwt.MapClaims{"scope": "read", "exp": exp}),
+			scope:   ScopeWrite,
+			wantErr: ErrForbidden,
+		},
+		{
+			name:    "Test#13. JWT of tenant",
+			token:   sign(jwt.SigningMethodHS256, []byte("jwt-secret"), jwt.MapClaims{"sub": "agent-3", "tenant": "team-a", "scope": "write", "exp": exp}),
+			scope:   ScopeWrite,
+			subject: "agent-3",
+			tenant:  "team-a",
+		},
+		{
+			name:    "Test#14. JWT of invalid tenant",
+			token:   sign(jwt.SigningMethodHS256, []byte("jwt-secret"), jwt.MapClaims{"tenant": "team a", "scope": "write", "exp": exp}),
+			scope:   ScopeWrite,
+			wantErr: ErrInvalidToken,
+		},
+		{name: "Test#15. Static token of tenant", token: "team-token", scope: ScopeRead, subject: "team", tenant: "team-b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := a.Authorize(tt.token, tt.scope)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.subject, p.Subject)
+			if tt.tenant == "" {
+				tt.tenant = tenant.Default
+			}
+			assert.Equal(t, tt.tenant, p.Tenant)
+		})
+	}
+}
+
+func TestAuthenticator_KeyConfusion(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	require.NoError(t, err)
+	pubKey := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+	a, err := NewAuthenticator(nil, "", pubKey)
+	require.NoError(t, err)
+
+	// HMAC token signed with public key must not be accepted
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"scope": "admin", "exp": time.Now().Add(time.Hour).Unix()}).SignedString(pubKey)
+	require.NoError(t, err)
+	_, err = a.Authenticate(token)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+}
+
+func TestNewAuthenticator(t *testing.T) {
+	a, err := NewAuthenticator(nil, "", nil)
+	assert.NoError(t, err)
+	assert.Nil(t, a)
+
+	_, err = NewAuthenticator([]Token{{Token: "t", Scopes: []string{"root"}}}, "", nil)
+	assert.Error(t, err)
+	_, err = NewAuthenticator([]Token{{Token: "", Scopes: []string{ScopeRead}}}, "", nil)
+	assert.Error(t, err)
+	_, err = NewAuthenticator(nil, "", []byte("not a key"))
+	assert.Error(t, err)
+}
+
+func TestBearerToken(t *testing.T) {
+	assert.Equal(t, "abc", BearerToken("Bearer abc"))
+	assert.Equal(t, "abc", BearerToken("bearer abc"))
+	assert.Equal(t, "", BearerToken("Basic abc"))
+	assert.Equal(t, "", BearerToken(""))
+}
@@ -0,0 +1,259 @@
+package collector
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/SerjRamone/metrius/internal/metrics"
+)
+
+// default mount point of cgroup v2 unified hierarchy
+const cgroupDefaultRoot = "/sys/fs/cgroup"
+
+// cgroupCollector reads resource usage and limits of cgroup v2
+type cgroupCollector struct {
+	dir string
+}
+
+// NewCgroup creates cgroup v2 collector.
+// If path is empty, cgroup of the current process is used.
+// Relative path is treated as a cgroup name inside root.
+func NewCgroup(root, path string) (*cgroupCollector, error) {
+	if root == "" {
+		root = cgroupDefaultRoot
+	}
+
+	if path == "" {
+		own, err := ownCgroup("/proc/self/cgroup")
+		if err != nil {
+			return nil, err
+		}
+		path = own
+	}
+
+	dir := path
+	if !strings.HasPrefix(path, root) {
+		dir = filepath.Join(root, path)
+	}
+
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, fmt.Errorf("cgroup directory <%s> error: %w", dir, err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("cgroup path <%s> is not a directory", dir)
+	}
+
+	return &cgroupCollector{dir: dir}, nil
+}
+
+// ownCgroup returns cgroup v2 path of process from /proc/<pid>/cgroup file
+func ownCgroup(procFile string) (string, error) {
+	data, err := os.ReadFile(procFile)
+	if err != nil {
+		return "", fmt.Errorf("reading <%s> error: %w", procFile, err)
+	}
+
+	// cgroup v2 entry has format "0::/path"
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		if path, ok := strings.CutPrefix(scanner.Text(), "0::"); ok {
+			return path, nil
+		}
+	}
+
+	return "", errors.New("cgroup v2 entry not found")
+}
+
+// Collect reads cgroup files and returns collection of metrics.
+// Files of disabled controllers are skipped.
+func (c *cgroupCollector) Collect() (metrics.Collection, error) {
+	collection := make(metrics.Collection, 0, 16)
+	add := func(name string, value float64) {
+		collection = append(collection, metrics.CollectionItem{Name: name, Type: "gauge", Value: value})
+	}
+
+	// memory
+	memCurrent, memOK, err := c.readValue("memory.current")
+	if err != nil {
+		return nil, err
+	}
+	if memOK {
+		add("CgroupMemoryCurrent", memCurrent)
+	}
+	memMax, memLimited, err := c.readValue("memory.max")
+	if err != nil {
+		return nil, err
+	}
+	if memLimited {
+		add("CgroupMemoryMax", memMax)
+		if memOK && memMax > 0 {
+			add("CgroupMemoryUtilization", memCurrent/memMax*100)
+		}
+	}
+
+	// cpu
+	cpuStat, err := c.readKeyValues("cpu.stat")
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range [][2]string{
+		{"usage_usec", "CgroupCPUUsageUsec"},
+		{"user_usec", "CgroupCPUUserUsec"},
+		{"system_usec", "CgroupCPUSystemUsec"},
+		{"nr_throttled", "CgroupCPUNrThrottled"},
+		{"throttled_usec", "CgroupCPUThrottledUsec"},
+	} {
+		if v, ok := cpuStat[f[0]]; ok {
+			add(f[1], v)
+		}
+	}
+	cpuLimit, cpuLimited, err := c.readCPULimit()
+	if err != nil {
+		return nil, err
+	}
+	if cpuLimited {
+		add("CgroupCPULimit", cpuLimit)
+	}
+
+	// io, summed over all devices
+	ioStat, ok, err := c.readIOStat()
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		add("CgroupIOReadBytes", ioStat["rbytes"])
+		add("CgroupIOWriteBytes", ioStat["wbytes"])
+		add("CgroupIOReadOps", ioStat["rios"])
+		add("CgroupIOWriteOps", ioStat["wios"])
+	}
+
+	// pids
+	pidsCurrent, ok, err := c.readValue("pids.current")
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		add("CgroupPidsCurrent", pidsCurrent)
+	}
+	pidsMax, ok, err := c.readValue("pids.max")
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		add("CgroupPidsMax", pidsMax)
+	}
+
+	return collection, nil
+}
+
+// readFile returns file content; ok is false if file does not exist
+func (c *cgroupCollector) readFile(name string) (data []byte, ok bool, err error) {
+	data, err = os.ReadFile(filepath.Join(c.dir, name))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, false, nil
+		}
+		return nil, false, fmt.Errorf("reading cgroup file <%s> error: %w", name, err)
+	}
+	return data, true, nil
+}
+
+// readValue reads single value file. ok is false if file does not exist
+// or value is "max" (no limit)
+func (c *cgroupCollector) readValue(name string) (float64, bool, error) {
+	data, ok, err := c.readFile(name)
+	if err != nil || !ok {
+		return 0, false, err
+	}
+	s := strings.TrimSpace(string(data))
+	if s == "max" {
+		return 0, false, nil
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("parsing cgroup file <%s> error: %w", name, err)
+	}
+	return v, true, nil
+}
+
+// readKeyValues reads flat keyed file with "key value" lines
+func (c *cgroupCollector) readKeyValues(name string) (map[string]float64, error) {
+	result := map[string]float64{}
+	data, ok, err := c.readFile(name)
+	if err != nil || !ok {
+		return result, err
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		v, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("parsing cgroup file <%s> error: %w", name, err)
+		}
+		result[fields[0]] = v
+	}
+	return result, nil
+}
+
+// readCPULimit reads cpu.max file with "$MAX $PERIOD" format
+// and returns limit in number of CPUs
+func (c *cgroupCollector) readCPULimit() (float64, bool, error) {
+	data, ok, err := c.readFile("cpu.max")
+	if err != nil || !ok {
+		return 0, false, err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) != 2 || fields[0] == "max" {
+		return 0, false, nil
+	}
+	quota, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("parsing cgroup file <cpu.max> error: %w", err)
+	}
+	period, err := strconv.ParseFloat(fields[1], 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("parsing cgroup file <cpu.max> error: %w", err)
+	}
+	if period == 0 {
+		return 0, false, nil
+	}
+	return quota / period, true, nil
+}
+
+// readIOStat reads io.stat file with lines like
+// "8:0 rbytes=1 wbytes=2 rios=3 wios=4 dbytes=0 dios=0"
+// and sums values of all devices
+func (c *cgroupCollector) readIOStat() (map[string]float64, bool, error) {
+	data, ok, err := c.readFile("io.stat")
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	result := map[string]float64{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		// skip device id
+		for _, f := range fields[min(1, len(fields)):] {
+			key, value, found := strings.Cut(f, "=")
+			if !found {
+				continue
+			}
+			v, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return nil, false, fmt.Errorf("parsing cgroup file <io.stat> error: %w", err)
+			}
+			result[key] += v
+		}
+	}
+	return result, true, nil
+}
@@ -0,0 +1,112 @@
+package collector
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/SerjRamone/metrius/internal/metrics"
+)
+
+// writeCgroupFiles creates fake cgroupfs directory
+func writeCgroupFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	require.NoError(t, os.MkdirAll(dir, 0o755))
+	for name, content := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644))
+	}
+}
+
+// toMap converts collection to name-value map
+func toMap(c metrics.Collection) map[string]float64 {
+	result := map[string]float64{}
+	for _, item := range c {
+		result[item.Name] = item.Value
+	}
+	return result
+}
+
+func TestCgroup_Collect(t *testing.T) {
+	root := t.TempDir()
+	writeCgroupFiles(t, filepath.Join(root, "agent.slice"), map[string]string{
+		"memory.current": "268435456\n",
+		"memory.max":     "536870912\n",
+		"cpu.stat":       "usage_usec 1000\nuser_usec 600\nsystem_usec 400\nnr_periods 10\nnr_throttled 2\nthrottled_usec 50\n",
+		"cpu.max":        "150000 100000\n",
+		"io.stat":        "8:0 rbytes=100 wbytes=200 rios=1 wios=2 dbytes=0 dios=0\n8:16 rbytes=10 wbytes=20 rios=3 wios=4 dbytes=0 dios=0\n",
+		"pids.current":   "7\n",
+		"pids.max":       "max\n",
+	})
+
+	cg, err := NewCgroup(root, "/agent.slice")
+	require.NoError(t, err)
+
+	c, err := cg.Collect()
+	require.NoError(t, err)
+
+	m := toMap(c)
+	assert.Equal(t, float64(268435456), m["CgroupMemoryCurrent"])
+	assert.Equal(t, float64(536870912), m["CgroupMemoryMax"])
+	assert.Equal(t, float64(50), m["CgroupMemoryUtilization"])
+	assert.Equal(t, float64(1000), m["CgroupCPUUsageUsec"])
+	assert.Equal(t, float64(2), m["CgroupCPUNrThrottled"])
+	assert.Equal(t, 1.5, m["CgroupCPULimit"])
+	assert.Equal(t, float64(110), m["CgroupIOReadBytes"])
+	assert.Equal(t, float64(220), m["CgroupIOWriteBytes"])
+	assert.Equal(t, float64(7), m["CgroupPidsCurrent"])
+	// unlimited values are not reported
+	assert.NotContains(t, m, "CgroupPidsMax")
+
+	for _, item := range c {
+		assert.Equal(t, "gauge", item.Type)
+	}
+}
+
+func TestCgroup_CollectWithoutControllers(t *testing.T) {
+	root := t.TempDir()
+	writeCgroupFiles(t, root, map[string]string{
+		"memory.current": "1024",
+		"memory.max":     "max",
+	})
+
+	cg, err := NewCgroup(root, root)
+	require.NoError(t, err)
+
+	c, err := cg.Collect()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]float64{"CgroupMemoryCurrent": 1024}, toMap(c))
+}
+
+func TestCgroup_InvalidValue(t *testing.T) {
+	root := t.TempDir()
+	writeCgroupFiles(t, root, map[string]string{
+		"memory.current": "invalid",
+	})
+
+	cg, err := NewCgroup(root, root)
+	require.NoError(t, err)
+
+	_, err = cg.Collect()
+	assert.Error(t, err)
+}
+
+func TestCgroup_NotExists(t *testing.T) {
+	_, err := NewCgroup(t.TempDir(), "/not/exists")
+	assert.Error(t, err)
+}
+
+func TestOwnCgroup(t *testing.T) {
+	f := filepath.Join(t.TempDir(), "cgroup")
+	require.NoError(t, os.WriteFile(f, []byte("0::/system.slice/agent.service\n"), 0o644))
+
+	path, err := ownCgroup(f)
+	require.NoError(t, err)
+	assert.Equal(t, "/system.slice/agent.service", path)
+
+	require.NoError(t, os.WriteFile(f, []byte("12:memory:/docker/abc\n"), 0o644))
+	_, err = ownCgroup(f)
+	assert.Error(t, err)
+}
@@ -3,13 +3,20 @@ package collector
 
 import (
 	"runtime"
+	"sync"
 
 	"github.com/SerjRamone/metrius/internal/metrics"
 	"github.com/SerjRamone/metrius/pkg/logger"
 )
 
+// Source is an additional source of metrics
+type Source interface {
+	Collect() (metrics.Collection, error)
+}
+
 // collector collect and store metrics
 type collector struct {
+	mu          sync.Mutex
 	collections []metrics.Collection
 }
 
@@ -28,12 +35,24 @@ func (c *collector) Collect() {
 
 	// add metrics to collection
 	collection := metrics.NewCollection(memStat)
-	c.collections = append(c.collections, collection)
+	c.Add(collection)
 	logger.Info("metrics added")
 }
 
+// Add puts collection from another source to collector
+func (c *collector) Add(collection metrics.Collection) {
+	if len(collection) == 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.collections = append(c.collections, collection)
+}
+
 // Export returns collections and clear slice
 func (c *collector) Export() []metrics.Collection {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	collections := c.collections
 	c.collections = make([]metrics.Collection, 0, 5)
 	return collections
@@ -0,0 +1,200 @@
+package collector
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/SerjRamone/metrius/internal/metrics"
+	"github.com/SerjRamone/metrius/pkg/logger"
+)
+
+const (
+	execDefaultInterval = 10 * time.Second
+	execDefaultTimeout  = 5 * time.Second
+)
+
+// ExecDefaultConcurrency is a default max number of simultaneously running commands
+const ExecDefaultConcurrency = 2
+
+// ExecCommand describes external command which stdout is parsed into metrics
+type ExecCommand struct {
+	Name     string
+	Command  []string
+	Interval time.Duration
+	Timeout  time.Duration
+}
+
+// execCollector runs external commands and parses their output
+type execCollector struct {
+	commands []ExecCommand
+	// limits number of simultaneously running commands
+	sem      chan struct{}
+	errors   atomic.Int64
+	timeouts atomic.Int64
+}
+
+// NewExec creates collector for external commands, commands are copied
+func NewExec(commands []ExecCommand, concurrency int) (*execCollector, error) {
+	if concurrency < 1 {
+		concurrency = ExecDefaultConcurrency
+	}
+	commands = append([]ExecCommand(nil), commands...)
+	for i, cmd := range commands {
+		if len(cmd.Command) == 0 {
+			return nil, fmt.Errorf("exec command <%s>: command is empty", cmd.Name)
+		}
+		if cmd.Name == "" {
+			commands[i].Name = cmd.Command[0]
+		}
+		if cmd.Interval <= 0 {
+			commands[i].Interval = execDefaultInterval
+		}
+		if cmd.Timeout <= 0 {
+			commands[i].Timeout = execDefaultTimeout
+		}
+	}
+	return &execCollector{
+		commands: commands,
+		sem:      make(chan struct{}, concurrency),
+	}, nil
+}
+
+// Run runs every command on its own interval until ctx is done.
+// Parsed metrics are passed to add.
+func (c *execCollector) Run(ctx context.Context, add func(metrics.Collection)) {
+	var wg sync.WaitGroup
+	for _, cmd := range c.commands {
+		wg.Add(1)
+		go func(cmd ExecCommand) {
+			defer wg.Done()
+			ticker := time.NewTicker(cmd.Interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					collection, err := c.run(ctx, cmd)
+					if err != nil {
+						logger.Error("exec command error", zap.String("name", cmd.Name), zap.Error(err))
+						continue
+					}
+					add(collection)
+				case <-ctx.Done():
+					return
+				}
+			}
+		}(cmd)
+	}
+	wg.Wait()
+}
+
+// run executes command once and parses its output
+func (c *execCollector) run(ctx context.Context, cmd ExecCommand) (metrics.Collection, error) {
+	// wait for free slot
+	select {
+	case c.sem <- struct{}{}:
+		defer func() { <-c.sem }()
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	cmdCtx, cancel := context.WithTimeout(ctx, cmd.Timeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	command := exec.CommandContext(cmdCtx, cmd.Command[0], cmd.Command[1:]...)
+	command.Stdout = &stdout
+	command.Stderr = &stderr
+
+	if err := command.Run(); err != nil {
+		if errors.Is(cmdCtx.Err(), context.DeadlineExceeded) {
+			c.timeouts.Add(1)
+			return nil, fmt.Errorf("timeout %s exceeded: %w", cmd.Timeout, err)
+		}
+		c.errors.Add(1)
+		return nil, fmt.Errorf("run error: %w, stderr: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	collection, err := parseExecOutput(stdout.Bytes())
+	if err != nil {
+		c.errors.Add(1)
+		return nil, fmt.Errorf("parse output error: %w", err)
+	}
+	return collection, nil
+}
+
+// Collect returns error counters increments since the previous call, zero increments are skipped
+func (c *execCollector) Collect() (metrics.Collection, error) {
+	var collection metrics.Collection
+	if n := c.errors.Swap(0); n > 0 {
+		collection = append(collection, metrics.CollectionItem{Name: "ExecErrors", Type: "counter", Value: float64(n)})
+	}
+	if n := c.timeouts.Swap(0); n > 0 {
+		collection = append(collection, metrics.CollectionItem{Name: "ExecTimeouts", Type: "counter", Value: float64(n)})
+	}
+	return collection, nil
+}
+
+// parseExecOutput parses command output. Supported formats:
+//   - JSON object or array in metrics.Metrics format
+//   - "name type value" lines, empty lines and lines started with # are skipped
+//
+// Counter values must be integers.
+func parseExecOutput(out []byte) (metrics.Collection, error) {
+	out = bytes.TrimSpace(out)
+	if len(out) == 0 {
+		return nil, nil
+	}
+
+	switch out[0] {
+	case '[':
+		var batch []metrics.Metrics
+		if err := json.Unmarshal(out, &batch); err != nil {
+			return nil, fmt.Errorf("unmarshal error: %w", err)
+		}
+		return metrics.CollectionFromMetrics(batch)
+	case '{':
+		var m metrics.Metrics
+		if err := json.Unmarshal(out, &m); err != nil {
+			return nil, fmt.Errorf("unmarshal error: %w", err)
+		}
+		return metrics.CollectionFromMetrics([]metrics.Metrics{m})
+	}
+
+	var collection metrics.Collection
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("line %d: expected \"name type value\", received: %q", line, text)
+		}
+		if fields[1] != "gauge" && fields[1] != "counter" {
+			return nil, fmt.Errorf("line %d: unknown metrics type: %s", line, fields[1])
+		}
+		v, err := strconv.ParseFloat(fields[2], 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid value: %w", line, err)
+		}
+		if fields[1] == "counter" && (v != math.Trunc(v) || math.IsInf(v, 0)) {
+			return nil, fmt.Errorf("line %d: counter value must be integer, received: %s", line, fields[2])
+		}
+		collection = append(collection, metrics.CollectionItem{Name: fields[0], Type: fields[1], Value: v})
+	}
+	return collection, scanner.Err()
+}
@@ -0,0 +1,122 @@
+package collector
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/SerjRamone/metrius/internal/metrics"
+)
+
+func TestParseExecOutput(t *testing.T) {
+	testCases := []struct {
+		name    string
+		output  string
+		want    metrics.Collection
+		wantErr bool
+	}{
+		{
+			name:   "Test#1. Lines",
+			output: "# comment\nQueueSize gauge 12.5\n\nJobsDone counter 3\n",
+			want: metrics.Collection{
+				{Name: "QueueSize", Type: "gauge", Value: 12.5},
+				{Name: "JobsDone", Type: "counter", Value: 3},
+			},
+		},
+		{
+			name:   "Test#2. JSON object",
+			output: `{"id":"QueueSize","type":"gauge","value":1.5}`,
+			want:   metrics.Collection{{Name: "QueueSize", Type: "gauge", Value: 1.5}},
+		},
+		{
+			name:   "Test#3. JSON array",
+			output: `[{"id":"QueueSize","type":"gauge","value":1},{"id":"JobsDone","type":"counter","delta":7}]`,
+			want: metrics.Collection{
+				{Name: "QueueSize", Type: "gauge", Value: 1},
+				{Name: "JobsDone", Type: "counter", Value: 7},
+			},
+		},
+		{
+			name:   "Test#4. Empty output",
+			output: "\n",
+		},
+		{
+			name:    "Test#5. Unknown type",
+			output:  "QueueSize histogram 1",
+			wantErr: true,
+		},
+		{
+			name:    "Test#6. Invalid value",
+			output:  "QueueSize gauge abc",
+			wantErr: true,
+		},
+		{
+			name:    "Test#7. JSON without value",
+			output:  `{"id":"JobsDone","type":"counter"}`,
+			wantErr: true,
+		},
+		{
+			name:    "Test#8. Fractional counter",
+			output:  "JobsDone counter 3.7",
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := parseExecOutput([]byte(tc.output))
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestExec_Run(t *testing.T) {
+	e, err := NewExec([]ExecCommand{
+		{Name: "ok", Command: []string{"echo", "QueueSize gauge 42"}},
+		{Name: "fail", Command: []string{"false"}},
+		{Name: "slow", Command: []string{"sleep", "5"}, Timeout: 50 * time.Millisecond},
+	}, 3)
+	require.NoError(t, err)
+
+	collection, err := e.run(context.Background(), e.commands[0])
+	require.NoError(t, err)
+	assert.Equal(t, metrics.Collection{{Name: "QueueSize", Type: "gauge", Value: 42}}, collection)
+
+	_, err = e.run(context.Background(), e.commands[1])
+	assert.Error(t, err)
+	_, err = e.run(context.Background(), e.commands[2])
+	assert.Error(t, err)
+
+	counters, err := e.Collect()
+	require.NoError(t, err)
+	assert.Equal(t, metrics.Collection{
+		{Name: "ExecErrors", Type: "counter", Value: 1},
+		{Name: "ExecTimeouts", Type: "counter", Value: 1},
+	}, counters)
+
+	// counters are reset after collect, zero counters are not sent
+	counters, err = e.Collect()
+	require.NoError(t, err)
+	assert.Empty(t, counters)
+}
+
+func TestNewExec(t *testing.T) {
+	_, err := NewExec([]ExecCommand{{Name: "empty"}}, 1)
+	assert.Error(t, err)
+
+	// defaults are set on copy of commands
+	commands := []ExecCommand{{Command: []string{"true"}}}
+	e, err := NewExec(commands, 0)
+	require.NoError(t, err)
+	assert.Equal(t, ExecCommand{Command: []string{"true"}}, commands[0])
+	assert.Equal(t, "true", e.commands[0].Name)
+	assert.Equal(t, ExecDefaultConcurrency, cap(e.sem))
+}
@@ -1,320 +1,771 @@
-// Package config ...
+// Package config parses agent and server configs.
+//
+// Values are taken in order of precedence: command line flags, environment variables,
+// config file, defaults. Config file is JSON, YAML or TOML by its extension,
+// unknown keys are errors. Intervals are set as duration strings, f.e. "1m30s",
+// or as numbers of seconds.
 package config
 
 import (
-	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"net"
 	"os"
-	"strconv"
+	"reflect"
 	"strings"
+	"time"
 
-	"github.com/caarlos0/env"
 	"go.uber.org/zap/zapcore"
 )
 
 const (
-	agentDefaultServerAddress  = "localhost:8080"
-	agentDefaultReportInterval = 10
-	agentDefaultPollInterval   = 2
-	agentDefaultHashKey        = ""
-	agentDefaultRateLimit      = 1
-	agentDefaultCryptoKey      = ""
-	agentDefaultConfig         = ""
-	agentDefaultServerType     = "http"
-
-	agentUsageServerAddress  = "address and port of metrics server"
-	agentUsageReportInterval = "period of time for sending data to server in seconds"
-	agentUsagePollInterval   = "period of time for collecting metrics values in seconds"
-	agentUsageHashKey        = "key string for hashing function"
-	agentUsageRateLimit      = "number of synchronous outgoing requests"
-	agentUsageCryptoKey      = "path to the public key file"
-	agentUsageConfig         = "path to config.json file"
-	agentUsageServerType     = "type of server (HTTP/gRPC)"
+	agentDefaultServerAddress    = "localhost:8080"
+	agentDefaultReportInterval   = Duration(10 * time.Second)
+	agentDefaultPollInterval     = Duration(2 * time.Second)
+	agentDefaultHashKey          = ""
+	agentDefaultAgentID          = ""
+	agentDefaultRateLimit        = 1
+	agentDefaultCryptoKey        = ""
+	agentDefaultConfig           = ""
+	agentDefaultPrintConfig      = false
+	agentDefaultServerType       = "http"
+	agentDefaultCgroup           = false
+	agentDefaultCgroupPath       = ""
+	agentDefaultExecConcurrency  = 2
+	agentDefaultPushAddress      = ""
+	agentDefaultPushSocket       = ""
+	agentDefaultAggregate        = false
+	agentDefaultAggregateMode    = "last"
+	agentDefaultSpoolDir         = ""
+	agentDefaultSpoolMaxSize     = 64 << 20
+	agentDefaultSpoolMaxAge      = Duration(24 * time.Hour)
+	agentDefaultDestinationsMode = "fanout"
+	agentDefaultShutdownTimeout  = Duration(10 * time.Second)
+	agentDefaultOutput           = ""
+	agentDefaultOutputFormat     = "json"
+	agentDefaultRequestsPerSec   = 0
+	agentDefaultBytesPerSec      = 0
+	agentDefaultMaxPayloadSize   = 0
+	agentDefaultConfigWatch      = Duration(0)
+	agentDefaultTLS              = false
+	agentDefaultTLSCA            = ""
+	agentDefaultTLSCert          = ""
+	agentDefaultTLSKey           = ""
+	agentDefaultTLSServerName    = ""
+	agentDefaultToken            = ""
+
+	agentUsageServerAddress    = "address and port of metrics server"
+	agentUsageReportInterval   = "period of time for sending data to server, f.e. 10s or number of seconds"
+	agentUsagePollInterval     = "period of time for collecting metrics values, f.e. 2s or number of seconds"
+	agentUsageHashKey          = "key string for hashing function"
+	agentUsageAgentID          = "agent ID sent with signed requests, server checks sign with key of this ID"
+	agentUsageRateLimit        = "number of synchronous outgoing requests"
+	agentUsageCryptoKey        = "path to the public key file"
+	agentUsageConfig           = "path to config file (JSON, YAML or TOML by extension)"
+	agentUsagePrintConfig      = "print effective config with secrets redacted and exit"
+	agentUsageServerType       = "type of server (HTTP/gRPC)"
+	agentUsageCgroup           = "collect cgroup v2 resource metrics"
+	agentUsageCgroupPath       = "path to cgroup v2 directory (default: cgroup of agent process)"
+	agentUsageExecConcurrency  = "max number of simultaneously running exec commands"
+	agentUsagePushAddress      = "address and port for receiving metrics from local applications, f.e. localhost:8081"
+	agentUsagePushSocket       = "path to Unix socket for receiving metrics from local applications"
+	agentUsageAggregate        = "aggregate collections polled during report interval before sending"
+	agentUsageAggregateMode    = "default aggregation mode for gauges (last/min/max/avg)"
+	agentUsageSpoolDir         = "directory for storing batches failed to send (disabled if empty)"
+	agentUsageSpoolMaxSize     = "max size of spooled batches in bytes"
+	agentUsageSpoolMaxAge      = "max age of spooled batches, f.e. 24h or number of seconds"
+	agentUsageDestinationsMode = "mode of sending to destinations from config file (fanout/failover)"
+	agentUsageShutdownTimeout  = "max time for sending the last metrics on shutdown, f.e. 10s or number of seconds"
+	agentUsageOutput           = "write metrics to file instead of sending to server, \"-\" for stdout"
+	agentUsageOutputFormat     = "format of output (json/prometheus/influx)"
+	agentUsageRequestsPerSec   = "max number of requests per second for all workers (0 - no limit)"
+	agentUsageBytesPerSec      = "max number of bytes of metrics JSON sent per second for all workers (0 - no limit)"
+	agentUsageMaxPayloadSize   = "max size of metrics JSON in one request in bytes, batches are split (0 - no limit)"
+	agentUsageConfigWatch      = "period of checking config file changes, f.e. 5s (0 - reload on SIGHUP only)"
+	agentUsageTLS              = "connect to server by TLS, it's enabled if any TLS file is set"
+	agentUsageTLSCA            = "path to CA bundle verifying server certificate (default: system roots)"
+	agentUsageTLSCert          = "path to client certificate for mutual TLS"
+	agentUsageTLSKey           = "path to client certificate key for mutual TLS"
+	agentUsageTLSServerName    = "server name expected in server certificate (default: host of server address)"
+	agentUsageToken            = "bearer token sent to server, it needs write scope"
 
 	serverDefaultAddress         = "localhost:8080"
-	serverDefaultStoreInterval   = 300
+	serverDefaultStoreInterval   = Duration(300 * time.Second)
 	serverDefaultFileStoragePath = "/tmp/metrics-db.json"
 	serverDefaultRestore         = true
 	serverDefaultDatabaseDSN     = ""
 	serverDefaultHashKey         = ""
 	serverDefaultCryptoKey       = ""
 	serverDefaultConfig          = ""
+	serverDefaultPrintConfig     = false
 	serverDefaultTrustedSubnet   = ""
+	serverDefaultDeniedSubnets   = ""
+	serverDefaultTrustedProxies  = ""
 	serverDefaultType            = "http"
+	serverDefaultSignMaxSkew     = Duration(5 * time.Minute)
+	serverDefaultSignLegacy      = false
+	serverDefaultNonceCacheSize  = 0
+	serverDefaultSignEnforce     = false
+	serverDefaultTLSCert         = ""
+	serverDefaultTLSKey          = ""
+	serverDefaultTLSClientCA     = ""
+	serverDefaultJWTSecret       = ""
+	serverDefaultJWTPublicKey    = ""
+	serverDefaultTenantMaxSeries = 0
+	serverDefaultTenantRate      = 0
+	serverDefaultTenantBurst     = 0
 
 	serverUsageAddress         = "address and port to run server"
-	serverUsageStoreInterval   = "period of time for put metrics to file"
+	serverUsageStoreInterval   = "period of time for put metrics to file, f.e. 300s or number of seconds (0 - synchronous)"
 	serverUsageFileStoragePath = "path to file for store metrics"
 	serverUsageRestore         = "if true then server will resotre metrics from file storage"
 	serverUsageDatabaseDSN     = "data sourse string in format: \"host=host port=port user=myuser password=xxxx dbname=mydb sslmode=disable\""
 	serverUsageHashKey         = "key string for hashing function"
 	serverUsageCryptoKey       = "path to the private key file"
-	serverUsageConfig          = "path to config.json file"
-	serverUsageTrustedSubnet   = "CIDR"
+	serverUsageConfig          = "path to config file (JSON, YAML or TOML by extension)"
+	serverUsagePrintConfig     = "print effective config with secrets redacted and exit"
+	serverUsageTrustedSubnet   = "comma-separated IPv4 or IPv6 CIDRs of allowed agents (default: any)"
+	serverUsageDeniedSubnets   = "comma-separated IPv4 or IPv6 CIDRs of denied agents"
+	serverUsageTrustedProxies  = "comma-separated CIDRs of proxies whose X-Real-IP and X-Forwarded-For headers are trusted"
 	serverUsageType            = "type of server (HTTP/gRPC)"
+	serverUsageSignMaxSkew     = "max difference between signed request timestamp and server time"
+	serverUsageSignLegacy      = "accept requests signed without timestamp and nonce, they can be replayed"
+	serverUsageNonceCacheSize  = "max number of remembered nonces of signed requests (0 - scaled to sign max skew)"
+	serverUsageSignEnforce     = "reject requests without sign"
+	serverUsageTLSCert         = "path to TLS certificate, server uses TLS if it is set"
+	serverUsageTLSKey          = "path to TLS certificate key"
+	serverUsageTLSClientCA     = "path to CA bundle verifying client certificates, clients must present certificate if it is set"
+	serverUsageJWTSecret       = "HMAC secret verifying bearer JWTs"
+	serverUsageJWTPublicKey    = "path to PEM public key verifying bearer JWTs (RSA, ECDSA or Ed25519)"
+	serverUsageTenantMaxSeries = "max number of series of every tenant (0 - no limit)"
+	serverUsageTenantRate      = "max number of metrics ingested by every tenant per second (0 - no limit)"
+	serverUsageTenantBurst     = "max number of metrics ingested by tenant at once, bigger batches are rejected (default: rate rounded up)"
 )
 
 var errTypeAssert = errors.New("type assesrtion error")
 
 // Agent contents config for Agent
 type Agent struct {
-	ServerAddress  string `env:"ADDRESS" json:"address"`
-	HashKey        string `env:"KEY"`
-	CryptoKey      string `env:"CRYPTO_KEY" json:"crypto_key"`
-	ReportInterval int    `env:"REPORT_INTERVAL" json:"report_interval"`
-	PollInterval   int    `env:"POLL_INTERVAL" json:"poll_interval"`
-	RateLimit      int    `env:"RATE_LIMIT"`
-	Config         string `env:"CONFIG"`
-	ServerType     string `env:"SERVER_TYPE"`
+	ServerAddress  string   `env:"ADDRESS" json:"address" yaml:"address" toml:"address"`
+	HashKey        string   `env:"KEY" json:"hash_key" yaml:"hash_key" toml:"hash_key"`
+	AgentID        string   `env:"AGENT_ID" json:"agent_id" yaml:"agent_id" toml:"agent_id"`
+	CryptoKey      string   `env:"CRYPTO_KEY" json:"crypto_key" yaml:"crypto_key" toml:"crypto_key"`
+	ReportInterval Duration `env:"REPORT_INTERVAL" json:"report_interval" yaml:"report_interval" toml:"report_interval"`
+	PollInterval   Duration `env:"POLL_INTERVAL" json:"poll_interval" yaml:"poll_interval" toml:"poll_interval"`
+	RateLimit      int      `env:"RATE_LIMIT" json:"rate_limit" yaml:"rate_limit" toml:"rate_limit"`
+	// Config is a path to config file, it can't be set in config file
+	Config     string `env:"CONFIG" json:"-" yaml:"-" toml:"-"`
+	ServerType string `env:"SERVER_TYPE" json:"server_type" yaml:"server_type" toml:"server_type"`
+	CgroupPath string `env:"CGROUP_PATH" json:"cgroup_path" yaml:"cgroup_path" toml:"cgroup_path"`
+	Cgroup     bool   `env:"CGROUP" json:"cgroup" yaml:"cgroup" toml:"cgroup"`
+	// Exec is a list of external commands, can be set in config file only
+	Exec            []ExecCommand `json:"exec" yaml:"exec" toml:"exec"`
+	ExecConcurrency int           `env:"EXEC_CONCURRENCY" json:"exec_concurrency" yaml:"exec_concurrency" toml:"exec_concurrency"`
+	PushAddress     string        `env:"PUSH_ADDRESS" json:"push_address" yaml:"push_address" toml:"push_address"`
+	PushSocket      string        `env:"PUSH_SOCKET" json:"push_socket" yaml:"push_socket" toml:"push_socket"`
+	AggregateMode   string        `env:"AGGREGATE_MODE" json:"aggregate_mode" yaml:"aggregate_mode" toml:"aggregate_mode"`
+	Aggregate       bool          `env:"AGGREGATE" json:"aggregate" yaml:"aggregate" toml:"aggregate"`
+	// Aggregations is a gauge name to aggregation mode map, can be set in config file only
+	Aggregations map[string]string `json:"aggregations" yaml:"aggregations" toml:"aggregations"`
+	SpoolDir     string            `env:"SPOOL_DIR" json:"spool_dir" yaml:"spool_dir" toml:"spool_dir"`
+	SpoolMaxSize int64             `env:"SPOOL_MAX_SIZE" json:"spool_max_size" yaml:"spool_max_size" toml:"spool_max_size"`
+	SpoolMaxAge  Duration          `env:"SPOOL_MAX_AGE" json:"spool_max_age" yaml:"spool_max_age" toml:"spool_max_age"`
+	// Destinations is a list of servers, can be set in config file only.
+	// ServerAddress, ServerType, HashKey and CryptoKey are ignored if it is set.
+	Destinations     []Destination `json:"destinations" yaml:"destinations" toml:"destinations"`
+	DestinationsMode string        `env:"DESTINATIONS_MODE" json:"destinations_mode" yaml:"destinations_mode" toml:"destinations_mode"`
+	ShutdownTimeout  Duration      `env:"SHUTDOWN_TIMEOUT" json:"shutdown_timeout" yaml:"shutdown_timeout" toml:"shutdown_timeout"`
+	// Output is a file for writing metrics instead of sending (dry-run mode)
+	Output       string `env:"OUTPUT" json:"output" yaml:"output" toml:"output"`
+	OutputFormat string `env:"OUTPUT_FORMAT" json:"output_format" yaml:"output_format" toml:"output_format"`
+	// Relabel is a list of rules applied to metrics before sending, can be set in config file only
+	Relabel        []RelabelRule `json:"relabel" yaml:"relabel" toml:"relabel"`
+	RequestsPerSec float64       `env:"REQUESTS_PER_SEC" json:"requests_per_sec" yaml:"requests_per_sec" toml:"requests_per_sec"`
+	BytesPerSec    int           `env:"BYTES_PER_SEC" json:"bytes_per_sec" yaml:"bytes_per_sec" toml:"bytes_per_sec"`
+	MaxPayloadSize int           `env:"MAX_PAYLOAD_SIZE" json:"max_payload_size" yaml:"max_payload_size" toml:"max_payload_size"`
+	// ConfigWatch is a period of checking config file changes, 0 - reload on SIGHUP only
+	ConfigWatch Duration `env:"CONFIG_WATCH" json:"config_watch" yaml:"config_watch" toml:"config_watch"`
+	// TLS settings are applied to all destinations, certificates are reloaded when files are changed
+	TLS           bool   `env:"TLS" json:"tls" yaml:"tls" toml:"tls"`
+	TLSCA         string `env:"TLS_CA" json:"tls_ca" yaml:"tls_ca" toml:"tls_ca"`
+	TLSCert       string `env:"TLS_CERT" json:"tls_cert" yaml:"tls_cert" toml:"tls_cert"`
+	TLSKey        string `env:"TLS_KEY" json:"tls_key" yaml:"tls_key" toml:"tls_key"`
+	TLSServerName string `env:"TLS_SERVER_NAME" json:"tls_server_name" yaml:"tls_server_name" toml:"tls_server_name"`
+	// Token is a bearer token of agent, it's sent to all destinations
+	Token string `env:"TOKEN" json:"token" yaml:"token" toml:"token"`
+	// PrintConfig prints effective config and exits, can be set by flag only
+	PrintConfig bool `json:"-" yaml:"-" toml:"-"`
+
+	// args are command line arguments, they are parsed again on Reload
+	args []string
+}
+
+// RelabelRule drops, keeps, renames or scales metrics with name matching regexp
+type RelabelRule struct {
+	Action      string  `json:"action" yaml:"action" toml:"action"`
+	Match       string  `json:"match" yaml:"match" toml:"match"`
+	Replacement string  `json:"replacement" yaml:"replacement" toml:"replacement"`
+	Factor      float64 `json:"factor" yaml:"factor" toml:"factor"`
+	Unit        string  `json:"unit" yaml:"unit" toml:"unit"`
+}
+
+// Destination metrics server with its own keys
+type Destination struct {
+	Address   string `json:"address" yaml:"address" toml:"address"`
+	Type      string `json:"type" yaml:"type" toml:"type"`
+	HashKey   string `json:"hash_key" yaml:"hash_key" toml:"hash_key"`
+	CryptoKey string `json:"crypto_key" yaml:"crypto_key" toml:"crypto_key"`
 }
 
-// NewAgent constructor for agent config
+// ExecCommand external command which output is parsed into metrics
+type ExecCommand struct {
+	Name     string   `json:"name" yaml:"name" toml:"name"`
+	Command  []string `json:"command" yaml:"command" toml:"command"`
+	Interval Duration `json:"interval" yaml:"interval" toml:"interval"`
+	Timeout  Duration `json:"timeout" yaml:"timeout" toml:"timeout"`
+}
+
+// NewAgent constructor for agent config, it parses command line flags,
+// environment variables and config file
 func NewAgent() (Agent, error) {
-	a := Agent{}
-	a.parseFlags()
-	if err := a.parseEnv(); err != nil {
+	return newAgent(os.Args[1:])
+}
+
+// newAgent parses config by command line arguments
+func newAgent(args []string) (Agent, error) {
+	// the first pass finds config file path set by flag or environment variable
+	a := defaultAgent()
+	if err := a.parseFlags(args, os.Stderr); err != nil {
 		return a, err
 	}
-	if a.Config != "" {
-		if err := a.parseFile(); err != nil {
+	path := a.Config
+	if path == "" {
+		path = os.Getenv("CONFIG")
+	}
+
+	a = defaultAgent()
+	a.args = args
+	if path != "" {
+		if err := decodeFile(path, &a); err != nil {
 			return a, err
 		}
 	}
-	return a, nil
+	if err := parseEnv(&a); err != nil {
+		return a, err
+	}
+	if err := a.parseFlags(args, io.Discard); err != nil {
+		return a, err
+	}
+	return a, a.validate()
+}
+
+// defaultAgent returns agent config with default values
+func defaultAgent() Agent {
+	return Agent{
+		ServerAddress:    agentDefaultServerAddress,
+		ReportInterval:   agentDefaultReportInterval,
+		PollInterval:     agentDefaultPollInterval,
+		HashKey:          agentDefaultHashKey,
+		AgentID:          agentDefaultAgentID,
+		RateLimit:        agentDefaultRateLimit,
+		CryptoKey:        agentDefaultCryptoKey,
+		Config:           agentDefaultConfig,
+		ServerType:       agentDefaultServerType,
+		Cgroup:           agentDefaultCgroup,
+		CgroupPath:       agentDefaultCgroupPath,
+		ExecConcurrency:  agentDefaultExecConcurrency,
+		PushAddress:      agentDefaultPushAddress,
+		PushSocket:       agentDefaultPushSocket,
+		Aggregate:        agentDefaultAggregate,
+		AggregateMode:    agentDefaultAggregateMode,
+		SpoolDir:         agentDefaultSpoolDir,
+		SpoolMaxSize:     agentDefaultSpoolMaxSize,
+		SpoolMaxAge:      agentDefaultSpoolMaxAge,
+		DestinationsMode: agentDefaultDestinationsMode,
+		ShutdownTimeout:  agentDefaultShutdownTimeout,
+		Output:           agentDefaultOutput,
+		OutputFormat:     agentDefaultOutputFormat,
+		RequestsPerSec:   agentDefaultRequestsPerSec,
+		BytesPerSec:      agentDefaultBytesPerSec,
+		MaxPayloadSize:   agentDefaultMaxPayloadSize,
+		ConfigWatch:      agentDefaultConfigWatch,
+		TLS:              agentDefaultTLS,
+		TLSCA:            agentDefaultTLSCA,
+		TLSCert:          agentDefaultTLSCert,
+		TLSKey:           agentDefaultTLSKey,
+		TLSServerName:    agentDefaultTLSServerName,
+		Token:            agentDefaultToken,
+		PrintConfig:      agentDefaultPrintConfig,
+	}
+}
+
+// Reload parses environment variables and config file again with the same command line flags
+func (c Agent) Reload() (Agent, error) {
+	return newAgent(c.args)
 }
 
-// parseFlags parse cli flags
-func (c *Agent) parseFlags() {
-	flag.StringVar(&c.ServerAddress, "a", agentDefaultServerAddress, agentUsageServerAddress)
-	flag.IntVar(&c.ReportInterval, "r", agentDefaultReportInterval, agentUsageReportInterval)
-	flag.IntVar(&c.PollInterval, "p", agentDefaultPollInterval, agentUsagePollInterval)
-	flag.StringVar(&c.HashKey, "k", agentDefaultHashKey, agentUsageHashKey)
-	flag.IntVar(&c.RateLimit, "l", agentDefaultRateLimit, agentUsageRateLimit)
-	flag.StringVar(&c.CryptoKey, "crypto-key", agentDefaultCryptoKey, agentUsageCryptoKey)
-	flag.StringVar(&c.CryptoKey, "c", agentDefaultConfig, agentUsageConfig)
-	flag.StringVar(&c.CryptoKey, "config", agentDefaultConfig, agentUsageConfig)
-	flag.StringVar(&c.ServerType, "server-type", agentDefaultServerType, agentUsageServerType)
-
-	flag.Parse()
+// Changed returns names of fields which have different values in other config
+func (c Agent) Changed(other Agent) []string {
+	var changed []string
+	v, o := reflect.ValueOf(c), reflect.ValueOf(other)
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		if !reflect.DeepEqual(v.Field(i).Interface(), o.Field(i).Interface()) {
+			changed = append(changed, field.Name)
+		}
+	}
+	return changed
 }
 
-// parseEnv parse environtment variables
-func (c *Agent) parseEnv() error {
-	return env.Parse(c)
+// parseFlags parse cli flags, values which are not set by flags are kept
+func (c *Agent) parseFlags(args []string, output io.Writer) error {
+	fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	fs.SetOutput(output)
+
+	fs.StringVar(&c.ServerAddress, "a", c.ServerAddress, agentUsageServerAddress)
+	fs.Var(&c.ReportInterval, "r", agentUsageReportInterval)
+	fs.Var(&c.PollInterval, "p", agentUsagePollInterval)
+	fs.StringVar(&c.HashKey, "k", c.HashKey, agentUsageHashKey)
+	fs.StringVar(&c.AgentID, "agent-id", c.AgentID, agentUsageAgentID)
+	fs.IntVar(&c.RateLimit, "l", c.RateLimit, agentUsageRateLimit)
+	fs.StringVar(&c.CryptoKey, "crypto-key", c.CryptoKey, agentUsageCryptoKey)
+	fs.StringVar(&c.Config, "c", c.Config, agentUsageConfig)
+	fs.StringVar(&c.Config, "config", c.Config, agentUsageConfig)
+	fs.BoolVar(&c.PrintConfig, "print-config", c.PrintConfig, agentUsagePrintConfig)
+	fs.StringVar(&c.ServerType, "server-type", c.ServerType, agentUsageServerType)
+	fs.BoolVar(&c.Cgroup, "cgroup", c.Cgroup, agentUsageCgroup)
+	fs.StringVar(&c.CgroupPath, "cgroup-path", c.CgroupPath, agentUsageCgroupPath)
+	fs.IntVar(&c.ExecConcurrency, "exec-concurrency", c.ExecConcurrency, agentUsageExecConcurrency)
+	fs.StringVar(&c.PushAddress, "push-address", c.PushAddress, agentUsagePushAddress)
+	fs.StringVar(&c.PushSocket, "push-socket", c.PushSocket, agentUsagePushSocket)
+	fs.BoolVar(&c.Aggregate, "aggregate", c.Aggregate, agentUsageAggregate)
+	fs.StringVar(&c.AggregateMode, "aggregate-mode", c.AggregateMode, agentUsageAggregateMode)
+	fs.StringVar(&c.SpoolDir, "spool-dir", c.SpoolDir, agentUsageSpoolDir)
+	fs.Int64Var(&c.SpoolMaxSize, "spool-max-size", c.SpoolMaxSize, agentUsageSpoolMaxSize)
+	fs.Var(&c.SpoolMaxAge, "spool-max-age", agentUsageSpoolMaxAge)
+	fs.StringVar(&c.DestinationsMode, "destinations-mode", c.DestinationsMode, agentUsageDestinationsMode)
+	fs.Var(&c.ShutdownTimeout, "shutdown-timeout", agentUsageShutdownTimeout)
+	fs.StringVar(&c.Output, "output", c.Output, agentUsageOutput)
+	fs.StringVar(&c.OutputFormat, "output-format", c.OutputFormat, agentUsageOutputFormat)
+	fs.Float64Var(&c.RequestsPerSec, "requests-per-sec", c.RequestsPerSec, agentUsageRequestsPerSec)
+	fs.IntVar(&c.BytesPerSec, "bytes-per-sec", c.BytesPerSec, agentUsageBytesPerSec)
+	fs.IntVar(&c.MaxPayloadSize, "max-payload-size", c.MaxPayloadSize, agentUsageMaxPayloadSize)
+	fs.Var(&c.ConfigWatch, "config-watch", agentUsageConfigWatch)
+	fs.BoolVar(&c.TLS, "tls", c.TLS, agentUsageTLS)
+	fs.StringVar(&c.TLSCA, "tls-ca", c.TLSCA, agentUsageTLSCA)
+	fs.StringVar(&c.TLSCert, "tls-cert", c.TLSCert, agentUsageTLSCert)
+	fs.StringVar(&c.TLSKey, "tls-key", c.TLSKey, agentUsageTLSKey)
+	fs.StringVar(&c.TLSServerName, "tls-server-name", c.TLSServerName, agentUsageTLSServerName)
+	fs.StringVar(&c.Token, "token", c.Token, agentUsageToken)
+
+	return fs.Parse(args)
 }
 
-// parseFile parse config file if path setted
-func (c *Agent) parseFile() error {
-	bytes, err := os.ReadFile(c.Config)
-	if err != nil {
-		return fmt.Errorf("reading config file <%s> error: %w", c.Config, err)
-	}
-	var tmp map[string]any
-	err = json.Unmarshal(bytes, &tmp)
-	if err != nil {
-		return fmt.Errorf("unmarshal error: %w", err)
-	}
-
-	var ok bool
-	for param, val := range tmp {
-		if param == "address" && c.ServerAddress == agentDefaultServerAddress {
-			c.ServerAddress, ok = val.(string)
-			if !ok {
-				return fmt.Errorf("%w: expected type string for ServerAddress, received: %T", errTypeAssert, val)
-			}
+// validate checks values and sets defaults of config file items
+func (c *Agent) validate() error {
+	if c.PollInterval <= 0 || c.ReportInterval <= 0 {
+		return fmt.Errorf("poll and report intervals must be positive, received: %s, %s", c.PollInterval, c.ReportInterval)
+	}
+	if c.RateLimit < 1 {
+		return fmt.Errorf("rate limit must be at least 1, received: %d", c.RateLimit)
+	}
+	if c.ShutdownTimeout < 0 || c.SpoolMaxAge < 0 || c.ConfigWatch < 0 {
+		return errors.New("shutdown timeout, spool max age and config watch period can't be negative")
+	}
+	if err := validateType(c.ServerType); err != nil {
+		return err
+	}
+	if (c.TLSCert == "") != (c.TLSKey == "") {
+		return errors.New("TLS certificate and key must be set together")
+	}
+	for i := range c.Destinations {
+		d := &c.Destinations[i]
+		if d.Address == "" {
+			return errors.New("destination address not set")
 		}
-		if param == "report_interval" && c.ReportInterval == agentDefaultReportInterval {
-			var v string
-			v, ok = val.(string)
-			if !ok {
-				return fmt.Errorf("%w: expected type string for ReportInterval, received: %T", errTypeAssert, val)
-			}
-			c.ReportInterval, err = parseInterval(v)
-			if err != nil {
-				return fmt.Errorf("parseInterval value <%s> error: %w", v, err)
-			}
+		if d.Type == "" {
+			d.Type = agentDefaultServerType
 		}
-		if param == "poll_interval" && c.PollInterval == agentDefaultPollInterval {
-			var v string
-			v, ok = val.(string)
-			if !ok {
-				return fmt.Errorf("%w: expected type string for PollInterval, received: %T", errTypeAssert, val)
-			}
-			c.PollInterval, err = parseInterval(v)
-			if err != nil {
-				return fmt.Errorf("parseInterval value <%s> error: %w", v, err)
-			}
+		if err := validateType(d.Type); err != nil {
+			return err
 		}
-		if param == "crypto_key" && c.CryptoKey == agentDefaultCryptoKey {
-			c.CryptoKey, ok = val.(string)
-			if !ok {
-				return fmt.Errorf("%w: expected type string for CryptoKey, received: %T", errTypeAssert, val)
-			}
+	}
+	// name of exec command defaults to the executable
+	for i, cmd := range c.Exec {
+		if len(cmd.Command) == 0 {
+			return fmt.Errorf("exec command %d: command not set", i)
 		}
-		if param == "server_type" && c.ServerType == agentDefaultServerType {
-			c.ServerType, ok = val.(string)
-			if !ok {
-				return fmt.Errorf("%w: expected type string for ServerType, received: %T", errTypeAssert, val)
-			}
+	}
+	for i, r := range c.Relabel {
+		if r.Match == "" {
+			return fmt.Errorf("relabel rule %d: match not set", i)
 		}
 	}
 	return nil
 }
 
+// TLSEnabled returns true if agent connects to server by TLS
+func (c Agent) TLSEnabled() bool {
+	return c.TLS || c.TLSCA != "" || c.TLSCert != ""
+}
+
+// validateType checks type of server
+func validateType(t string) error {
+	if t != "http" && t != "grpc" {
+		return fmt.Errorf("invalid server type <%s>, expected http or grpc", t)
+	}
+	return nil
+}
+
+// Print writes effective config as JSON with secrets redacted
+func (c Agent) Print(w io.Writer) error {
+	c.HashKey = redact(c.HashKey)
+	c.Token = redact(c.Token)
+	destinations := make([]Destination, 0, len(c.Destinations))
+	for _, d := range c.Destinations {
+		d.HashKey = redact(d.HashKey)
+		destinations = append(destinations, d)
+	}
+	c.Destinations = destinations
+	return printJSON(w, c)
+}
+
 // MarshalLogObject zapcore.ObjectMarshaler implemet for loggin agent config struct
 func (c *Agent) MarshalLogObject(enc zapcore.ObjectEncoder) error {
 	enc.AddString("ServerAddress", c.ServerAddress)
-	enc.AddInt("ReportInterval", c.ReportInterval)
-	enc.AddInt("PollInterval", c.PollInterval)
-	enc.AddString("HashKey", c.HashKey)
+	enc.AddString("ReportInterval", c.ReportInterval.String())
+	enc.AddString("PollInterval", c.PollInterval.String())
+	enc.AddString("HashKey", redact(c.HashKey))
+	enc.AddString("AgentID", c.AgentID)
 	enc.AddInt("RateLimit", c.RateLimit)
 	enc.AddString("CryptoKey", c.CryptoKey)
 	enc.AddString("Config", c.Config)
 	enc.AddString("ServerType", c.ServerType)
+	enc.AddBool("Cgroup", c.Cgroup)
+	enc.AddString("CgroupPath", c.CgroupPath)
+	enc.AddInt("ExecCommands", len(c.Exec))
+	enc.AddInt("ExecConcurrency", c.ExecConcurrency)
+	enc.AddString("PushAddress", c.PushAddress)
+	enc.AddString("PushSocket", c.PushSocket)
+	enc.AddBool("Aggregate", c.Aggregate)
+	enc.AddString("AggregateMode", c.AggregateMode)
+	enc.AddInt("Aggregations", len(c.Aggregations))
+	enc.AddString("SpoolDir", c.SpoolDir)
+	enc.AddInt64("SpoolMaxSize", c.SpoolMaxSize)
+	enc.AddString("SpoolMaxAge", c.SpoolMaxAge.String())
+	enc.AddInt("Destinations", len(c.Destinations))
+	enc.AddString("DestinationsMode", c.DestinationsMode)
+	enc.AddString("ShutdownTimeout", c.ShutdownTimeout.String())
+	enc.AddString("Output", c.Output)
+	enc.AddString("OutputFormat", c.OutputFormat)
+	enc.AddInt("RelabelRules", len(c.Relabel))
+	enc.AddFloat64("RequestsPerSec", c.RequestsPerSec)
+	enc.AddInt("BytesPerSec", c.BytesPerSec)
+	enc.AddInt("MaxPayloadSize", c.MaxPayloadSize)
+	enc.AddString("ConfigWatch", c.ConfigWatch.String())
+	enc.AddBool("TLS", c.TLSEnabled())
+	enc.AddString("TLSCA", c.TLSCA)
+	enc.AddString("TLSCert", c.TLSCert)
+	enc.AddString("TLSKey", c.TLSKey)
+	enc.AddString("TLSServerName", c.TLSServerName)
+	enc.AddString("Token", redact(c.Token))
 	return nil
 }
 
 // Server contents config for Server
 type Server struct {
-	Address         string `env:"ADDRESS" json:"address"`
-	FileStoragePath string `env:"FILE_STORAGE_PATH" json:"store_file"`
-	DatabaseDSN     string `env:"DATABASE_DSN" json:"database_dsn"`
-	HashKey         string `env:"KEY"`
-	CryptoKey       string `env:"CRYPTO_KEY" json:"crypto_key"`
-	StoreInterval   int    `env:"STORE_INTERVAL" json:"store_interval"`
-	Restore         bool   `env:"RESTORE" json:"restore"`
-	Config          string `env:"CONFIG"`
-	TrustedSubnet   string `env:"TRUSTED_SUBNET"`
-	Type            string `env:"TYPE"`
+	Address         string   `env:"ADDRESS" json:"address" yaml:"address" toml:"address"`
+	FileStoragePath string   `env:"FILE_STORAGE_PATH" json:"store_file" yaml:"store_file" toml:"store_file"`
+	DatabaseDSN     string   `env:"DATABASE_DSN" json:"database_dsn" yaml:"database_dsn" toml:"database_dsn"`
+	HashKey         string   `env:"KEY" json:"hash_key" yaml:"hash_key" toml:"hash_key"`
+	CryptoKey       string   `env:"CRYPTO_KEY" json:"crypto_key" yaml:"crypto_key" toml:"crypto_key"`
+	StoreInterval   Duration `env:"STORE_INTERVAL" json:"store_interval" yaml:"store_interval" toml:"store_interval"`
+	Restore         bool     `env:"RESTORE" json:"restore" yaml:"restore" toml:"restore"`
+	// Config is a path to config file, it can't be set in config file
+	Config string `env:"CONFIG" json:"-" yaml:"-" toml:"-"`
+	// TrustedSubnet, DeniedSubnets and TrustedProxies are comma-separated CIDR lists.
+	// Agent IP is the connection address, proxy headers are used only if connection comes from trusted proxy.
+	TrustedSubnet  string `env:"TRUSTED_SUBNET" json:"trusted_subnet" yaml:"trusted_subnet" toml:"trusted_subnet"`
+	DeniedSubnets  string `env:"DENIED_SUBNETS" json:"denied_subnets" yaml:"denied_subnets" toml:"denied_subnets"`
+	TrustedProxies string `env:"TRUSTED_PROXIES" json:"trusted_proxies" yaml:"trusted_proxies" toml:"trusted_proxies"`
+	Type           string `env:"TYPE" json:"type" yaml:"type" toml:"type"`
+	// SignMaxSkew, SignLegacy and NonceCacheSize configure replay protection of signed requests
+	SignMaxSkew    Duration `env:"SIGN_MAX_SKEW" json:"sign_max_skew" yaml:"sign_max_skew" toml:"sign_max_skew"`
+	SignLegacy     bool     `env:"SIGN_LEGACY" json:"sign_legacy" yaml:"sign_legacy" toml:"sign_legacy"`
+	NonceCacheSize int      `env:"NONCE_CACHE_SIZE" json:"nonce_cache_size" yaml:"nonce_cache_size" toml:"nonce_cache_size"`
+	// SignEnforce rejects requests without sign
+	SignEnforce bool `env:"SIGN_ENFORCE" json:"sign_enforce" yaml:"sign_enforce" toml:"sign_enforce"`
+	// AgentKeys are own sign keys of agents, can be set in config file only
+	AgentKeys []AgentKey `json:"agent_keys" yaml:"agent_keys" toml:"agent_keys"`
+	// TLSCert and TLSKey enable TLS for HTTP and gRPC servers, TLSClientCA enables mutual TLS.
+	// Certificates are reloaded when files are changed and on SIGHUP.
+	TLSCert     string `env:"TLS_CERT" json:"tls_cert" yaml:"tls_cert" toml:"tls_cert"`
+	TLSKey      string `env:"TLS_KEY" json:"tls_key" yaml:"tls_key" toml:"tls_key"`
+	TLSClientCA string `env:"TLS_CLIENT_CA" json:"tls_client_ca" yaml:"tls_client_ca" toml:"tls_client_ca"`
+	// Tokens are static bearer tokens, can be set in config file only.
+	// Clients must present token or JWT if any of Tokens, JWTSecret and JWTPublicKey is set.
+	Tokens       []Token `json:"tokens" yaml:"tokens" toml:"tokens"`
+	JWTSecret    string  `env:"JWT_SECRET" json:"jwt_secret" yaml:"jwt_secret" toml:"jwt_secret"`
+	JWTPublicKey string  `env:"JWT_PUBLIC_KEY" json:"jwt_public_key" yaml:"jwt_public_key" toml:"jwt_public_key"`
+	// TenantMaxSeries, TenantRate and TenantBurst are default quotas of tenants,
+	// TenantQuotas override them for particular tenants and can be set in config file only
+	TenantMaxSeries int           `env:"TENANT_MAX_SERIES" json:"tenant_max_series" yaml:"tenant_max_series" toml:"tenant_max_series"`
+	TenantRate      float64       `env:"TENANT_RATE" json:"tenant_rate" yaml:"tenant_rate" toml:"tenant_rate"`
+	TenantBurst     int           `env:"TENANT_BURST" json:"tenant_burst" yaml:"tenant_burst" toml:"tenant_burst"`
+	TenantQuotas    []TenantQuota `json:"tenant_quotas" yaml:"tenant_quotas" toml:"tenant_quotas"`
+	// PrintConfig prints effective config and exits, can be set by flag only
+	PrintConfig bool `json:"-" yaml:"-" toml:"-"`
+
+	// args are command line arguments, they are parsed again on Reload
+	args []string
+}
+
+// AgentKey is a sign key of agent valid from NotBefore till NotAfter, zero time is unbounded.
+// Several keys of agent with overlapping windows allow key rotation.
+type AgentKey struct {
+	Agent     string    `json:"agent" yaml:"agent" toml:"agent"`
+	Key       string    `json:"key" yaml:"key" toml:"key"`
+	NotBefore time.Time `json:"not_before" yaml:"not_before" toml:"not_before"`
+	NotAfter  time.Time `json:"not_after" yaml:"not_after" toml:"not_after"`
 }
 
-// NewServer constructor for server config
+// Token is a static bearer token with scopes (read/write/admin) bound to tenant, empty tenant is the default one
+type Token struct {
+	Token   string   `json:"token" yaml:"token" toml:"token"`
+	Subject string   `json:"subject" yaml:"subject" toml:"subject"`
+	Tenant  string   `json:"tenant" yaml:"tenant" toml:"tenant"`
+	Scopes  []string `json:"scopes" yaml:"scopes" toml:"scopes"`
+}
+
+// TenantQuota is a quota of tenant, zero value means no limit
+type TenantQuota struct {
+	Tenant    string  `json:"tenant" yaml:"tenant" toml:"tenant"`
+	MaxSeries int     `json:"max_series" yaml:"max_series" toml:"max_series"`
+	Rate      float64 `json:"rate" yaml:"rate" toml:"rate"`
+	Burst     int     `json:"burst" yaml:"burst" toml:"burst"`
+}
+
+// NewServer constructor for server config, it parses command line flags,
+// environment variables and config file
 func NewServer() (Server, error) {
-	s := Server{}
-	s.parseFlags()
-	if err := s.parseEnv(); err != nil {
+	return newServer(os.Args[1:])
+}
+
+// newServer parses config by command line arguments
+func newServer(args []string) (Server, error) {
+	// the first pass finds config file path set by flag or environment variable
+	s := defaultServer()
+	if err := s.parseFlags(args, os.Stderr); err != nil {
 		return s, err
 	}
-	if s.Config != "" {
-		if err := s.parseFile(); err != nil {
+	path := s.Config
+	if path == "" {
+		path = os.Getenv("CONFIG")
+	}
+
+	s = defaultServer()
+	s.args = args
+	if path != "" {
+		if err := decodeFile(path, &s); err != nil {
 			return s, err
 		}
 	}
-	return s, nil
+	if err := parseEnv(&s); err != nil {
+		return s, err
+	}
+	if err := s.parseFlags(args, io.Discard); err != nil {
+		return s, err
+	}
+	return s, s.validate()
+}
+
+// defaultServer returns server config with default values
+func defaultServer() Server {
+	return Server{
+		Address:         serverDefaultAddress,
+		StoreInterval:   serverDefaultStoreInterval,
+		FileStoragePath: serverDefaultFileStoragePath,
+		Restore:         serverDefaultRestore,
+		DatabaseDSN:     serverDefaultDatabaseDSN,
+		HashKey:         serverDefaultHashKey,
+		CryptoKey:       serverDefaultCryptoKey,
+		Config:          serverDefaultConfig,
+		TrustedSubnet:   serverDefaultTrustedSubnet,
+		DeniedSubnets:   serverDefaultDeniedSubnets,
+		TrustedProxies:  serverDefaultTrustedProxies,
+		Type:            serverDefaultType,
+		SignMaxSkew:     serverDefaultSignMaxSkew,
+		SignLegacy:      serverDefaultSignLegacy,
+		NonceCacheSize:  serverDefaultNonceCacheSize,
+		SignEnforce:     serverDefaultSignEnforce,
+		TLSCert:         serverDefaultTLSCert,
+		TLSKey:          serverDefaultTLSKey,
+		TLSClientCA:     serverDefaultTLSClientCA,
+		JWTSecret:       serverDefaultJWTSecret,
+		JWTPublicKey:    serverDefaultJWTPublicKey,
+		TenantMaxSeries: serverDefaultTenantMaxSeries,
+		TenantRate:      serverDefaultTenantRate,
+		TenantBurst:     serverDefaultTenantBurst,
+		PrintConfig:     serverDefaultPrintConfig,
+	}
 }
 
-// parseFlags parse cli flags
-func (c *Server) parseFlags() {
-	flag.StringVar(&c.Address, "a", serverDefaultAddress, serverUsageAddress)
-	flag.IntVar(&c.StoreInterval, "i", serverDefaultStoreInterval, serverUsageStoreInterval)
-	flag.StringVar(&c.FileStoragePath, "f", serverDefaultFileStoragePath, serverUsageFileStoragePath)
-	flag.BoolVar(&c.Restore, "r", serverDefaultRestore, serverUsageRestore)
-	flag.StringVar(&c.DatabaseDSN, "d", serverDefaultDatabaseDSN, serverUsageDatabaseDSN)
-	flag.StringVar(&c.HashKey, "k", serverDefaultHashKey, serverUsageHashKey)
-	flag.StringVar(&c.CryptoKey, "crypto-key", serverDefaultCryptoKey, serverUsageCryptoKey)
-	flag.StringVar(&c.Config, "c", serverDefaultConfig, serverUsageConfig)
-	flag.StringVar(&c.Config, "config", serverDefaultConfig, serverUsageConfig)
-	flag.StringVar(&c.TrustedSubnet, "t", serverDefaultTrustedSubnet, serverUsageTrustedSubnet)
-	flag.StringVar(&c.Type, "type", serverDefaultType, serverUsageType)
-
-	flag.Parse()
+// Reload parses environment variables and config file again with the same command line flags
+func (c Server) Reload() (Server, error) {
+	return newServer(c.args)
 }
 
-// parseEnv parse environtment variables
-func (c *Server) parseEnv() error {
-	return env.Parse(c)
+// parseFlags parse cli flags, values which are not set by flags are kept
+func (c *Server) parseFlags(args []string, output io.Writer) error {
+	fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	fs.SetOutput(output)
+
+	fs.StringVar(&c.Address, "a", c.Address, serverUsageAddress)
+	fs.Var(&c.StoreInterval, "i", serverUsageStoreInterval)
+	fs.StringVar(&c.FileStoragePath, "f", c.FileStoragePath, serverUsageFileStoragePath)
+	fs.BoolVar(&c.Restore, "r", c.Restore, serverUsageRestore)
+	fs.StringVar(&c.DatabaseDSN, "d", c.DatabaseDSN, serverUsageDatabaseDSN)
+	fs.StringVar(&c.HashKey, "k", c.HashKey, serverUsageHashKey)
+	fs.StringVar(&c.CryptoKey, "crypto-key", c.CryptoKey, serverUsageCryptoKey)
+	fs.StringVar(&c.Config, "c", c.Config, serverUsageConfig)
+	fs.StringVar(&c.Config, "config", c.Config, serverUsageConfig)
+	fs.BoolVar(&c.PrintConfig, "print-config", c.PrintConfig, serverUsagePrintConfig)
+	fs.StringVar(&c.TrustedSubnet, "t", c.TrustedSubnet, serverUsageTrustedSubnet)
+	fs.StringVar(&c.DeniedSubnets, "denied-subnets", c.DeniedSubnets, serverUsageDeniedSubnets)
+	fs.StringVar(&c.TrustedProxies, "trusted-proxies", c.TrustedProxies, serverUsageTrustedProxies)
+	fs.StringVar(&c.Type, "type", c.Type, serverUsageType)
+	fs.Var(&c.SignMaxSkew, "sign-max-skew", serverUsageSignMaxSkew)
+	fs.BoolVar(&c.SignLegacy, "sign-legacy", c.SignLegacy, serverUsageSignLegacy)
+	fs.IntVar(&c.NonceCacheSize, "nonce-cache-size", c.NonceCacheSize, serverUsageNonceCacheSize)
+	fs.BoolVar(&c.SignEnforce, "sign-enforce", c.SignEnforce, serverUsageSignEnforce)
+	fs.StringVar(&c.TLSCert, "tls-cert", c.TLSCert, serverUsageTLSCert)
+	fs.StringVar(&c.TLSKey, "tls-key", c.TLSKey, serverUsageTLSKey)
+	fs.StringVar(&c.TLSClientCA, "tls-client-ca", c.TLSClientCA, serverUsageTLSClientCA)
+	fs.StringVar(&c.JWTSecret, "jwt-secret", c.JWTSecret, serverUsageJWTSecret)
+	fs.StringVar(&c.JWTPublicKey, "jwt-public-key", c.JWTPublicKey, serverUsageJWTPublicKey)
+	fs.IntVar(&c.TenantMaxSeries, "tenant-max-series", c.TenantMaxSeries, serverUsageTenantMaxSeries)
+	fs.Float64Var(&c.TenantRate, "tenant-rate", c.TenantRate, serverUsageTenantRate)
+	fs.IntVar(&c.TenantBurst, "tenant-burst", c.TenantBurst, serverUsageTenantBurst)
+
+	return fs.Parse(args)
 }
 
-// parseFile parse config file if path setted
-func (c *Server) parseFile() error {
-	bytes, err := os.ReadFile(c.Config)
-	if err != nil {
-		return fmt.Errorf("reading config file <%s> error: %w", c.Config, err)
-	}
-	var tmp map[string]any
-	err = json.Unmarshal(bytes, &tmp)
-	if err != nil {
-		return fmt.Errorf("unmarshal error: %w", err)
-	}
-
-	var ok bool
-	for param, val := range tmp {
-		if param == "address" && c.Address == serverDefaultAddress {
-			c.Address, ok = val.(string)
-			if !ok {
-				return fmt.Errorf("%w: expected type string for Address, received: %T", errTypeAssert, val)
-			}
+// validate checks values
+func (c *Server) validate() error {
+	if c.StoreInterval < 0 {
+		return fmt.Errorf("store interval can't be negative, received: %s", c.StoreInterval)
+	}
+	if c.SignMaxSkew <= 0 {
+		return fmt.Errorf("sign max skew must be positive, received: %s", c.SignMaxSkew)
+	}
+	if c.NonceCacheSize < 0 {
+		return fmt.Errorf("nonce cache size can't be negative, received: %d", c.NonceCacheSize)
+	}
+	for _, k := range c.AgentKeys {
+		if k.Agent == "" || k.Key == "" {
+			return errors.New("agent key must have agent and key")
 		}
-		if param == "restore" && c.Restore { // c.Restore is true by default
-			c.Restore, ok = val.(bool)
-			if !ok {
-				return fmt.Errorf("%w: expected type bool for Restore, received: %T", errTypeAssert, val)
-			}
+	}
+	for _, t := range c.Tokens {
+		if t.Token == "" || len(t.Scopes) == 0 {
+			return errors.New("token must have token and scopes")
 		}
-		if param == "store_interval" && c.StoreInterval == serverDefaultStoreInterval {
-			var v string
-			v, ok = val.(string)
-			if !ok {
-				return fmt.Errorf("%w: expected type string for StoreInterval, received: %T", errTypeAssert, val)
-			}
-			c.StoreInterval, err = parseInterval(v)
-			if err != nil {
-				return fmt.Errorf("parseInterval value <%s> error: %w", v, err)
-			}
+	}
+	if c.TenantMaxSeries < 0 || c.TenantRate < 0 || c.TenantBurst < 0 {
+		return errors.New("tenant quotas can't be negative")
+	}
+	for _, q := range c.TenantQuotas {
+		if q.Tenant == "" {
+			return errors.New("tenant quota must have tenant")
 		}
-		if param == "store_file" && c.FileStoragePath == serverDefaultFileStoragePath {
-			c.FileStoragePath, ok = val.(string)
-			if !ok {
-				return fmt.Errorf("%w: expected type string for FileStoragePath, received: %T", errTypeAssert, val)
-			}
+		if q.MaxSeries < 0 || q.Rate < 0 || q.Burst < 0 {
+			return fmt.Errorf("quota of tenant <%s> can't be negative", q.Tenant)
 		}
-		if param == "database_dsn" && c.DatabaseDSN == serverDefaultDatabaseDSN {
-			c.DatabaseDSN, ok = val.(string)
-			if !ok {
-				return fmt.Errorf("%w: expected type string for DatabaseDSN, received: %T", errTypeAssert, val)
-			}
+	}
+	if c.SignEnforce && c.HashKey == "" && len(c.AgentKeys) == 0 {
+		return errors.New("sign is enforced, but neither hash key nor agent keys are set")
+	}
+	if (c.TLSCert == "") != (c.TLSKey == "") {
+		return errors.New("TLS certificate and key must be set together")
+	}
+	if c.TLSClientCA != "" && c.TLSCert == "" {
+		return errors.New("TLS client CA is set, but TLS certificate is not")
+	}
+	for _, list := range []string{c.TrustedSubnet, c.DeniedSubnets, c.TrustedProxies} {
+		if err := validateCIDRs(list); err != nil {
+			return err
 		}
-		if param == "crypto_key" && c.CryptoKey == serverDefaultCryptoKey {
-			c.CryptoKey, ok = val.(string)
-			if !ok {
-				return fmt.Errorf("%w: expected type string for CryptoKey, received: %T", errTypeAssert, val)
-			}
+	}
+	return validateType(c.Type)
+}
+
+// validateCIDRs checks comma-separated list of CIDRs
+func validateCIDRs(list string) error {
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
 		}
-		if param == "type" && c.Type == serverDefaultType {
-			c.Type, ok = val.(string)
-			if !ok {
-				return fmt.Errorf("%w: expected type string for Type, received: %T", errTypeAssert, val)
-			}
+		if _, _, err := net.ParseCIDR(item); err != nil {
+			return fmt.Errorf("invalid subnet <%s>: %w", item, err)
 		}
 	}
 	return nil
 }
 
+// Print writes effective config as JSON with secrets redacted
+func (c Server) Print(w io.Writer) error {
+	c.HashKey = redact(c.HashKey)
+	c.DatabaseDSN = redactDSN(c.DatabaseDSN)
+	keys := make([]AgentKey, 0, len(c.AgentKeys))
+	for _, k := range c.AgentKeys {
+		k.Key = redact(k.Key)
+		keys = append(keys, k)
+	}
+	c.AgentKeys = keys
+	tokens := make([]Token, 0, len(c.Tokens))
+	for _, t := range c.Tokens {
+		t.Token = redact(t.Token)
+		tokens = append(tokens, t)
+	}
+	c.Tokens = tokens
+	c.JWTSecret = redact(c.JWTSecret)
+	return printJSON(w, c)
+}
+
 // MarshalLogObject zapcore.ObjectMarshaler implemet for loggin server config struct
 func (c *Server) MarshalLogObject(enc zapcore.ObjectEncoder) error {
 	enc.AddString("Address", c.Address)
-	enc.AddInt("StoreInterval", c.StoreInterval)
+	enc.AddString("StoreInterval", c.StoreInterval.String())
 	enc.AddString("FileStoragePath", c.FileStoragePath)
 	enc.AddBool("Restore", c.Restore)
-	enc.AddString("DatabaseDSN", c.DatabaseDSN)
-	enc.AddString("HashKey", c.HashKey)
+	enc.AddString("DatabaseDSN", redactDSN(c.DatabaseDSN))
+	enc.AddString("HashKey", redact(c.HashKey))
 	enc.AddString("CryptoKey", c.CryptoKey)
 	enc.AddString("Config", c.Config)
 	enc.AddString("TrustedSubnet", c.TrustedSubnet)
+	enc.AddString("DeniedSubnets", c.DeniedSubnets)
+	enc.AddString("TrustedProxies", c.TrustedProxies)
 	enc.AddString("Type", c.Type)
+	enc.AddString("SignMaxSkew", c.SignMaxSkew.String())
+	enc.AddBool("SignLegacy", c.SignLegacy)
+	enc.AddInt("NonceCacheSize", c.NonceCacheSize)
+	enc.AddBool("SignEnforce", c.SignEnforce)
+	enc.AddInt("AgentKeys", len(c.AgentKeys))
+	enc.AddString("TLSCert", c.TLSCert)
+	enc.AddString("TLSKey", c.TLSKey)
+	enc.AddString("TLSClientCA", c.TLSClientCA)
+	enc.AddInt("Tokens", len(c.Tokens))
+	enc.AddString("JWTSecret", redact(c.JWTSecret))
+	enc.AddString("JWTPublicKey", c.JWTPublicKey)
+	enc.AddInt("TenantMaxSeries", c.TenantMaxSeries)
+	enc.AddFloat64("TenantRate", c.TenantRate)
+	enc.AddInt("TenantBurst", c.TenantBurst)
+	enc.AddInt("TenantQuotas", len(c.TenantQuotas))
 	return nil
 }
-
-// parseInterval parse string interval to int interval value
-func parseInterval(v string) (int, error) {
-	if strings.HasSuffix(v, "s") {
-		v, _ = strings.CutSuffix(v, "s")
-	}
-	interval, err := strconv.Atoi(v)
-	if err != nil {
-		return 0, err
-	}
-	return interval, nil
-}
@@ -0,0 +1,173 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/caarlos0/env"
+	"gopkg.in/yaml.v3"
+)
+
+// Duration is an interval which is set as duration string, f.e. "1m30s",
+// or as number of seconds, f.e. 10 or "10"
+type Duration time.Duration
+
+// parseDuration parses duration string or number of seconds
+func parseDuration(v string) (Duration, error) {
+	v = strings.TrimSpace(v)
+	if secs, err := strconv.ParseFloat(v, 64); err == nil {
+		return Duration(secs * float64(time.Second)), nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration <%s>", v)
+	}
+	return Duration(d), nil
+}
+
+// String ...
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+// Set implements flag.Value
+func (d *Duration) Set(v string) error {
+	parsed, err := parseDuration(v)
+	if err != nil {
+		return err
+	}
+	*d = parsed
+	return nil
+}
+
+// MarshalText ...
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// UnmarshalText is used for YAML, TOML and environment variables
+func (d *Duration) UnmarshalText(b []byte) error {
+	return d.Set(string(b))
+}
+
+// UnmarshalJSON accepts string or number
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var v any
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	switch v := v.(type) {
+	case string:
+		return d.Set(v)
+	case float64:
+		*d = Duration(v * float64(time.Second))
+		return nil
+	}
+	return fmt.Errorf("%w: expected duration string or number of seconds, received: %s", errTypeAssert, b)
+}
+
+// envParsers parse types unsupported by env package
+var envParsers = env.CustomParsers{
+	reflect.TypeOf(Duration(0)): func(v string) (interface{}, error) {
+		return parseDuration(v)
+	},
+}
+
+// parseEnv parses environment variables into config struct v
+func parseEnv(v any) error {
+	if err := env.ParseWithFuncs(v, envParsers); err != nil {
+		return fmt.Errorf("environment variables parse error: %w", err)
+	}
+	return nil
+}
+
+// decodeFile decodes config file into v. Format is chosen by file extension:
+// .yaml and .yml - YAML, .toml - TOML, JSON otherwise. Unknown keys are errors.
+func decodeFile(path string, v any) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading config file <%s> error: %w", path, err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err = dec.Decode(v); err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("config file <%s> YAML decode error: %w", path, err)
+		}
+	case ".toml":
+		md, err := toml.Decode(string(data), v)
+		if err != nil {
+			return fmt.Errorf("config file <%s> TOML decode error: %w", path, err)
+		}
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			keys := make([]string, 0, len(undecoded))
+			for _, k := range undecoded {
+				keys = append(keys, k.String())
+			}
+			sort.Strings(keys)
+			return fmt.Errorf("config file <%s> TOML decode error: unknown keys: %s", path, strings.Join(keys, ", "))
+		}
+	default:
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		if err = dec.Decode(v); err != nil {
+			return fmt.Errorf("config file <%s> JSON decode error: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// redacted is a placeholder of secrets in printed config
+const redacted = "<redacted>"
+
+// redact hides non-empty secret
+func redact(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return redacted
+}
+
+// redactDSN hides password in URL or key=value data source string
+func redactDSN(dsn string) string {
+	if strings.Contains(dsn, "://") {
+		if i := strings.LastIndex(dsn, "@"); i > 0 {
+			scheme := strings.Index(dsn, "://") + 3
+			if j := strings.Index(dsn[scheme:i], ":"); j >= 0 {
+				return dsn[:scheme+j+1] + redacted + dsn[i:]
+			}
+		}
+		return dsn
+	}
+	fields := strings.Fields(dsn)
+	for i, f := range fields {
+		if strings.HasPrefix(f, "password=") {
+			fields[i] = "password=" + redacted
+		}
+	}
+	return strings.Join(fields, " ")
+}
+
+// printJSON writes config as indented JSON
+func printJSON(w io.Writer, v any) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return fmt.Errorf("config encode error: %w", err)
+	}
+	return nil
+}
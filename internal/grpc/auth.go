@@ -0,0 +1,69 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/SerjRamone/metrius/internal/auth"
+	"github.com/SerjRamone/metrius/internal/middlewares"
+	"github.com/SerjRamone/metrius/pkg/logger"
+	pb "github.com/SerjRamone/metrius/pkg/metrius_v1"
+)
+
+// methodScopes are scopes required by methods, other methods require admin scope
+var methodScopes = map[string]string{
+	pb.MetricsService_Update_FullMethodName:      auth.ScopeWrite,
+	pb.MetricsService_BatchUpdate_FullMethodName: auth.ScopeWrite,
+	pb.MetricsService_GetMetrics_FullMethodName:  auth.ScopeRead,
+}
+
+// AuthInterceptor checks bearer token in "authorization" metadata and scope of method,
+// principal is put into context. Calls are passed if Security has no authenticator.
+func AuthInterceptor(h *middlewares.SecurityHolder) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		ctx, err := authorize(ctx, h.Load().Auth, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// AuthStreamInterceptor checks bearer token on stream start as AuthInterceptor
+func AuthStreamInterceptor(h *middlewares.SecurityHolder) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if _, err := authorize(ss.Context(), h.Load().Auth, info.FullMethod); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
+
+// authorize returns context with principal if token has scope of method
+func authorize(ctx context.Context, a *auth.Authenticator, method string) (context.Context, error) {
+	if a == nil {
+		return ctx, nil
+	}
+	scope, ok := methodScopes[method]
+	if !ok {
+		scope = auth.ScopeAdmin
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	p, err := a.Authorize(auth.BearerToken(first(md, "authorization")), scope)
+	if errors.Is(err, auth.ErrForbidden) {
+		logger.Warn("insufficient scope", zap.String("subject", p.Subject), zap.String("scope", scope))
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	}
+	if err != nil {
+		logger.Warn("authentication failed", zap.Error(err))
+		return nil, status.Error(codes.Unauthenticated, "Unauthorized")
+	}
+	return auth.WithPrincipal(ctx, p), nil
+}
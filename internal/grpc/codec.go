@@ -0,0 +1,142 @@
+package grpc
+
+import (
+	"context"
+	"fmt"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/SerjRamone/metrius/internal/middlewares"
+	"github.com/SerjRamone/metrius/pkg/logger"
+)
+
+var _ encoding.Codec = cryptoCodec{}
+
+// sealedField is a number of unknown field which keeps encrypted request until it is opened
+const sealedField = protowire.MaxValidNumber
+
+// cryptoCodec is a proto codec for requests sealed by middlewares.SealMessage.
+// Encrypted request is kept in message as is and is decrypted by OpenInterceptor,
+// so requests are not decrypted before client is checked. Responses are not encrypted.
+type cryptoCodec struct {
+	sec *middlewares.SecurityHolder
+}
+
+// NewCryptoCodec creates server codec, requests must be encrypted if Security has private key
+func NewCryptoCodec(sec *middlewares.SecurityHolder) encoding.Codec {
+	return cryptoCodec{sec: sec}
+}
+
+// Marshal ...
+func (c cryptoCodec) Marshal(v any) ([]byte, error) {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("can't marshal message of type %T", v)
+	}
+	return proto.Marshal(msg)
+}
+
+// Unmarshal keeps data in message unknown field if private key is set
+func (c cryptoCodec) Unmarshal(data []byte, v any) error {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("can't unmarshal message of type %T", v)
+	}
+	if c.sec.Load().PrivKey != nil {
+		raw := protowire.AppendTag(nil, sealedField, protowire.BytesType)
+		msg.ProtoReflect().SetUnknown(protowire.AppendBytes(raw, data))
+		return nil
+	}
+	return proto.Unmarshal(data, msg)
+}
+
+// Name is the name of default proto codec, so clients need no content subtype
+func (c cryptoCodec) Name() string {
+	return "proto"
+}
+
+// OpenInterceptor decrypts request kept by codec with private key of current Security
+func OpenInterceptor(h *middlewares.SecurityHolder) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if err := open(h, req); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// OpenStreamInterceptor decrypts every received message of stream as OpenInterceptor
+func OpenStreamInterceptor(h *middlewares.SecurityHolder) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return handler(srv, &openStream{ServerStream: ss, sec: h})
+	}
+}
+
+// openStream decrypts received messages
+type openStream struct {
+	grpc.ServerStream
+	sec *middlewares.SecurityHolder
+}
+
+// RecvMsg ...
+func (s *openStream) RecvMsg(m any) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	return open(s.sec, m)
+}
+
+// open replaces message with decrypted one. Message must be encrypted if private key is set.
+func open(h *middlewares.SecurityHolder, v any) error {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return status.Errorf(codes.InvalidArgument, "invalid message of type %T", v)
+	}
+	key := h.Load().PrivKey
+	data, sealed := sealedData(msg)
+	if !sealed && key == nil {
+		return nil
+	}
+	// key could be changed after message was received
+	if !sealed || key == nil {
+		return status.Error(codes.InvalidArgument, "unexpected request encryption")
+	}
+
+	plain, err := middlewares.OpenMessage(key, data)
+	if err != nil {
+		logger.Error("decrypt error", zap.Error(err))
+		return status.Error(codes.InvalidArgument, "decrypt error")
+	}
+	proto.Reset(msg)
+	if err = proto.Unmarshal(plain, msg); err != nil {
+		return status.Error(codes.InvalidArgument, "request decode error")
+	}
+	return nil
+}
+
+// sealedData returns encrypted data kept in message by codec
+func sealedData(msg proto.Message) ([]byte, bool) {
+	b := msg.ProtoReflect().GetUnknown()
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return nil, false
+		}
+		b = b[n:]
+		if num == sealedField && typ == protowire.BytesType {
+			data, n := protowire.ConsumeBytes(b)
+			return data, n >= 0
+		}
+		if n = protowire.ConsumeFieldValue(num, typ, b); n < 0 {
+			return nil, false
+		}
+		b = b[n:]
+	}
+	return nil, false
+}
@@ -0,0 +1,137 @@
+package grpc
+
+import (
+	"context"
+	"strings"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/SerjRamone/metrius/internal/middlewares"
+	"github.com/SerjRamone/metrius/pkg/logger"
+)
+
+// SubnetInterceptor checks if the client IP address is allowed by IP filter.
+// Security is loaded from h on every call, so the filter can be replaced while server is running.
+func SubnetInterceptor(h *middlewares.SecurityHolder) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if err := checkSubnet(ctx, h.Load().IPFilter); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// SubnetStreamInterceptor checks client IP on stream start as SubnetInterceptor
+func SubnetStreamInterceptor(h *middlewares.SecurityHolder) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := checkSubnet(ss.Context(), h.Load().IPFilter); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
+
+// checkSubnet checks client IP by filter. Client IP is the peer address,
+// x-forwarded-for and x-real-ip metadata are used only if peer is trusted proxy.
+func checkSubnet(ctx context.Context, f *middlewares.IPFilter) error {
+	if f == nil {
+		return nil
+	}
+
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return status.Error(codes.PermissionDenied, "unknown peer address")
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	ip, err := f.ClientIP(p.Addr.String(),
+		last(md, strings.ToLower(middlewares.RealIPHeader)),
+		strings.Join(md.Get(strings.ToLower(middlewares.ForwardedForHeader)), ","),
+	)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	if !f.Allowed(ip) {
+		return status.Error(codes.PermissionDenied, "Forbidden")
+	}
+	return nil
+}
+
+// SignInterceptor checks HMAC sign of serialized request in metadata, sign covers timestamp and nonce.
+// Requests without sign are passed unless sign is enforced, as in HTTP server.
+func SignInterceptor(h *middlewares.SecurityHolder) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		s := h.Load()
+		if !s.Signed() {
+			return handler(ctx, req)
+		}
+		md, _ := metadata.FromIncomingContext(ctx)
+		sign := first(md, "hashsha256")
+		if sign == "" {
+			if s.SignEnforce {
+				return nil, status.Error(codes.Unauthenticated, "sign required")
+			}
+			logger.Info("request without 'hashsha256' metadata")
+			return handler(ctx, req)
+		}
+
+		msg, ok := req.(proto.Message)
+		if !ok {
+			return nil, status.Error(codes.InvalidArgument, "invalid sign")
+		}
+		data, err := proto.MarshalOptions{Deterministic: true}.Marshal(msg)
+		if err != nil {
+			return nil, status.Error(codes.Internal, "request encode error")
+		}
+		timestamp, nonce := first(md, strings.ToLower(middlewares.TimestampHeader)), first(md, strings.ToLower(middlewares.NonceHeader))
+		payload := data
+		if timestamp != "" || nonce != "" {
+			payload = middlewares.SignPayload(timestamp, nonce, data)
+		}
+		agentID := first(md, strings.ToLower(middlewares.AgentIDHeader))
+		if _, ok = middlewares.MatchSign(middlewares.SignKeys(s.HashKey, s.Keys, agentID), payload, sign); !ok {
+			logger.Warn("invalid request sign", zap.String("agent", agentID))
+			return nil, status.Error(codes.InvalidArgument, "invalid sign")
+		}
+
+		if s.Replay != nil {
+			if err = s.Replay.Check(timestamp, nonce); err != nil {
+				logger.Warn("request replay check failed", zap.String("nonce", nonce), zap.Error(err))
+				return nil, status.Error(codes.InvalidArgument, err.Error())
+			}
+		}
+		return handler(ctx, req)
+	}
+}
+
+// SignStreamInterceptor rejects streams when signing is enabled, sign covers single request only,
+// so streams can't be checked and would bypass signing
+func SignStreamInterceptor(h *middlewares.SecurityHolder) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if h.Load().Signed() {
+			return status.Error(codes.Unimplemented, "signed streams are not supported")
+		}
+		return handler(srv, ss)
+	}
+}
+
+// first returns the first metadata value by key
+func first(md metadata.MD, key string) string {
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// last returns the last metadata value by key, values appended by proxies come last
+func last(md metadata.MD, key string) string {
+	if values := md.Get(key); len(values) > 0 {
+		return values[len(values)-1]
+	}
+	return ""
+}
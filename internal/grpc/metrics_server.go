@@ -3,9 +3,11 @@ package grpc
 
 import (
 	"context"
+	"errors"
 
 	"github.com/SerjRamone/metrius/internal/metrics"
 	"github.com/SerjRamone/metrius/internal/storage"
+	"github.com/SerjRamone/metrius/internal/tenant"
 	"github.com/SerjRamone/metrius/pkg/logger"
 	pb "github.com/SerjRamone/metrius/pkg/metrius_v1"
 	"go.uber.org/zap"
@@ -34,12 +36,18 @@ func (s *MetricsServer) Update(ctx context.Context, in *pb.UpdateRequest) (*pb.U
 	case pb.Metrics_COUNTER:
 		err = s.storage.SetCounter(ctx, in.Metrics.Id, metrics.Counter(in.Metrics.Delta))
 		if err != nil {
+			if qErr := quotaError(err); qErr != nil {
+				return nil, qErr
+			}
 			logger.Error("can't set counter", zap.String("ID", in.Metrics.Id), zap.Int64("delta", in.Metrics.Delta), zap.Error(err))
 			return nil, status.Errorf(codes.Internal, "can't set counter. ID: %s, VALUE: %v", in.Metrics.Id, in.Metrics.Delta)
 		}
 	case pb.Metrics_GAUGE:
 		err = s.storage.SetGauge(ctx, in.Metrics.Id, metrics.Gauge(in.Metrics.Value))
 		if err != nil {
+			if qErr := quotaError(err); qErr != nil {
+				return nil, qErr
+			}
 			logger.Error("can't set gauge", zap.String("ID", in.Metrics.Id), zap.Float64("delta", in.Metrics.Value), zap.Error(err))
 			return nil, status.Errorf(codes.Internal, "can't set gauge. ID: %s, VALUE: %v", in.Metrics.Id, in.Metrics.Value)
 		}
@@ -68,6 +76,9 @@ func (s *MetricsServer) BatchUpdate(ctx context.Context, in *pb.BatchUpdateReque
 		batch = append(batch, metrics.Metrics{ID: m.Id, Value: &m.Value, Delta: &m.Delta, MType: mType})
 	}
 	if err := s.storage.BatchUpsert(ctx, batch); err != nil {
+		if qErr := quotaError(err); qErr != nil {
+			return nil, qErr
+		}
 		logger.Error("can't do batch upsert", zap.Error(err))
 		return nil, status.Error(codes.Internal, "batach upsert error")
 	}
@@ -107,3 +118,16 @@ func (s *MetricsServer) GetMetrics(ctx context.Context, in *pb.GetMetricsRequest
 
 	return &response, nil
 }
+
+// quotaError returns status error if err is a quota error of tenant
+func quotaError(err error) error {
+	switch {
+	case errors.Is(err, tenant.ErrRateLimit):
+		logger.Warn("tenant quota exceeded", zap.Error(err))
+		return status.Error(codes.ResourceExhausted, err.Error())
+	case errors.Is(err, tenant.ErrSeriesLimit):
+		logger.Warn("tenant quota exceeded", zap.Error(err))
+		return status.Error(codes.FailedPrecondition, err.Error())
+	}
+	return nil
+}
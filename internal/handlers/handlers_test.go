@@ -13,7 +13,11 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"github.com/SerjRamone/metrius/internal/auth"
+	"github.com/SerjRamone/metrius/internal/metrics"
+	"github.com/SerjRamone/metrius/internal/middlewares"
 	"github.com/SerjRamone/metrius/internal/storage"
+	"github.com/SerjRamone/metrius/internal/tenant"
 )
 
 // stubResponseWriter используется для эмуляции http.ResponseWriter
@@ -69,9 +73,7 @@ func TestRouter(t *testing.T) {
 	fb := storage.NewFileBackuper(f)
 	m := storage.NewMemStorage(300, fb)
 	_ = m.SetCounter(context.TODO(), "foo", 1)
-	var privKey []byte
-
-	ts := httptest.NewServer(Router(m, "testkey", privKey, nil))
+	ts := httptest.NewServer(Router(m, middlewares.NewSecurityHolder(middlewares.Security{HashKey: "testkey"})))
 	defer ts.Close()
 
 	type want struct {
@@ -266,6 +268,52 @@ func TestRouter(t *testing.T) {
 	}
 }
 
+func TestRouter_Tenants(t *testing.T) {
+	a, err := auth.NewAuthenticator([]auth.Token{
+		{Token: "team-a", Tenant: "team-a", Scopes: []string{auth.ScopeAdmin}},
+		{Token: "team-b", Tenant: "team-b", Scopes: []string{auth.ScopeAdmin}},
+	}, "", nil)
+	require.NoError(t, err)
+	quotas := tenant.NewQuotas(tenant.Quota{}, map[string]tenant.Quota{"team-b": {MaxSeries: 1}})
+	s := storage.NewQuotaStorage(storage.NewMemStorage(300, nil), quotas)
+	ts := httptest.NewServer(Router(s, middlewares.NewSecurityHolder(middlewares.Security{Auth: a})))
+	defer ts.Close()
+
+	do := func(token, method, path string) int {
+		req, err := http.NewRequest(method, ts.URL+path, nil)
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := ts.Client().Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	tests := []struct {
+		name     string
+		token    string
+		method   string
+		path     string
+		wantCode int
+	}{
+		{name: "Test#1. Update of tenant A", token: "team-a", method: http.MethodPost, path: "/update/gauge/Alloc/1", wantCode: http.StatusOK},
+		{name: "Test#2. Value of tenant A", token: "team-a", method: http.MethodGet, path: "/value/gauge/Alloc", wantCode: http.StatusOK},
+		{name: "Test#3. Value of tenant B", token: "team-b", method: http.MethodGet, path: "/value/gauge/Alloc", wantCode: http.StatusNotFound},
+		{name: "Test#4. Update of tenant B", token: "team-b", method: http.MethodPost, path: "/update/gauge/Alloc/2", wantCode: http.StatusOK},
+		{name: "Test#5. Series limit of tenant B", token: "team-b", method: http.MethodPost, path: "/update/gauge/HeapAlloc/2", wantCode: http.StatusForbidden},
+		{name: "Test#6. No series limit of tenant A", token: "team-a", method: http.MethodPost, path: "/update/gauge/HeapAlloc/2", wantCode: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.wantCode, do(tt.token, tt.method, tt.path))
+		})
+	}
+
+	v, ok := s.Gauge(tenant.NewContext(context.Background(), "team-a"), "Alloc")
+	assert.True(t, ok)
+	assert.Equal(t, metrics.Gauge(1), v)
+}
+
 func ExamplebaseHandler_Ping() {
 	tempFile, err := os.CreateTemp("", "example")
 	if err != nil {
@@ -15,7 +15,11 @@ import (
 //   - 418 in all other cases.
 func (bHandler baseHandler) Ping() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if v, ok := bHandler.storage.(storage.SQLStorage); ok {
+		s := bHandler.storage
+		if w, ok := s.(interface{ Unwrap() storage.Storage }); ok {
+			s = w.Unwrap()
+		}
+		if v, ok := s.(storage.SQLStorage); ok {
 			err := v.Ping()
 			if err != nil {
 				logger.Error("can't ping db", zap.Error(err))
@@ -1,8 +1,7 @@
 package handlers
 
 import (
-	"net"
-
+	"github.com/SerjRamone/metrius/internal/auth"
 	"github.com/SerjRamone/metrius/internal/middlewares"
 	"github.com/SerjRamone/metrius/internal/storage"
 
@@ -12,39 +11,34 @@ import (
 
 // Router creates and configures a chi.Router object.
 //   - s: an object satisfying the storage.Storage interface, used as a storage for metrics.
-//   - hashKey: a string representing the encryption key used for signing.
-//   - privKey: a slice of bytes with private key for decrypt request body
-//   - trustedSubnet: an IPNet represents an IP network
-func Router(s storage.Storage, hashKey string, privKey []byte, trustedSubnet *net.IPNet) chi.Router {
+//   - sec: a holder of sign key, private key for decrypt request body, IP filter and token authenticator,
+//     they can be replaced while server is running.
+//
+// Read endpoints require read scope, update endpoints require write scope, /ping is public.
+// Clients see metrics of tenant of their token only.
+func Router(s storage.Storage, sec *middlewares.SecurityHolder) chi.Router {
 	r := chi.NewRouter()
 	bHandler := NewBaseHandler(s)
 
 	r.Use(middlewares.RequestLogger)
-	if hashKey != "" {
-		r.Use(middlewares.Signer(hashKey))
-	}
-	if len(privKey) > 0 {
-		r.Use(middlewares.Crypto(privKey))
-	}
-	if trustedSubnet != nil {
-		r.Use(middlewares.IPWhitelist(trustedSubnet))
-	}
 
 	// r.Mount("/debug", middleware.Profiler())
 
-	// with gzip compression
-	r.Group(func(r chi.Router) {
-		r.Use(middlewares.GzipCompressor)
-		r.Get("/", bHandler.List())
-		r.Post("/value/", bHandler.ValueJSON())
-		r.Post("/update/", bHandler.UpdateJSON())
-		r.Post("/updates/", bHandler.Updates())
-	})
+	read := middlewares.Secure(sec, auth.ScopeRead)
+	write := middlewares.Secure(sec, auth.ScopeWrite)
+	public := middlewares.Secure(sec, "")
+
+	// with gzip compression, body is decompressed after it's decrypted
+	gzip := middlewares.GzipCompressor
+	r.With(read, gzip).Get("/", bHandler.List())
+	r.With(read, gzip).Post("/value/", bHandler.ValueJSON())
+	r.With(write, gzip).Post("/update/", bHandler.UpdateJSON())
+	r.With(write, gzip).Post("/updates/", bHandler.Updates())
 
-	r.Get("/value/{type}/{name}", bHandler.Value())
-	r.Post("/update/{type}/{name}/{value}", bHandler.Update())
+	r.With(read).Get("/value/{type}/{name}", bHandler.Value())
+	r.With(write).Post("/update/{type}/{name}/{value}", bHandler.Update())
 
-	r.Get("/ping", bHandler.Ping())
+	r.With(public).Get("/ping", bHandler.Ping())
 
 	return r
 }
@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"io"
 	"log"
 	"net/http"
@@ -11,6 +12,7 @@ import (
 	"go.uber.org/zap"
 
 	"github.com/SerjRamone/metrius/internal/metrics"
+	"github.com/SerjRamone/metrius/internal/tenant"
 	"github.com/SerjRamone/metrius/pkg/logger"
 )
 
@@ -22,6 +24,7 @@ import (
 //     1. if type is not equal to "gauge" or "counter".
 //     2. if the value parameter is not provided.
 //     3. if the value parameter cannot be converted to a valid value of counter or gauge types.
+//   - 403 if series limit of tenant is exceeded, 429 if ingestion rate of tenant is exceeded.
 //   - 200 in case of successful metric update.
 //   - 500 in case of a service error.
 func (bHandler baseHandler) Update() http.HandlerFunc {
@@ -62,6 +65,9 @@ func (bHandler baseHandler) Update() http.HandlerFunc {
 			}
 
 			if err := bHandler.storage.SetCounter(r.Context(), mName, metrics.Counter(c)); err != nil {
+				if quotaExceeded(w, err) {
+					return
+				}
 				log.Fatal("can't set counter", err)
 				return
 			}
@@ -74,6 +80,9 @@ func (bHandler baseHandler) Update() http.HandlerFunc {
 			}
 
 			if err := bHandler.storage.SetGauge(r.Context(), mName, metrics.Gauge(g)); err != nil {
+				if quotaExceeded(w, err) {
+					return
+				}
 				log.Fatal("can't set gauge", err)
 				return
 			}
@@ -95,6 +104,7 @@ func (bHandler baseHandler) Update() http.HandlerFunc {
 //     1. if Content-Type is not application/json.
 //     2. if an invalid JSON is passed in the request body.
 //     3. if id, type, and delta/value are not correctly specified in the request body.
+//   - 403 if series limit of tenant is exceeded, 429 if ingestion rate of tenant is exceeded.
 //   - 200 in case of successful metric update.
 //
 // Example request body:
@@ -138,6 +148,9 @@ func (bHandler baseHandler) Updates() http.HandlerFunc {
 		}
 
 		if err := bHandler.storage.BatchUpsert(r.Context(), batch); err != nil {
+			if quotaExceeded(w, err) {
+				return
+			}
 			logger.Info("cannot do batch upsert", zap.Error(err))
 			w.WriteHeader(http.StatusBadRequest)
 			return
@@ -155,6 +168,7 @@ func (bHandler baseHandler) Updates() http.HandlerFunc {
 //     1. if Content-Type is not application/json.
 //     2. if an invalid JSON is passed in the request body.
 //     3. if id, type, and delta/value are not correctly specified in the request body.
+//   - 403 if series limit of tenant is exceeded, 429 if ingestion rate of tenant is exceeded.
 //   - 500 in case of an internal service error.
 //   - 200 in case of a successful metric update.
 //
@@ -207,6 +221,9 @@ func (bHandler baseHandler) UpdateJSON() http.HandlerFunc {
 		switch req.MType {
 		case "counter":
 			if err = bHandler.storage.SetCounter(r.Context(), req.ID, metrics.Counter(*req.Delta)); err != nil {
+				if quotaExceeded(w, err) {
+					return
+				}
 				logger.Fatal("can't set counter", zap.Error(err))
 				return
 			}
@@ -223,6 +240,9 @@ func (bHandler baseHandler) UpdateJSON() http.HandlerFunc {
 
 		case "gauge":
 			if err = bHandler.storage.SetGauge(r.Context(), req.ID, metrics.Gauge(*req.Value)); err != nil {
+				if quotaExceeded(w, err) {
+					return
+				}
 				logger.Fatal("can't set gauge", zap.Error(err))
 				return
 			}
@@ -243,3 +263,19 @@ func (bHandler baseHandler) UpdateJSON() http.HandlerFunc {
 		}
 	}
 }
+
+// quotaExceeded writes response if err is a quota error of tenant:
+// 429 if ingestion rate is exceeded, 403 if series limit is exceeded.
+func quotaExceeded(w http.ResponseWriter, err error) bool {
+	switch {
+	case errors.Is(err, tenant.ErrRateLimit):
+		logger.Warn("tenant quota exceeded", zap.Error(err))
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+	case errors.Is(err, tenant.ErrSeriesLimit):
+		logger.Warn("tenant quota exceeded", zap.Error(err))
+		http.Error(w, err.Error(), http.StatusForbidden)
+	default:
+		return false
+	}
+	return true
+}
@@ -1,8 +1,13 @@
 package metrics
 
 import (
+	"errors"
+	"fmt"
 	"math/rand"
 	"runtime"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -51,3 +56,74 @@ func NewCollection(m runtime.MemStats) Collection {
 	}
 	return c
 }
+
+// CollectionFromMetrics converts metrics in JSON-request format to collection
+func CollectionFromMetrics(batch []Metrics) (Collection, error) {
+	c := make(Collection, 0, len(batch))
+	for _, m := range batch {
+		if m.ID == "" {
+			return nil, errors.New("metrics id not set")
+		}
+		switch {
+		case m.MType == "gauge" && m.Value != nil:
+			c = append(c, CollectionItem{Name: m.ID, Type: m.MType, Value: *m.Value})
+		case m.MType == "counter" && m.Delta != nil:
+			c = append(c, CollectionItem{Name: m.ID, Type: m.MType, Value: float64(*m.Delta)})
+		default:
+			return nil, fmt.Errorf("metrics <%s>: type not set or unknown or value not set", m.ID)
+		}
+	}
+	return c, nil
+}
+
+// NameWithLabels returns metrics name with labels sorted by key,
+// f.e. AgentBuildInfo{commit="abc",version="1.0"}
+func NameWithLabels(name string, labels map[string]string) string {
+	if len(labels) == 0 {
+		return name
+	}
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString(name)
+	b.WriteByte('{')
+	for i, k := range keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%s=%q", k, labels[k])
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// SplitLabels parses name made by NameWithLabels into base name and labels
+func SplitLabels(name string) (string, map[string]string, error) {
+	i := strings.IndexByte(name, '{')
+	if i < 0 || !strings.HasSuffix(name, "}") {
+		return name, nil, nil
+	}
+
+	labels := make(map[string]string)
+	rest := name[i+1 : len(name)-1]
+	for rest != "" {
+		eq := strings.IndexByte(rest, '=')
+		if eq <= 0 {
+			return "", nil, fmt.Errorf("invalid labels of metrics <%s>", name)
+		}
+		key := rest[:eq]
+		value, err := strconv.QuotedPrefix(rest[eq+1:])
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid label <%s> of metrics <%s>: %w", key, name, err)
+		}
+		rest = strings.TrimPrefix(rest[eq+1+len(value):], ",")
+		if labels[key], err = strconv.Unquote(value); err != nil {
+			return "", nil, fmt.Errorf("invalid label <%s> of metrics <%s>: %w", key, name, err)
+		}
+	}
+	return name[:i], labels, nil
+}
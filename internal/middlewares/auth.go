@@ -0,0 +1,48 @@
+package middlewares
+
+import (
+	"errors"
+	"net/http"
+
+	"go.uber.org/zap"
+
+	"github.com/SerjRamone/metrius/internal/auth"
+	"github.com/SerjRamone/metrius/pkg/logger"
+)
+
+// RequireScope checks bearer token of request and its scope, principal is put into request context.
+// Requests are passed if Security has no authenticator.
+func RequireScope(h *SecurityHolder, scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requireScope(h.Load().Auth, scope)(next).ServeHTTP(w, r)
+		})
+	}
+}
+
+// requireScope checks bearer token by a, requests are passed if a is nil
+func requireScope(a *auth.Authenticator, scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if a == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			p, err := a.Authorize(auth.BearerToken(r.Header.Get("Authorization")), scope)
+			if errors.Is(err, auth.ErrForbidden) {
+				logger.Warn("insufficient scope", zap.String("subject", p.Subject), zap.String("scope", scope))
+				http.Error(w, err.Error(), http.StatusForbidden)
+				return
+			}
+			if err != nil {
+				logger.Warn("authentication failed", zap.Error(err))
+				w.Header().Set("WWW-Authenticate", `Bearer realm="metrius"`)
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(auth.WithPrincipal(r.Context(), p)))
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package middlewares
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/SerjRamone/metrius/internal/auth"
+)
+
+func TestRequireScope(t *testing.T) {
+	a, err := auth.NewAuthenticator([]auth.Token{
+		{Token: "reader", Subject: "dashboard", Scopes: []string{auth.ScopeRead}},
+		{Token: "writer", Subject: "agent", Scopes: []string{auth.ScopeWrite}},
+	}, "", nil)
+	require.NoError(t, err)
+	h := NewSecurityHolder(Security{Auth: a})
+
+	var subject string
+	handler := RequireScope(h, auth.ScopeWrite)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, _ := auth.FromContext(r.Context())
+		subject = p.Subject
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		name     string
+		header   string
+		wantCode int
+	}{
+		{name: "Test#1. Token with scope", header: "Bearer writer", wantCode: http.StatusOK},
+		{name: "Test#2. Token without scope", header: "Bearer reader", wantCode: http.StatusForbidden},
+		{name: "Test#3. Unknown token", header: "Bearer unknown", wantCode: http.StatusUnauthorized},
+		{name: "Test#4. No token", wantCode: http.StatusUnauthorized},
+		{name: "Test#5. Not bearer", header: "Basic writer", wantCode: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/updates/", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+			assert.Equal(t, tt.wantCode, rr.Code)
+			if tt.wantCode == http.StatusUnauthorized {
+				assert.NotEmpty(t, rr.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+	assert.Equal(t, "agent", subject)
+
+	// auth is disabled without authenticator
+	h.Store(Security{})
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/updates/", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+}
@@ -3,9 +3,15 @@ package middlewares
 import (
 	"bytes"
 	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
+	"encoding/base64"
 	"encoding/pem"
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
 
@@ -14,8 +20,180 @@ import (
 	"github.com/SerjRamone/metrius/pkg/logger"
 )
 
-// Crypto decrypts request body
-func Crypto(privKey []byte) func(http.Handler) http.Handler {
+// EncryptedKeyHeader is a header with base64 AES key of request encrypted by RSA-OAEP.
+// Requests without it are encrypted by legacy chunked RSA.
+const EncryptedKeyHeader = "X-Encrypted-Key"
+
+// envelopeV2 is a version of envelope: version byte, GCM nonce and AES-256-GCM ciphertext
+const envelopeV2 byte = 2
+
+// ErrEnvelope is returned when encrypted body can't be decrypted
+var ErrEnvelope = errors.New("invalid encrypted envelope")
+
+// ParsePrivateKey parses PEM-encoded PKCS1 RSA private key
+func ParsePrivateKey(data []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("private key is not PEM-encoded")
+	}
+	key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parsing private key error: %w", err)
+	}
+	return key, nil
+}
+
+// ParsePublicKey parses PEM-encoded PKCS1 RSA public key
+func ParsePublicKey(data []byte) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("public key is not PEM-encoded")
+	}
+	key, err := x509.ParsePKCS1PublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parsing public key error: %w", err)
+	}
+	return key, nil
+}
+
+// Encrypt encrypts data with random AES-256-GCM key, the key is encrypted with pub.
+// It returns envelope and base64 encrypted key for EncryptedKeyHeader.
+func Encrypt(pub *rsa.PublicKey, data []byte) ([]byte, string, error) {
+	envelope, encKey, err := encrypt(pub, data)
+	if err != nil {
+		return nil, "", err
+	}
+	return envelope, base64.StdEncoding.EncodeToString(encKey), nil
+}
+
+// Decrypt decrypts envelope made by Encrypt, empty encKey means legacy chunked RSA body
+func Decrypt(key *rsa.PrivateKey, envelope []byte, encKey string) ([]byte, error) {
+	if encKey == "" {
+		return decryptChunks(key, envelope)
+	}
+	rawKey, err := base64.StdEncoding.DecodeString(encKey)
+	if err != nil {
+		return nil, fmt.Errorf("%w: encrypted key is not base64", ErrEnvelope)
+	}
+	return decrypt(key, envelope, rawKey)
+}
+
+// SealMessage encrypts data as Encrypt, but puts encrypted key into message, so it needs no headers.
+// Message is version byte, 2 bytes of key length, encrypted key, GCM nonce and ciphertext.
+// Version byte is never the first byte of protobuf message, it's an invalid field tag.
+func SealMessage(pub *rsa.PublicKey, data []byte) ([]byte, error) {
+	envelope, encKey, err := encrypt(pub, data)
+	if err != nil {
+		return nil, err
+	}
+	msg := make([]byte, 0, len(envelope)+len(encKey)+2)
+	msg = append(msg, envelope[0], byte(len(encKey)>>8), byte(len(encKey)))
+	msg = append(msg, encKey...)
+	return append(msg, envelope[1:]...), nil
+}
+
+// OpenMessage decrypts message made by SealMessage
+func OpenMessage(key *rsa.PrivateKey, msg []byte) ([]byte, error) {
+	if len(msg) < 3 || msg[0] != envelopeV2 {
+		return nil, fmt.Errorf("%w: message is not encrypted", ErrEnvelope)
+	}
+	n := int(msg[1])<<8 | int(msg[2])
+	if len(msg) < 3+n {
+		return nil, fmt.Errorf("%w: message is too short", ErrEnvelope)
+	}
+	envelope := make([]byte, 0, len(msg)-n-2)
+	envelope = append(envelope, msg[0])
+	envelope = append(envelope, msg[3+n:]...)
+	return decrypt(key, envelope, msg[3:3+n])
+}
+
+// encrypt returns envelope of data and AES key encrypted with pub
+func encrypt(pub *rsa.PublicKey, data []byte) ([]byte, []byte, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, nil, fmt.Errorf("key generation error: %w", err)
+	}
+	encKey, err := rsa.EncryptOAEP(crypto.SHA512.New(), rand.Reader, pub, key, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("key encryption error: %w", err)
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	envelope := make([]byte, 1+gcm.NonceSize(), 1+gcm.NonceSize()+len(data)+gcm.Overhead())
+	envelope[0] = envelopeV2
+	if _, err = rand.Read(envelope[1:]); err != nil {
+		return nil, nil, fmt.Errorf("nonce generation error: %w", err)
+	}
+	// version byte is authenticated as additional data
+	return gcm.Seal(envelope, envelope[1:], data, envelope[:1]), encKey, nil
+}
+
+// decrypt decrypts envelope by AES key encrypted with public key of key
+func decrypt(key *rsa.PrivateKey, envelope, encKey []byte) ([]byte, error) {
+	aesKey, err := key.Decrypt(nil, encKey, &rsa.OAEPOptions{Hash: crypto.SHA512})
+	if err != nil || len(aesKey) != 32 {
+		return nil, fmt.Errorf("%w: key decryption failed", ErrEnvelope)
+	}
+	gcm, err := newGCM(aesKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(envelope) < 1+gcm.NonceSize() {
+		return nil, fmt.Errorf("%w: body is too short", ErrEnvelope)
+	}
+	if envelope[0] != envelopeV2 {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrEnvelope, envelope[0])
+	}
+	nonce, ciphertext := envelope[1:1+gcm.NonceSize()], envelope[1+gcm.NonceSize():]
+	data, err := gcm.Open(nil, nonce, ciphertext, envelope[:1])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrEnvelope, err)
+	}
+	return data, nil
+}
+
+// decryptChunks decrypts body of old agents, encrypted by RSA-OAEP chunks
+func decryptChunks(key *rsa.PrivateKey, body []byte) ([]byte, error) {
+	msgLen := len(body)
+	// chunk size
+	step := key.PublicKey.Size()
+	var decryptedBytes []byte
+
+	// decrypt by chunks
+	for start := 0; start < msgLen; start += step {
+		finish := start + step
+		if finish > msgLen {
+			finish = msgLen
+		}
+
+		decryptedBlockBytes, err := key.Decrypt(nil, body[start:finish], &rsa.OAEPOptions{Hash: crypto.SHA512})
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrEnvelope, err)
+		}
+
+		decryptedBytes = append(decryptedBytes, decryptedBlockBytes...)
+	}
+	return decryptedBytes, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("cipher creation error: %w", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("cipher creation error: %w", err)
+	}
+	return gcm, nil
+}
+
+// Crypto decrypts request body. Body is AES-GCM envelope if EncryptedKeyHeader is set,
+// otherwise it's decrypted by legacy chunked RSA to support old agents.
+func Crypto(key *rsa.PrivateKey) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			bodyBytes, err := io.ReadAll(r.Body)
@@ -26,39 +204,17 @@ func Crypto(privKey []byte) func(http.Handler) http.Handler {
 			}
 			r.Body.Close()
 
-			// parse pem-encoded key
-			block, _ := pem.Decode(privKey)
-			key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+			decryptedBytes, err := Decrypt(key, bodyBytes, r.Header.Get(EncryptedKeyHeader))
 			if err != nil {
-				w.WriteHeader(http.StatusInternalServerError)
-				logger.Error("parsing key error", zap.Error(err))
+				w.WriteHeader(http.StatusBadRequest)
+				logger.Error("decrypt error", zap.Error(err))
 				return
 			}
 
-			msgLen := len(bodyBytes)
-			// chunk size
-			step := key.PublicKey.Size()
-			var decryptedBytes []byte
-
-			// decrypt by chunks
-			for start := 0; start < msgLen; start += step {
-				finish := start + step
-				if finish > msgLen {
-					finish = msgLen
-				}
-
-				decryptedBlockBytes, err := key.Decrypt(nil, bodyBytes[start:finish], &rsa.OAEPOptions{Hash: crypto.SHA512})
-				if err != nil {
-					w.WriteHeader(http.StatusInternalServerError)
-					logger.Error("decrypt error", zap.Error(err))
-					return
-				}
-
-				decryptedBytes = append(decryptedBytes, decryptedBlockBytes...)
-			}
-
 			// set unread body
 			r.Body = io.NopCloser(bytes.NewBuffer(decryptedBytes))
+			r.ContentLength = int64(len(decryptedBytes))
+			r.Header.Del(EncryptedKeyHeader)
 
 			next.ServeHTTP(w, r)
 		})
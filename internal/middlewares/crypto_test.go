@@ -0,0 +1,75 @@
+package middlewares
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// encryptChunks encrypts body as old agents do
+func encryptChunks(t *testing.T, pub *rsa.PublicKey, body []byte) []byte {
+	t.Helper()
+	hash := crypto.SHA512.New()
+	step := pub.Size() - 2*hash.Size() - 2
+	var out []byte
+	for start := 0; start < len(body); start += step {
+		finish := min(start+step, len(body))
+		chunk, err := rsa.EncryptOAEP(hash, rand.Reader, pub, body[start:finish], nil)
+		require.NoError(t, err)
+		out = append(out, chunk...)
+	}
+	return out
+}
+
+func TestCrypto(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	handler := Crypto(key)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		_, _ = w.Write(b)
+	}))
+
+	body := bytes.Repeat([]byte(`{"id":"Alloc","type":"gauge","value":1}`), 100)
+	envelope, encKey, err := Encrypt(&key.PublicKey, body)
+	require.NoError(t, err)
+	tampered := bytes.Clone(envelope)
+	tampered[len(tampered)-1] ^= 1
+	oldVersion := bytes.Clone(envelope)
+	oldVersion[0] = 1
+
+	tests := []struct {
+		name     string
+		body     []byte
+		encKey   string
+		wantCode int
+	}{
+		{name: "Test#1. Envelope", body: envelope, encKey: encKey, wantCode: http.StatusOK},
+		{name: "Test#2. Legacy chunks", body: encryptChunks(t, &key.PublicKey, body), wantCode: http.StatusOK},
+		{name: "Test#3. Tampered envelope", body: tampered, encKey: encKey, wantCode: http.StatusBadRequest},
+		{name: "Test#4. Unknown version", body: oldVersion, encKey: encKey, wantCode: http.StatusBadRequest},
+		{name: "Test#5. Invalid key header", body: envelope, encKey: "not base64", wantCode: http.StatusBadRequest},
+		{name: "Test#6. Plain body", body: body, wantCode: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/updates/", bytes.NewReader(tt.body))
+			if tt.encKey != "" {
+				req.Header.Set(EncryptedKeyHeader, tt.encKey)
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+			assert.Equal(t, tt.wantCode, rr.Code)
+			if tt.wantCode == http.StatusOK {
+				assert.Equal(t, body, rr.Body.Bytes())
+			}
+		})
+	}
+}
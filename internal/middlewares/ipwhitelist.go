@@ -1,29 +1,144 @@
 package middlewares
 
 import (
+	"errors"
+	"fmt"
 	"net"
 	"net/http"
+	"strings"
 )
 
-// IPWhitelist checks if the incoming request's IP address belongs to a trusted subnet
-func IPWhitelist(trustedNet *net.IPNet) func(http.Handler) http.Handler {
+// headers with client IP set by proxies
+const (
+	RealIPHeader       = "X-Real-IP"
+	ForwardedForHeader = "X-Forwarded-For"
+)
+
+// IPFilter allows requests by client IP. Client IP is the address of connection,
+// X-Forwarded-For and X-Real-IP are used only if connection comes from trusted proxy.
+type IPFilter struct {
+	allow   []*net.IPNet
+	deny    []*net.IPNet
+	proxies []*net.IPNet
+}
+
+// NewIPFilter parses comma-separated lists of IPv4 or IPv6 CIDRs.
+// Client IP must be in allow list, if it's set, and mustn't be in deny list.
+// It returns nil if both allow and deny lists are empty, nothing is filtered then.
+func NewIPFilter(allow, deny, proxies string) (*IPFilter, error) {
+	var f IPFilter
+	var err error
+	if f.allow, err = ParseCIDRs(allow); err != nil {
+		return nil, err
+	}
+	if f.deny, err = ParseCIDRs(deny); err != nil {
+		return nil, err
+	}
+	if f.proxies, err = ParseCIDRs(proxies); err != nil {
+		return nil, err
+	}
+	if len(f.allow) == 0 && len(f.deny) == 0 {
+		return nil, nil
+	}
+	return &f, nil
+}
+
+// ParseCIDRs parses comma-separated list of CIDRs, empty items are skipped
+func ParseCIDRs(list string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		_, subnet, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR subnet <%s> error: %w", item, err)
+		}
+		nets = append(nets, subnet)
+	}
+	return nets, nil
+}
+
+// ClientIP returns IP of client by connection address and values of proxy headers
+func (f *IPFilter) ClientIP(remoteAddr, realIP, forwardedFor string) (net.IP, error) {
+	ip := parseIP(remoteAddr)
+	if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		ip = parseIP(host)
+	}
+	if ip == nil {
+		return nil, errors.New("invalid remote address")
+	}
+	if !contains(f.proxies, ip) {
+		// headers of untrusted peers are ignored, they can be spoofed
+		return ip, nil
+	}
+
+	if forwardedFor != "" {
+		// the rightmost address which is not a trusted proxy is the client,
+		// addresses before it are set by client and can be spoofed
+		hops := strings.Split(forwardedFor, ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			ip = parseIP(strings.TrimSpace(hops[i]))
+			if ip == nil {
+				return nil, fmt.Errorf("invalid %s header value", ForwardedForHeader)
+			}
+			if !contains(f.proxies, ip) {
+				break
+			}
+		}
+		return ip, nil
+	}
+	if realIP != "" {
+		if ip = parseIP(strings.TrimSpace(realIP)); ip == nil {
+			return nil, fmt.Errorf("invalid %s header value", RealIPHeader)
+		}
+	}
+	return ip, nil
+}
+
+// Allowed returns true if ip is not denied and is allowed
+func (f *IPFilter) Allowed(ip net.IP) bool {
+	if contains(f.deny, ip) {
+		return false
+	}
+	return len(f.allow) == 0 || contains(f.allow, ip)
+}
+
+// contains returns true if ip belongs to one of nets
+func contains(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseIP parses IP address, IPv6 zone is dropped
+func parseIP(s string) net.IP {
+	if i := strings.IndexByte(s, '%'); i >= 0 {
+		s = s[:i]
+	}
+	return net.ParseIP(s)
+}
+
+// IPWhitelist checks if IP address of the incoming request is allowed by filter
+func IPWhitelist(f *IPFilter) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			hIP := r.Header.Get("X-Real-IP")
-			if hIP == "" {
-				http.Error(w, "X-Real-IP header is required", http.StatusBadRequest)
-				return
+			// proxies append header lines, lines before the last ones can be set by client
+			var realIP string
+			if values := r.Header.Values(RealIPHeader); len(values) > 0 {
+				realIP = values[len(values)-1]
 			}
-
-			// parse IP from header
-			ip := net.ParseIP(hIP)
-			if ip == nil {
-				http.Error(w, "invalid X-Real-IP header value", http.StatusBadRequest)
+			ip, err := f.ClientIP(r.RemoteAddr, realIP, strings.Join(r.Header.Values(ForwardedForHeader), ","))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
 				return
 			}
 
-			// compare IP with trusted subnet
-			if !trustedNet.Contains(ip) {
+			if !f.Allowed(ip) {
 				http.Error(w, "Forbidden", http.StatusForbidden)
 				return
 			}
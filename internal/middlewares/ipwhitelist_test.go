@@ -0,0 +1,84 @@
+package middlewares
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewIPFilter(t *testing.T) {
+	tests := []struct {
+		name    string
+		allow   string
+		deny    string
+		proxies string
+		wantNil bool
+		wantErr bool
+	}{
+		{name: "Test#1. Empty", wantNil: true},
+		{name: "Test#2. Proxies only", proxies: "10.0.0.0/8", wantNil: true},
+		{name: "Test#3. IPv4 and IPv6", allow: "192.168.1.0/24, 2001:db8::/32", wantNil: false},
+		{name: "Test#4. Deny only", deny: "192.168.1.13/32", wantNil: false},
+		{name: "Test#5. Invalid subnet", allow: "192.168.1.0", wantErr: true},
+		{name: "Test#6. Invalid proxy", allow: "192.168.1.0/24", proxies: "proxy", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := NewIPFilter(tt.allow, tt.deny, tt.proxies)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantNil, f == nil)
+		})
+	}
+}
+
+func TestIPWhitelist(t *testing.T) {
+	f, err := NewIPFilter("192.168.1.0/24,2001:db8::/32", "192.168.1.13/32", "10.0.0.0/8")
+	require.NoError(t, err)
+	handler := IPWhitelist(f)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		name         string
+		remoteAddr   string
+		realIP       []string
+		forwardedFor []string
+		wantCode     int
+	}{
+		{name: "Test#1. Allowed remote address", remoteAddr: "192.168.1.5:4000", wantCode: http.StatusOK},
+		{name: "Test#2. Allowed IPv6 remote address", remoteAddr: "[2001:db8::1]:4000", wantCode: http.StatusOK},
+		{name: "Test#3. Denied remote address", remoteAddr: "192.168.1.13:4000", wantCode: http.StatusForbidden},
+		{name: "Test#4. Not allowed remote address", remoteAddr: "172.16.0.1:4000", wantCode: http.StatusForbidden},
+		{name: "Test#5. Spoofed header of untrusted peer", remoteAddr: "172.16.0.1:4000", realIP: []string{"192.168.1.5"}, wantCode: http.StatusForbidden},
+		{name: "Test#6. Real IP from proxy", remoteAddr: "10.0.0.1:4000", realIP: []string{"192.168.1.5"}, wantCode: http.StatusOK},
+		{name: "Test#7. Proxy without headers", remoteAddr: "10.0.0.1:4000", wantCode: http.StatusForbidden},
+		{name: "Test#8. Forwarded chain", remoteAddr: "10.0.0.1:4000", forwardedFor: []string{"192.168.1.5, 10.0.0.2"}, wantCode: http.StatusOK},
+		{name: "Test#9. Spoofed forwarded address", remoteAddr: "10.0.0.1:4000", forwardedFor: []string{"192.168.1.5, 172.16.0.1"}, wantCode: http.StatusForbidden},
+		{name: "Test#10. Forwarded denied address", remoteAddr: "10.0.0.1:4000", forwardedFor: []string{"192.168.1.13"}, realIP: []string{"192.168.1.5"}, wantCode: http.StatusForbidden},
+		{name: "Test#11. Invalid forwarded address", remoteAddr: "10.0.0.1:4000", forwardedFor: []string{"unknown"}, wantCode: http.StatusBadRequest},
+		{name: "Test#12. Spoofed forwarded header line", remoteAddr: "10.0.0.1:4000", forwardedFor: []string{"192.168.1.5", "172.16.0.1"}, wantCode: http.StatusForbidden},
+		{name: "Test#13. Spoofed real IP header line", remoteAddr: "10.0.0.1:4000", realIP: []string{"192.168.1.5", "172.16.0.1"}, wantCode: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/updates/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for _, v := range tt.realIP {
+				req.Header.Add(RealIPHeader, v)
+			}
+			for _, v := range tt.forwardedFor {
+				req.Header.Add(ForwardedForHeader, v)
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+			assert.Equal(t, tt.wantCode, rr.Code)
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package middlewares
+
+import (
+	"errors"
+	"time"
+)
+
+// AgentIDHeader is a header with agent ID, it selects agent keys in KeyStore
+const AgentIDHeader = "X-Agent-ID"
+
+// AgentKey is a HMAC key of agent valid from NotBefore till NotAfter, zero time is unbounded.
+// Overlapping windows of old and new keys allow rotating keys without rejected requests.
+type AgentKey struct {
+	Key       string
+	NotBefore time.Time
+	NotAfter  time.Time
+}
+
+// KeyStore maps agent IDs to their keys
+type KeyStore struct {
+	keys map[string][]AgentKey
+	now  func() time.Time
+}
+
+// NewKeyStore validates keys and creates KeyStore
+func NewKeyStore(keys map[string][]AgentKey) (*KeyStore, error) {
+	for id, list := range keys {
+		if id == "" {
+			return nil, errors.New("agent ID of key is empty")
+		}
+		for _, k := range list {
+			if k.Key == "" {
+				return nil, errors.New("agent key is empty, agent: " + id)
+			}
+			if !k.NotBefore.IsZero() && !k.NotAfter.IsZero() && !k.NotAfter.After(k.NotBefore) {
+				return nil, errors.New("agent key window is empty, agent: " + id)
+			}
+		}
+	}
+	return &KeyStore{keys: keys, now: time.Now}, nil
+}
+
+// Keys returns keys of agent valid now and whether agent is known
+func (s *KeyStore) Keys(agentID string) ([]string, bool) {
+	list, ok := s.keys[agentID]
+	if !ok {
+		return nil, false
+	}
+	now := s.now()
+	keys := make([]string, 0, len(list))
+	for _, k := range list {
+		if (k.NotBefore.IsZero() || !now.Before(k.NotBefore)) && (k.NotAfter.IsZero() || now.Before(k.NotAfter)) {
+			keys = append(keys, k.Key)
+		}
+	}
+	return keys, true
+}
+
+// SignKeys returns keys which request of agent can be signed with.
+// Known agents use their own keys only, others use common hashKey.
+func SignKeys(hashKey string, store *KeyStore, agentID string) []string {
+	if store != nil && agentID != "" {
+		if keys, ok := store.Keys(agentID); ok {
+			return keys
+		}
+	}
+	if hashKey == "" {
+		return nil
+	}
+	return []string{hashKey}
+}
+
+// MatchSign returns key which sign of payload is made with
+func MatchSign(keys []string, payload []byte, sign string) (string, bool) {
+	for _, key := range keys {
+		if hmacEqual(CalcHash(payload, []byte(key)), sign) {
+			return key, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,87 @@
+package middlewares
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyStore_Rotation(t *testing.T) {
+	rotation := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	store, err := NewKeyStore(map[string][]AgentKey{
+		"agent-1": {
+			{Key: "old", NotAfter: rotation.Add(time.Hour)},
+			{Key: "new", NotBefore: rotation},
+		},
+	})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		now     time.Time
+		agentID string
+		want    []string
+	}{
+		{name: "Test#1. Before rotation", now: rotation.Add(-time.Hour), agentID: "agent-1", want: []string{"old"}},
+		{name: "Test#2. Rotation window", now: rotation.Add(time.Minute), agentID: "agent-1", want: []string{"old", "new"}},
+		{name: "Test#3. After rotation", now: rotation.Add(2 * time.Hour), agentID: "agent-1", want: []string{"new"}},
+		{name: "Test#4. Unknown agent", now: rotation, agentID: "agent-2", want: []string{"common"}},
+		{name: "Test#5. Without ID", now: rotation, agentID: "", want: []string{"common"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store.now = func() time.Time { return tt.now }
+			assert.Equal(t, tt.want, SignKeys("common", store, tt.agentID))
+		})
+	}
+}
+
+func TestNewKeyStore_Invalid(t *testing.T) {
+	now := time.Now()
+	_, err := NewKeyStore(map[string][]AgentKey{"agent-1": {{Key: ""}}})
+	assert.Error(t, err)
+	_, err = NewKeyStore(map[string][]AgentKey{"agent-1": {{Key: "k", NotBefore: now, NotAfter: now}}})
+	assert.Error(t, err)
+}
+
+func TestSigner_AgentKeysEnforce(t *testing.T) {
+	store, err := NewKeyStore(map[string][]AgentKey{"agent-1": {{Key: "agent-key"}}})
+	require.NoError(t, err)
+	handler := Signer("common", nil, WithKeyStore(store), WithEnforce(true))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	body := []byte("test body")
+	tests := []struct {
+		name     string
+		agentID  string
+		key      string
+		wantCode int
+	}{
+		{name: "Test#1. Agent key", agentID: "agent-1", key: "agent-key", wantCode: http.StatusOK},
+		{name: "Test#2. Common key of known agent", agentID: "agent-1", key: "common", wantCode: http.StatusBadRequest},
+		{name: "Test#3. Common key", agentID: "", key: "common", wantCode: http.StatusOK},
+		{name: "Test#4. Unsigned", agentID: "", key: "", wantCode: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/updates/", bytes.NewReader(body))
+			if tt.key != "" {
+				req.Header.Set("HashSHA256", CalcHash(body, []byte(tt.key)))
+			}
+			req.Header.Set(AgentIDHeader, tt.agentID)
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+			assert.Equal(t, tt.wantCode, rr.Code)
+			if tt.wantCode == http.StatusOK {
+				// response is signed with key of request
+				assert.Equal(t, CalcHash(nil, []byte(tt.key)), rr.Header().Get("HashSHA256"))
+			}
+		})
+	}
+}
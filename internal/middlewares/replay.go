@@ -0,0 +1,166 @@
+package middlewares
+
+import (
+	"container/heap"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// headers of signed requests, sign covers timestamp, nonce and body
+const (
+	TimestampHeader = "X-Timestamp"
+	NonceHeader     = "X-Nonce"
+)
+
+// errors of replay checks
+var (
+	ErrStaleRequest   = errors.New("request timestamp is out of allowed window")
+	ErrReplayedNonce  = errors.New("request nonce is already used")
+	ErrMissingNonce   = errors.New("request timestamp and nonce are required")
+	ErrInvalidRequest = errors.New("invalid request timestamp or nonce")
+)
+
+// SignPayload returns data which is signed by HashSHA256 with timestamp and nonce
+func SignPayload(timestamp, nonce string, body []byte) []byte {
+	payload := make([]byte, 0, len(timestamp)+len(nonce)+len(body)+2)
+	payload = append(payload, timestamp...)
+	payload = append(payload, '\n')
+	payload = append(payload, nonce...)
+	payload = append(payload, '\n')
+	return append(payload, body...)
+}
+
+// NewNonce returns random hex nonce and current unix timestamp for signing request
+func NewNonce() (timestamp, nonce string, err error) {
+	b := make([]byte, 16)
+	if _, err = rand.Read(b); err != nil {
+		return "", "", err
+	}
+	return strconv.FormatInt(time.Now().Unix(), 10), hex.EncodeToString(b), nil
+}
+
+// ReplayGuard rejects requests with timestamp out of clock skew window
+// and requests with already used nonce
+type ReplayGuard struct {
+	// Legacy allows requests signed without timestamp and nonce
+	Legacy bool
+
+	maxSkew time.Duration
+	nonces  *nonceCache
+	now     func() time.Time
+}
+
+// NonceRate is a number of signed requests per second which nonce cache holds
+// for the whole window by default
+const NonceRate = 200
+
+// NewReplayGuard creates guard which remembers up to cacheSize nonces.
+// If cacheSize is not positive, it is scaled to the window of maxSkew by NonceRate.
+func NewReplayGuard(maxSkew time.Duration, cacheSize int, legacy bool) *ReplayGuard {
+	if cacheSize <= 0 {
+		cacheSize = max(int(2*maxSkew/time.Second), 1) * NonceRate
+	}
+	return &ReplayGuard{
+		Legacy:  legacy,
+		maxSkew: maxSkew,
+		nonces:  newNonceCache(cacheSize),
+		now:     time.Now,
+	}
+}
+
+// Check validates timestamp and nonce of request. Empty values are allowed in legacy mode only.
+func (g *ReplayGuard) Check(timestamp, nonce string) error {
+	if timestamp == "" && nonce == "" {
+		if g.Legacy {
+			return nil
+		}
+		return ErrMissingNonce
+	}
+	sec, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || nonce == "" || len(nonce) > 64 {
+		return ErrInvalidRequest
+	}
+
+	ts, now := time.Unix(sec, 0), g.now()
+	if ts.Before(now.Add(-g.maxSkew)) || ts.After(now.Add(g.maxSkew)) {
+		return ErrStaleRequest
+	}
+	return g.nonces.add(nonce, ts, now.Add(-g.maxSkew))
+}
+
+// nonceCache remembers nonces until their timestamps leave the window.
+// When it is full the nonce with the oldest timestamp is evicted and requests not newer than it
+// are rejected, so evicted nonces can't be replayed. Eviction by timestamp keeps a client
+// with clock ahead from raising the floor for others.
+type nonceCache struct {
+	mu    sync.Mutex
+	size  int
+	seen  map[string]struct{}
+	heap  nonceHeap
+	floor time.Time
+}
+
+type nonceEntry struct {
+	nonce string
+	ts    time.Time
+}
+
+// nonceHeap is a min-heap of entries by timestamp
+type nonceHeap []nonceEntry
+
+func (h nonceHeap) Len() int           { return len(h) }
+func (h nonceHeap) Less(i, j int) bool { return h[i].ts.Before(h[j].ts) }
+func (h nonceHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *nonceHeap) Push(x any)        { *h = append(*h, x.(nonceEntry)) }
+func (h *nonceHeap) Pop() any {
+	old := *h
+	e := old[len(old)-1]
+	old[len(old)-1] = nonceEntry{}
+	*h = old[:len(old)-1]
+	return e
+}
+
+func newNonceCache(size int) *nonceCache {
+	return &nonceCache{
+		size: size,
+		seen: make(map[string]struct{}),
+	}
+}
+
+// add records nonce, expired are entries with timestamp before windowStart
+func (c *nonceCache) add(nonce string, ts, windowStart time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !ts.After(c.floor) {
+		return ErrStaleRequest
+	}
+	if _, ok := c.seen[nonce]; ok {
+		return ErrReplayedNonce
+	}
+
+	// drop expired entries
+	for len(c.heap) > 0 && c.heap[0].ts.Before(windowStart) {
+		c.evict()
+	}
+	for len(c.heap) >= c.size && len(c.heap) > 0 {
+		if e := c.evict(); e.ts.After(c.floor) {
+			c.floor = e.ts
+		}
+	}
+
+	c.seen[nonce] = struct{}{}
+	heap.Push(&c.heap, nonceEntry{nonce: nonce, ts: ts})
+	return nil
+}
+
+// evict removes entry with the oldest timestamp
+func (c *nonceCache) evict() nonceEntry {
+	e := heap.Pop(&c.heap).(nonceEntry)
+	delete(c.seen, e.nonce)
+	return e
+}
@@ -0,0 +1,129 @@
+package middlewares
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplayGuard_Check(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	ts := func(d time.Duration) string {
+		return strconv.FormatInt(now.Add(d).Unix(), 10)
+	}
+
+	tests := []struct {
+		name      string
+		legacy    bool
+		timestamp string
+		nonce     string
+		wantErr   error
+	}{
+		{name: "Test#1. Valid", timestamp: ts(0), nonce: "a", wantErr: nil},
+		{name: "Test#2. Replayed nonce", timestamp: ts(0), nonce: "a", wantErr: ErrReplayedNonce},
+		{name: "Test#3. Old timestamp", timestamp: ts(-time.Hour), nonce: "b", wantErr: ErrStaleRequest},
+		{name: "Test#4. Future timestamp", timestamp: ts(time.Hour), nonce: "c", wantErr: ErrStaleRequest},
+		{name: "Test#5. Invalid timestamp", timestamp: "now", nonce: "d", wantErr: ErrInvalidRequest},
+		{name: "Test#6. Missing nonce", wantErr: ErrMissingNonce},
+		{name: "Test#7. Legacy", legacy: true, wantErr: nil},
+	}
+	g := NewReplayGuard(time.Minute, 10, false)
+	g.now = func() time.Time { return now }
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g.Legacy = tt.legacy
+			assert.ErrorIs(t, g.Check(tt.timestamp, tt.nonce), tt.wantErr)
+		})
+	}
+}
+
+func TestReplayGuard_Evict(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	g := NewReplayGuard(time.Minute, 2, false)
+	g.now = func() time.Time { return now }
+	ts := func(d time.Duration) string {
+		return strconv.FormatInt(now.Add(d).Unix(), 10)
+	}
+
+	assert.NoError(t, g.Check(ts(-3*time.Second), "a"))
+	assert.NoError(t, g.Check(ts(-2*time.Second), "b"))
+	// "a" is evicted, requests not newer than it are rejected
+	assert.NoError(t, g.Check(ts(-time.Second), "c"))
+	assert.ErrorIs(t, g.Check(ts(-3*time.Second), "a"), ErrStaleRequest)
+	assert.ErrorIs(t, g.Check(ts(-2*time.Second), "b"), ErrReplayedNonce)
+	assert.NoError(t, g.Check(ts(0), "d"))
+}
+
+func TestReplayGuard_EvictByTimestamp(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	g := NewReplayGuard(time.Minute, 2, false)
+	g.now = func() time.Time { return now }
+	ts := func(d time.Duration) string {
+		return strconv.FormatInt(now.Add(d).Unix(), 10)
+	}
+
+	// clock of the first client is ahead, its nonce is not evicted first
+	assert.NoError(t, g.Check(ts(30*time.Second), "ahead"))
+	assert.NoError(t, g.Check(ts(0), "a"))
+	assert.NoError(t, g.Check(ts(time.Second), "b"))
+	assert.ErrorIs(t, g.Check(ts(0), "a"), ErrStaleRequest)
+	assert.NoError(t, g.Check(ts(2*time.Second), "c"))
+	assert.ErrorIs(t, g.Check(ts(30*time.Second), "ahead"), ErrReplayedNonce)
+}
+
+func TestReplayGuard_OverflowWithinSecond(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	g := NewReplayGuard(time.Minute, 2, false)
+	g.now = func() time.Time { return now }
+	ts := strconv.FormatInt(now.Unix(), 10)
+
+	assert.NoError(t, g.Check(ts, "a"))
+	assert.NoError(t, g.Check(ts, "b"))
+	assert.NoError(t, g.Check(ts, "c"))
+	// one of nonces is evicted, none of them can be replayed
+	for _, nonce := range []string{"a", "b", "c"} {
+		assert.Error(t, g.Check(ts, nonce), nonce)
+	}
+	// the rest of second is rejected, the next one is accepted
+	assert.ErrorIs(t, g.Check(ts, "d"), ErrStaleRequest)
+	now = now.Add(time.Second)
+	assert.NoError(t, g.Check(strconv.FormatInt(now.Unix(), 10), "d"))
+}
+
+func TestNewReplayGuard_CacheSize(t *testing.T) {
+	assert.Equal(t, 10, NewReplayGuard(time.Minute, 10, false).nonces.size)
+	// window is two skews long
+	assert.Equal(t, 120*NonceRate, NewReplayGuard(time.Minute, 0, false).nonces.size)
+	assert.Equal(t, NonceRate, NewReplayGuard(time.Millisecond, 0, false).nonces.size)
+}
+
+func TestSigner_Replay(t *testing.T) {
+	key := []byte("key")
+	handler := Signer(string(key), NewReplayGuard(time.Minute, 10, false))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	body := []byte("test body")
+	timestamp, nonce, err := NewNonce()
+	assert.NoError(t, err)
+	do := func(sign string) int {
+		req := httptest.NewRequest(http.MethodPost, "/updates/", bytes.NewReader(body))
+		req.Header.Set("HashSHA256", sign)
+		req.Header.Set(TimestampHeader, timestamp)
+		req.Header.Set(NonceHeader, nonce)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	// timestamp and nonce are signed
+	assert.Equal(t, http.StatusBadRequest, do(CalcHash(body, key)))
+	assert.Equal(t, http.StatusOK, do(CalcHash(SignPayload(timestamp, nonce, body), key)))
+	// replayed request
+	assert.Equal(t, http.StatusBadRequest, do(CalcHash(SignPayload(timestamp, nonce, body), key)))
+}
@@ -0,0 +1,92 @@
+package middlewares
+
+import (
+	"crypto/rsa"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/SerjRamone/metrius/internal/auth"
+)
+
+// Security is a set of server secrets and access settings used by middlewares
+type Security struct {
+	// HashKey is a key of HMAC sign, empty key disables signing
+	HashKey string
+	// PrivKey is a RSA private key parsed once at startup, nil disables decryption
+	PrivKey *rsa.PrivateKey
+	// IPFilter limits agents IP, nil allows any IP
+	IPFilter *IPFilter
+	// Replay rejects replayed signed requests, nil disables the check
+	Replay *ReplayGuard
+	// Keys are own keys of agents, they are used instead of HashKey
+	Keys *KeyStore
+	// SignEnforce rejects requests without sign
+	SignEnforce bool
+	// Auth checks bearer tokens, nil disables authentication
+	Auth *auth.Authenticator
+}
+
+// Signed returns true if requests are checked by Signer
+func (s *Security) Signed() bool {
+	return s.HashKey != "" || s.Keys != nil || s.SignEnforce
+}
+
+// NewSecurity validates private key and creates Security, ipFilter is created by NewIPFilter
+func NewSecurity(hashKey string, privKey []byte, ipFilter *IPFilter) (Security, error) {
+	s := Security{HashKey: hashKey, IPFilter: ipFilter}
+	if len(privKey) > 0 {
+		key, err := ParsePrivateKey(privKey)
+		if err != nil {
+			return s, err
+		}
+		s.PrivKey = key
+	}
+	return s, nil
+}
+
+// SecurityHolder holds current Security, which can be replaced while server is running
+type SecurityHolder struct {
+	v atomic.Pointer[Security]
+}
+
+// NewSecurityHolder ...
+func NewSecurityHolder(s Security) *SecurityHolder {
+	h := &SecurityHolder{}
+	h.Store(s)
+	return h
+}
+
+// Load returns current Security, it must not be changed
+func (h *SecurityHolder) Load() *Security {
+	return h.v.Load()
+}
+
+// Store replaces current Security, requests in progress use previous one
+func (h *SecurityHolder) Store(s Security) {
+	h.v.Store(&s)
+}
+
+// Secure applies IPWhitelist, RequireScope, Crypto and Signer in this order with Security loaded from h,
+// so body of client which is not admitted is not decrypted. Empty scope means public endpoint without token check.
+// Security is loaded once per request, so replacing it doesn't affect requests in progress.
+func Secure(h *SecurityHolder, scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			s := h.Load()
+			handler := next
+			if s.Signed() {
+				handler = Signer(s.HashKey, s.Replay, WithKeyStore(s.Keys), WithEnforce(s.SignEnforce))(handler)
+			}
+			if s.PrivKey != nil {
+				handler = Crypto(s.PrivKey)(handler)
+			}
+			if scope != "" {
+				handler = requireScope(s.Auth, scope)(handler)
+			}
+			if s.IPFilter != nil {
+				handler = IPWhitelist(s.IPFilter)(handler)
+			}
+			handler.ServeHTTP(w, r)
+		})
+	}
+}
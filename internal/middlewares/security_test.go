@@ -0,0 +1,127 @@
+package middlewares
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/SerjRamone/metrius/internal/auth"
+)
+
+func TestNewSecurity(t *testing.T) {
+	tests := []struct {
+		name    string
+		privKey []byte
+		wantErr bool
+	}{
+		{name: "Test#1. Empty", wantErr: false},
+		{name: "Test#2. Invalid private key", privKey: []byte("not a key"), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewSecurity("key", tt.privKey, nil)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestSecure_Reload(t *testing.T) {
+	h := NewSecurityHolder(Security{HashKey: "old"})
+	handler := Secure(h, "")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	body := []byte("test body")
+	do := func(key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/updates/", bytes.NewReader(body))
+		req.Header.Set("HashSHA256", CalcHash(body, []byte(key)))
+		req.RemoteAddr = "10.0.0.1:1234"
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	assert.Equal(t, http.StatusOK, do("old").Code)
+
+	f, err := NewIPFilter("192.168.1.0/24", "", "")
+	require.NoError(t, err)
+	s, err := NewSecurity("new", nil, f)
+	require.NoError(t, err)
+	h.Store(s)
+	assert.Equal(t, http.StatusForbidden, do("old").Code)
+	assert.Equal(t, http.StatusForbidden, do("new").Code)
+
+	f, err = NewIPFilter("10.0.0.0/8", "", "")
+	require.NoError(t, err)
+	s, err = NewSecurity("new", nil, f)
+	require.NoError(t, err)
+	h.Store(s)
+	assert.Equal(t, http.StatusBadRequest, do("old").Code)
+	assert.Equal(t, http.StatusOK, do("new").Code)
+}
+
+func TestSecure_Order(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	a, err := auth.NewAuthenticator([]auth.Token{
+		{Token: "writer", Subject: "agent", Scopes: []string{auth.ScopeWrite}},
+	}, "", nil)
+	require.NoError(t, err)
+	f, err := NewIPFilter("10.0.0.0/8", "", "")
+	require.NoError(t, err)
+	h := NewSecurityHolder(Security{HashKey: "key", PrivKey: key, IPFilter: f, Auth: a})
+
+	var got []byte
+	handler := Secure(h, auth.ScopeWrite)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusOK)
+	}))
+	body := []byte("test body")
+	envelope, encKey, err := Encrypt(&key.PublicKey, body)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		token      string
+		encKey     string
+		sign       string
+		wantCode   int
+	}{
+		// broken envelope gives 400 if it's decrypted, so these clients are rejected before decryption
+		{name: "Test#1. Disallowed IP", remoteAddr: "172.16.0.1:1234", token: "writer", encKey: "broken", wantCode: http.StatusForbidden},
+		{name: "Test#2. Unauthenticated", remoteAddr: "10.0.0.1:1234", token: "unknown", encKey: "broken", wantCode: http.StatusUnauthorized},
+		{name: "Test#3. Broken envelope", remoteAddr: "10.0.0.1:1234", token: "writer", encKey: "broken", wantCode: http.StatusBadRequest},
+		// sign covers decrypted body
+		{name: "Test#4. Sign of decrypted body", remoteAddr: "10.0.0.1:1234", token: "writer", encKey: encKey, sign: CalcHash(body, []byte("key")), wantCode: http.StatusOK},
+		{name: "Test#5. Sign of encrypted body", remoteAddr: "10.0.0.1:1234", token: "writer", encKey: encKey, sign: CalcHash(envelope, []byte("key")), wantCode: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got = nil
+			req := httptest.NewRequest(http.MethodPost, "/updates/", bytes.NewReader(envelope))
+			req.RemoteAddr = tt.remoteAddr
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+			req.Header.Set(EncryptedKeyHeader, tt.encKey)
+			if tt.sign != "" {
+				req.Header.Set("HashSHA256", tt.sign)
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+			assert.Equal(t, tt.wantCode, rr.Code)
+			if tt.wantCode == http.StatusOK {
+				assert.Equal(t, body, got)
+			}
+		})
+	}
+}
@@ -13,15 +13,48 @@ import (
 	"github.com/SerjRamone/metrius/pkg/logger"
 )
 
-// Signer adds header with hash key
-func Signer(hashKey string) func(http.Handler) http.Handler {
+// SignerOption configures Signer
+type SignerOption func(*signer)
+
+// WithKeyStore checks requests of known agents with their own keys
+func WithKeyStore(store *KeyStore) SignerOption {
+	return func(s *signer) {
+		s.keys = store
+	}
+}
+
+// WithEnforce rejects requests without sign
+func WithEnforce(enforce bool) SignerOption {
+	return func(s *signer) {
+		s.enforce = enforce
+	}
+}
+
+// signer is a config of Signer
+type signer struct {
+	hashKey string
+	guard   *ReplayGuard
+	keys    *KeyStore
+	enforce bool
+}
+
+// Signer checks HashSHA256 header of request and adds it to response.
+// If guard is set, signed requests must have unique nonce and timestamp within clock skew window,
+// they are signed together with body. Response is signed with the key of request.
+func Signer(hashKey string, guard *ReplayGuard, opts ...SignerOption) func(http.Handler) http.Handler {
+	cfg := &signer{hashKey: hashKey, guard: guard}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			headerHash := r.Header.Get("HashSHA256")
 			srw := &signResponseWriter{
 				ResponseWriter: w,
-				HashKey:        hashKey,
+				HashKey:        cfg.hashKey,
 				Body:           new(bytes.Buffer),
+				status:         http.StatusOK,
 			}
 			if headerHash != "" {
 				logger.Info("request with hash header", zap.String("hash", headerHash))
@@ -31,20 +64,41 @@ func Signer(hashKey string) func(http.Handler) http.Handler {
 				// set unread body
 				r.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
 
-				// calculate body hash with key
-				b64Hash := CalcHash(bodyBytes, []byte(hashKey))
-				logger.Info("hashes", zap.String("calculated", string(b64Hash)), zap.String("header", headerHash))
+				// calculate body hash with key, timestamp and nonce are signed if they are set
+				timestamp, nonce := r.Header.Get(TimestampHeader), r.Header.Get(NonceHeader)
+				payload := bodyBytes
+				if timestamp != "" || nonce != "" {
+					payload = SignPayload(timestamp, nonce, bodyBytes)
+				}
 
-				// compare hashes calculated and from request header
-				if string(b64Hash) != headerHash {
+				// compare hashes calculated with keys of agent and from request header
+				agentID := r.Header.Get(AgentIDHeader)
+				key, ok := MatchSign(SignKeys(cfg.hashKey, cfg.keys, agentID), payload, headerHash)
+				if !ok {
+					logger.Warn("invalid request sign", zap.String("agent", agentID))
 					http.Error(w, "invalid sign", http.StatusBadRequest)
 					return
 				}
+				srw.HashKey = key
+
+				// sign is valid, check that request is not replayed
+				if cfg.guard != nil {
+					if err := cfg.guard.Check(timestamp, nonce); err != nil {
+						logger.Warn("request replay check failed", zap.String("nonce", nonce), zap.Error(err))
+						http.Error(w, err.Error(), http.StatusBadRequest)
+						return
+					}
+				}
 			} else {
+				if cfg.enforce {
+					http.Error(w, "sign required", http.StatusUnauthorized)
+					return
+				}
 				logger.Info("request without 'HashSHA256' header")
 			}
 
 			next.ServeHTTP(srw, r)
+			srw.flush()
 		})
 	}
 }
@@ -58,22 +112,36 @@ func CalcHash(body, key []byte) string {
 	return base64.StdEncoding.EncodeToString(calculatedHash)
 }
 
+// hmacEqual compares signs in constant time
+func hmacEqual(a, b string) bool {
+	return hmac.Equal([]byte(a), []byte(b))
+}
+
+// signResponseWriter buffers response to sign the whole body
 type signResponseWriter struct {
 	http.ResponseWriter
 	Body    *bytes.Buffer
 	HashKey string
+	status  int
 }
 
 func (rw *signResponseWriter) Write(b []byte) (int, error) {
-	rw.Body.Write(b)
-	return rw.ResponseWriter.Write(b)
+	return rw.Body.Write(b)
 }
 
 func (rw *signResponseWriter) WriteHeader(code int) {
-	logger.Info("response status", zap.Int("status", code))
-	if code == 200 {
+	rw.status = code
+}
+
+// flush signs successful response and writes it
+func (rw *signResponseWriter) flush() {
+	logger.Info("response status", zap.Int("status", rw.status))
+	if rw.status >= 200 && rw.status <= 299 && rw.HashKey != "" {
 		b64Hash := CalcHash(rw.Body.Bytes(), []byte(rw.HashKey))
-		rw.ResponseWriter.Header().Add("HashSHA256", b64Hash)
+		rw.ResponseWriter.Header().Set("HashSHA256", b64Hash)
+	}
+	rw.ResponseWriter.WriteHeader(rw.status)
+	if _, err := rw.ResponseWriter.Write(rw.Body.Bytes()); err != nil {
+		logger.Error("response write error", zap.Error(err))
 	}
-	rw.ResponseWriter.WriteHeader(code)
 }
@@ -36,7 +36,7 @@ func TestSignerMiddleware(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			middleware := Signer(testKey)
+			middleware := Signer(testKey, nil)
 
 			req, err := http.NewRequest("POST", "/test", bytes.NewBuffer(tc.requestBody))
 			if err != nil {
@@ -46,16 +46,18 @@ func TestSignerMiddleware(t *testing.T) {
 
 			rr := httptest.NewRecorder()
 			handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-				fmt.Println("testing")
+				w.WriteHeader(http.StatusOK)
+				fmt.Fprint(w, "response body")
 			})
 			middleware(handler).ServeHTTP(rr, req)
 
 			assert.Equal(t, tc.expectedStatusCode, rr.Code, "status code doesn't match")
-			//
-			// if tc.expectedStatusCode == http.StatusOK {
-			// 	expectedHash := CalcHash(tc.requestBody, []byte(testKey))
-			// 	assert.Equal(t, expectedHash, rr.Header().Get("HashSHA256"), "hash in response doesn't match")
-			// }
+
+			if tc.expectedStatusCode == http.StatusOK {
+				expectedHash := CalcHash([]byte("response body"), []byte(testKey))
+				assert.Equal(t, expectedHash, rr.Header().Get("HashSHA256"), "hash in response doesn't match")
+				assert.Equal(t, "response body", rr.Body.String())
+			}
 		})
 	}
 }
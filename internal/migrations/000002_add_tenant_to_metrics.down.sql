@@ -0,0 +1,9 @@
+BEGIN;
+  DELETE FROM metrics WHERE tenant <> 'default';
+
+  ALTER TABLE metrics DROP CONSTRAINT IF EXISTS metrics_pkey;
+  ALTER TABLE metrics ADD PRIMARY KEY (id);
+  ALTER TABLE metrics DROP COLUMN IF EXISTS tenant;
+
+  COMMENT ON COLUMN metrics.id IS 'Unique metrics ID';
+COMMIT;
@@ -0,0 +1,9 @@
+BEGIN;
+  ALTER TABLE metrics ADD COLUMN IF NOT EXISTS tenant VARCHAR(50) NOT NULL DEFAULT 'default';
+
+  ALTER TABLE metrics DROP CONSTRAINT IF EXISTS metrics_pkey;
+  ALTER TABLE metrics ADD PRIMARY KEY (tenant, id);
+
+  COMMENT ON COLUMN metrics.tenant IS 'Tenant of metrics';
+  COMMENT ON COLUMN metrics.id IS 'Metrics ID, unique for tenant';
+COMMIT;
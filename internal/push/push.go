@@ -0,0 +1,175 @@
+// Package push receives metrics from applications on the same host
+// and passes them to the agent collector
+package push
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+
+	"github.com/SerjRamone/metrius/internal/metrics"
+	"github.com/SerjRamone/metrius/internal/middlewares"
+	"github.com/SerjRamone/metrius/pkg/logger"
+)
+
+// Router creates router with the same JSON endpoints as metrics server has.
+// Received metrics are passed to add.
+func Router(add func(metrics.Collection)) chi.Router {
+	r := chi.NewRouter()
+	r.Use(middlewares.RequestLogger)
+	r.Use(middlewares.GzipCompressor)
+
+	r.Post("/update/", updateJSON(add))
+	r.Post("/updates/", updates(add))
+
+	return r
+}
+
+// updateJSON handles single metrics in JSON format, see handlers.UpdateJSON
+func updateJSON(add func(metrics.Collection)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != "application/json" {
+			http.Error(w, "Bad content-type", http.StatusBadRequest)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			logger.Info("request body reading error", zap.Error(err))
+			http.Error(w, "Request body reading error", http.StatusBadRequest)
+			return
+		}
+
+		var m metrics.Metrics
+		if err = json.Unmarshal(body, &m); err != nil {
+			logger.Info("cannot decode request JSON body", zap.Error(err))
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+
+		c, err := metrics.CollectionFromMetrics([]metrics.Metrics{m})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		add(c)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if _, err = w.Write(body); err != nil {
+			logger.Error("can't write response", zap.Error(err))
+		}
+	}
+}
+
+// updates handles batch of metrics in JSON format, see handlers.Updates
+func updates(add func(metrics.Collection)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != "application/json" {
+			http.Error(w, "Bad content-type", http.StatusBadRequest)
+			return
+		}
+
+		var batch []metrics.Metrics
+		if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+			logger.Info("cannot decode request JSON body", zap.Error(err))
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+
+		c, err := metrics.CollectionFromMetrics(batch)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		add(c)
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// Server serves push endpoints on TCP address and/or Unix socket
+type Server struct {
+	address string
+	socket  string
+	handler http.Handler
+	servers []*http.Server
+	wg      sync.WaitGroup
+}
+
+// NewServer creates push Server. Empty address or socket disables the listener.
+func NewServer(address, socket string, add func(metrics.Collection)) *Server {
+	return &Server{
+		address: address,
+		socket:  socket,
+		handler: Router(add),
+	}
+}
+
+// Up opens listeners and starts serving in background
+func (s *Server) Up() error {
+	listeners := make([]net.Listener, 0, 2)
+	if s.address != "" {
+		l, err := net.Listen("tcp", s.address)
+		if err != nil {
+			return err
+		}
+		listeners = append(listeners, l)
+	}
+	if s.socket != "" {
+		// remove socket left by previous run
+		if err := os.Remove(s.socket); err != nil && !errors.Is(err, os.ErrNotExist) {
+			closeListeners(listeners)
+			return err
+		}
+		l, err := net.Listen("unix", s.socket)
+		if err != nil {
+			closeListeners(listeners)
+			return err
+		}
+		listeners = append(listeners, l)
+	}
+
+	for _, l := range listeners {
+		srv := &http.Server{Handler: s.handler}
+		s.servers = append(s.servers, srv)
+		s.wg.Add(1)
+		go func(l net.Listener) {
+			defer s.wg.Done()
+			logger.Info("push server started", zap.String("address", l.Addr().String()))
+			if err := srv.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				logger.Error("push server error", zap.Error(err))
+			}
+		}(l)
+	}
+	return nil
+}
+
+// closeListeners closes listeners which are opened before error
+func closeListeners(listeners []net.Listener) {
+	for _, l := range listeners {
+		if err := l.Close(); err != nil {
+			logger.Error("push listener close error", zap.Error(err))
+		}
+	}
+}
+
+// Down gracefully stops all listeners
+func (s *Server) Down(ctx context.Context) error {
+	var errs []error
+	for _, srv := range s.servers {
+		if err := srv.Shutdown(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	s.wg.Wait()
+	return errors.Join(errs...)
+}
@@ -0,0 +1,136 @@
+package push
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/SerjRamone/metrius/internal/metrics"
+)
+
+// recorder stores received collections
+type recorder struct {
+	mu          sync.Mutex
+	collections []metrics.Collection
+}
+
+func (r *recorder) add(c metrics.Collection) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collections = append(r.collections, c)
+}
+
+func TestRouter(t *testing.T) {
+	testCases := []struct {
+		name        string
+		path        string
+		body        string
+		contentType string
+		wantCode    int
+		want        []metrics.Collection
+	}{
+		{
+			name:        "Test#1. Single gauge",
+			path:        "/update/",
+			body:        `{"id":"Requests","type":"gauge","value":1.5}`,
+			contentType: "application/json",
+			wantCode:    http.StatusOK,
+			want:        []metrics.Collection{{{Name: "Requests", Type: "gauge", Value: 1.5}}},
+		},
+		{
+			name:        "Test#2. Batch",
+			path:        "/updates/",
+			body:        `[{"id":"Requests","type":"counter","delta":3},{"id":"Latency","type":"gauge","value":0.2}]`,
+			contentType: "application/json",
+			wantCode:    http.StatusOK,
+			want: []metrics.Collection{{
+				{Name: "Requests", Type: "counter", Value: 3},
+				{Name: "Latency", Type: "gauge", Value: 0.2},
+			}},
+		},
+		{
+			name:        "Test#3. Bad content type",
+			path:        "/update/",
+			body:        `{"id":"Requests","type":"gauge","value":1.5}`,
+			contentType: "text/plain",
+			wantCode:    http.StatusBadRequest,
+		},
+		{
+			name:        "Test#4. Counter without delta",
+			path:        "/update/",
+			body:        `{"id":"Requests","type":"counter","value":1}`,
+			contentType: "application/json",
+			wantCode:    http.StatusBadRequest,
+		},
+		{
+			name:        "Test#5. Invalid JSON",
+			path:        "/updates/",
+			body:        `[{"id":`,
+			contentType: "application/json",
+			wantCode:    http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := &recorder{}
+			req := httptest.NewRequest(http.MethodPost, tc.path, bytes.NewBufferString(tc.body))
+			req.Header.Set("Content-Type", tc.contentType)
+			rr := httptest.NewRecorder()
+
+			Router(rec.add).ServeHTTP(rr, req)
+
+			assert.Equal(t, tc.wantCode, rr.Code)
+			assert.Equal(t, tc.want, rec.collections)
+		})
+	}
+}
+
+func TestServer_UnixSocket(t *testing.T) {
+	rec := &recorder{}
+	socket := filepath.Join(t.TempDir(), "agent.sock")
+	s := NewServer("", socket, rec.add)
+	require.NoError(t, s.Up())
+	defer func() {
+		assert.NoError(t, s.Down(context.Background()))
+	}()
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+			},
+		},
+	}
+	resp, err := client.Post("http://agent/update/", "application/json",
+		bytes.NewBufferString(`{"id":"Requests","type":"counter","delta":1}`))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, []metrics.Collection{{{Name: "Requests", Type: "counter", Value: 1}}}, rec.collections)
+}
+
+func TestServer_UpError(t *testing.T) {
+	// socket in missing directory can't be listened
+	socket := filepath.Join(t.TempDir(), "missing", "agent.sock")
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := l.Addr().String()
+	require.NoError(t, l.Close())
+
+	// the TCP listener opened before error is closed, so the address can be used again
+	s := NewServer(addr, socket, (&recorder{}).add)
+	require.Error(t, s.Up())
+	l, err = net.Listen("tcp", addr)
+	require.NoError(t, err)
+	assert.NoError(t, l.Close())
+}
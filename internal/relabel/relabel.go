@@ -0,0 +1,137 @@
+// Package relabel filters and rewrites metrics before sending
+package relabel
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/SerjRamone/metrius/internal/metrics"
+)
+
+// Rule actions
+const (
+	// Drop removes metrics matching the rule
+	Drop = "drop"
+	// Keep removes metrics not matching the rule (allow-list mode)
+	Keep = "keep"
+	// Rename replaces name of matching metrics with Replacement, $1 and ${name} refer to regexp groups
+	Rename = "rename"
+	// Scale multiplies value of matching gauges by Factor or converts them to Unit.
+	// Counters are integer deltas and are not scaled.
+	Scale = "scale"
+)
+
+// units are factors of supported conversions
+var units = map[string]float64{
+	"bytes_to_kib": 1.0 / (1 << 10),
+	"bytes_to_mib": 1.0 / (1 << 20),
+	"bytes_to_gib": 1.0 / (1 << 30),
+	"ns_to_us":     1e-3,
+	"ns_to_ms":     1e-6,
+	"ns_to_s":      1e-9,
+}
+
+// Rule describes one step of relabeling
+type Rule struct {
+	Action string
+	// Match is a regexp, it must match the whole metrics name
+	Match       string
+	Replacement string
+	Factor      float64
+	Unit        string
+}
+
+// rule is a compiled Rule
+type rule struct {
+	action      string
+	re          *regexp.Regexp
+	replacement string
+	factor      float64
+}
+
+// relabeler applies rules in order to every metrics
+type relabeler struct {
+	rules []rule
+}
+
+// New compiles rules
+func New(rules []Rule) (*relabeler, error) {
+	compiled := make([]rule, 0, len(rules))
+	for i, r := range rules {
+		// empty regexp matches empty names only, so rule would do nothing
+		if r.Match == "" {
+			return nil, fmt.Errorf("rule %d: match not set", i)
+		}
+		re, err := regexp.Compile("^(?:" + r.Match + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: match regexp error: %w", i, err)
+		}
+		c := rule{action: r.Action, re: re, replacement: r.Replacement, factor: r.Factor}
+
+		switch r.Action {
+		case Drop, Keep:
+		case Rename:
+			if r.Replacement == "" {
+				return nil, fmt.Errorf("rule %d: replacement not set", i)
+			}
+		case Scale:
+			if r.Unit != "" {
+				factor, ok := units[r.Unit]
+				if !ok {
+					return nil, fmt.Errorf("rule %d: unknown unit conversion: %s", i, r.Unit)
+				}
+				c.factor = factor
+			}
+			if c.factor == 0 {
+				return nil, fmt.Errorf("rule %d: factor or unit not set", i)
+			}
+		default:
+			return nil, fmt.Errorf("rule %d: unknown action: %s", i, r.Action)
+		}
+		compiled = append(compiled, c)
+	}
+	return &relabeler{rules: compiled}, nil
+}
+
+// Apply returns collections with rules applied, empty collections are removed
+func (r *relabeler) Apply(collections []metrics.Collection) []metrics.Collection {
+	result := make([]metrics.Collection, 0, len(collections))
+	for _, collection := range collections {
+		c := make(metrics.Collection, 0, len(collection))
+		for _, item := range collection {
+			if item, ok := r.apply(item); ok {
+				c = append(c, item)
+			}
+		}
+		if len(c) > 0 {
+			result = append(result, c)
+		}
+	}
+	return result
+}
+
+// apply applies rules to item, returns false if item is dropped
+func (r *relabeler) apply(item metrics.CollectionItem) (metrics.CollectionItem, bool) {
+	for _, rule := range r.rules {
+		matched := rule.re.MatchString(item.Name)
+		switch rule.action {
+		case Drop:
+			if matched {
+				return item, false
+			}
+		case Keep:
+			if !matched {
+				return item, false
+			}
+		case Rename:
+			if matched {
+				item.Name = rule.re.ReplaceAllString(item.Name, rule.replacement)
+			}
+		case Scale:
+			if matched && item.Type == "gauge" {
+				item.Value *= rule.factor
+			}
+		}
+	}
+	return item, true
+}
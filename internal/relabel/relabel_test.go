@@ -0,0 +1,78 @@
+package relabel
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/SerjRamone/metrius/internal/metrics"
+)
+
+func TestRelabeler_Apply(t *testing.T) {
+	collections := []metrics.Collection{
+		{
+			{Name: "Alloc", Type: "gauge", Value: 3 << 20},
+			{Name: "HeapIdle", Type: "gauge", Value: 1 << 20},
+			{Name: "PollCount", Type: "counter", Value: 1},
+			{Name: "RandomValue", Type: "gauge", Value: 0.5},
+		},
+		{
+			{Name: "RandomValue", Type: "gauge", Value: 0.7},
+		},
+	}
+
+	tests := []struct {
+		name  string
+		rules []Rule
+		want  []metrics.Collection
+	}{
+		{
+			name:  "Test#1. Drop",
+			rules: []Rule{{Action: Drop, Match: "Heap.*|RandomValue"}},
+			want: []metrics.Collection{{
+				{Name: "Alloc", Type: "gauge", Value: 3 << 20},
+				{Name: "PollCount", Type: "counter", Value: 1},
+			}},
+		},
+		{
+			name: "Test#2. Keep, rename and scale",
+			rules: []Rule{
+				{Action: Keep, Match: "Alloc|PollCount"},
+				{Action: Scale, Match: "Alloc|PollCount", Unit: "bytes_to_mib"},
+				{Action: Rename, Match: ".*", Replacement: "go_runtime_$0"},
+			},
+			want: []metrics.Collection{{
+				{Name: "go_runtime_Alloc", Type: "gauge", Value: 3},
+				{Name: "go_runtime_PollCount", Type: "counter", Value: 1},
+			}},
+		},
+		{
+			name:  "Test#3. Match is anchored",
+			rules: []Rule{{Action: Drop, Match: "Heap"}},
+			want:  collections,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := New(tt.rules)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, r.Apply(collections))
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	_, err := New([]Rule{{Action: "replace", Match: ".*"}})
+	assert.Error(t, err)
+	_, err = New([]Rule{{Action: Drop}})
+	assert.Error(t, err)
+	_, err = New([]Rule{{Action: Drop, Match: "("}})
+	assert.Error(t, err)
+	_, err = New([]Rule{{Action: Rename, Match: ".*"}})
+	assert.Error(t, err)
+	_, err = New([]Rule{{Action: Scale, Match: ".*", Unit: "furlongs"}})
+	assert.Error(t, err)
+	_, err = New([]Rule{{Action: Scale, Match: ".*", Factor: 0.001}})
+	assert.NoError(t, err)
+}
@@ -0,0 +1,117 @@
+package sender
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/SerjRamone/metrius/pkg/logger"
+)
+
+const (
+	breakerDefaultThreshold   = 5
+	breakerDefaultOpenTimeout = 30 * time.Second
+)
+
+// ErrCircuitOpen is returned while destination is considered unavailable
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// states of circuit breaker
+const (
+	stateClosed = iota
+	stateOpen
+	stateHalfOpen
+)
+
+// breaker is a circuit breaker of one destination.
+// It opens after threshold consecutive failures and rejects calls during openTimeout,
+// then lets a single probe call through. Successful probe closes the circuit.
+type breaker struct {
+	name        string
+	threshold   int
+	openTimeout time.Duration
+
+	mu       sync.Mutex
+	state    int
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+// newBreaker creates circuit breaker for destination with name
+func newBreaker(name string) *breaker {
+	return &breaker{
+		name:        name,
+		threshold:   breakerDefaultThreshold,
+		openTimeout: breakerDefaultOpenTimeout,
+	}
+}
+
+// call calls fn if circuit is not open.
+// Only errors classified as retryable are counted as failures.
+func (b *breaker) call(fn func() error) error {
+	probe, err := b.allow()
+	if err != nil {
+		return err
+	}
+	err = fn()
+	b.done(probe, err == nil || !isRetryable(err))
+	return err
+}
+
+// allow checks if call is permitted and returns true if call is a half-open probe
+func (b *breaker) allow() (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case stateOpen:
+		if time.Since(b.openedAt) < b.openTimeout {
+			return false, ErrCircuitOpen
+		}
+		b.state = stateHalfOpen
+		b.probing = true
+		logger.Info("circuit breaker half-open", zap.String("destination", b.name))
+		return true, nil
+	case stateHalfOpen:
+		// only one probe at a time
+		if b.probing {
+			return false, ErrCircuitOpen
+		}
+		b.probing = true
+		return true, nil
+	}
+	return false, nil
+}
+
+// done records call result.
+// Results of calls started before the circuit opened are ignored until the probe completes.
+func (b *breaker) done(probe, success bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if probe {
+		b.probing = false
+	} else if b.state != stateClosed {
+		return
+	}
+	if success {
+		if b.state != stateClosed {
+			logger.Info("circuit breaker closed", zap.String("destination", b.name))
+		}
+		b.state = stateClosed
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	if b.state == stateHalfOpen || b.failures >= b.threshold {
+		if b.state != stateOpen {
+			logger.Warn("circuit breaker opened", zap.String("destination", b.name), zap.Int("failures", b.failures))
+		}
+		b.state = stateOpen
+		b.openedAt = time.Now()
+	}
+}
@@ -0,0 +1,67 @@
+package sender
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBreaker(t *testing.T) {
+	b := newBreaker("test")
+	b.threshold = 2
+	b.openTimeout = time.Hour
+
+	errUnavailable := &StatusError{Code: http.StatusServiceUnavailable}
+	fail := func() error { return errUnavailable }
+	ok := func() error { return nil }
+
+	// not retryable errors are not counted
+	for i := 0; i < 3; i++ {
+		assert.Error(t, b.call(func() error { return &StatusError{Code: http.StatusBadRequest} }))
+	}
+	assert.NoError(t, b.call(ok))
+
+	assert.ErrorIs(t, b.call(fail), errUnavailable)
+	assert.ErrorIs(t, b.call(fail), errUnavailable)
+	// circuit is open, fn is not called
+	assert.ErrorIs(t, b.call(ok), ErrCircuitOpen)
+
+	// failed probe opens circuit again
+	b.openedAt = time.Now().Add(-2 * time.Hour)
+	assert.ErrorIs(t, b.call(fail), errUnavailable)
+	assert.ErrorIs(t, b.call(ok), ErrCircuitOpen)
+
+	// successful probe closes circuit
+	b.openedAt = time.Now().Add(-2 * time.Hour)
+	assert.NoError(t, b.call(ok))
+	assert.NoError(t, b.call(ok))
+}
+
+func TestBreaker_SlowCall(t *testing.T) {
+	b := newBreaker("test")
+	b.threshold = 1
+	b.openTimeout = time.Hour
+
+	// slow call is started before circuit opens
+	slow, err := b.allow()
+	assert.NoError(t, err)
+	assert.False(t, slow)
+	b.done(false, false)
+
+	b.openedAt = time.Now().Add(-2 * time.Hour)
+	probe, err := b.allow()
+	assert.NoError(t, err)
+	assert.True(t, probe)
+
+	// completion of slow call doesn't let the second probe through and doesn't close circuit
+	b.done(slow, true)
+	_, err = b.allow()
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+
+	// probe result closes circuit
+	b.done(probe, true)
+	_, err = b.allow()
+	assert.NoError(t, err)
+}
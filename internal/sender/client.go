@@ -10,25 +10,190 @@ import (
 	"io"
 	"net"
 	"net/http"
+	"net/url"
+	"strings"
 
+	"github.com/SerjRamone/metrius/internal/auth"
 	"github.com/SerjRamone/metrius/internal/metrics"
+	"github.com/SerjRamone/metrius/internal/middlewares"
+	"github.com/SerjRamone/metrius/internal/telemetry"
+	"github.com/SerjRamone/metrius/internal/tenant"
 	"github.com/SerjRamone/metrius/pkg/logger"
 	pb "github.com/SerjRamone/metrius/pkg/metrius_v1"
 	"github.com/SerjRamone/metrius/pkg/retry"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
 )
 
 // APIClient ...
 type APIClient interface {
-	Do(metrics.CollectionItem) error
-	DoBatch([]metrics.Collection) error
+	Do(context.Context, metrics.CollectionItem) error
+	DoBatch(context.Context, []metrics.Collection) error
+}
+
+// errors of sending
+var (
+	// ErrInvalidSign is returned when server rejected request sign
+	ErrInvalidSign = errors.New("server rejected request sign")
+	// ErrForbidden is returned when server forbade request, f.e. agent IP is not trusted
+	ErrForbidden = errors.New("request forbidden by server")
+	// ErrInsufficientScope is returned when agent token has no scope required by server
+	ErrInsufficientScope = errors.New("insufficient scope of agent token")
+	// ErrSeriesLimit is returned when series limit of agent tenant is exceeded
+	ErrSeriesLimit = errors.New("tenant series limit exceeded")
+	// ErrResponseSign is returned when response sign is invalid
+	ErrResponseSign = errors.New("invalid response sign")
+)
+
+// maxErrorBodySize limits response body snippet in StatusError
+const maxErrorBodySize = 256
+
+// StatusError is returned when server responds with non-2xx status code
+type StatusError struct {
+	Code int
+	// Body is a beginning of response body
+	Body string
+}
+
+// Error ...
+func (e *StatusError) Error() string {
+	msg := fmt.Sprintf("unexpected response status: %d %s", e.Code, http.StatusText(e.Code))
+	if e.Body != "" {
+		msg += ": " + e.Body
+	}
+	return msg
+}
+
+// Unwrap returns ErrInvalidSign or one of forbidden errors for matching responses
+func (e *StatusError) Unwrap() error {
+	switch {
+	case e.Code == http.StatusForbidden:
+		return forbiddenError(e.Body)
+	case e.Code == http.StatusBadRequest && strings.Contains(e.Body, "invalid sign"):
+		return ErrInvalidSign
+	}
+	return nil
+}
+
+// forbiddenError returns error matching message of server forbidden response
+func forbiddenError(msg string) error {
+	switch {
+	case strings.Contains(msg, auth.ErrForbidden.Error()):
+		return ErrInsufficientScope
+	case strings.Contains(msg, tenant.ErrSeriesLimit.Error()):
+		return ErrSeriesLimit
+	}
+	return ErrForbidden
+}
+
+// grpcError wraps gRPC status error with ErrInvalidSign or one of forbidden errors,
+// so both clients report the same errors
+func grpcError(err error) error {
+	s, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+	switch {
+	case s.Code() == codes.PermissionDenied,
+		s.Code() == codes.FailedPrecondition && strings.Contains(s.Message(), tenant.ErrSeriesLimit.Error()):
+		return fmt.Errorf("%w: %w", forbiddenError(s.Message()), err)
+	case s.Code() == codes.InvalidArgument && strings.Contains(s.Message(), "invalid sign"):
+		return fmt.Errorf("%w: %w", ErrInvalidSign, err)
+	}
+	return err
+}
+
+// isRetryable classifies errors of sending:
+// network errors, HTTP 5xx and 429 statuses, gRPC Unavailable, ResourceExhausted
+// and Aborted codes are temporary, others are permanent
+func isRetryable(err error) bool {
+	if errors.Is(err, ErrCircuitOpen) || errors.Is(err, ErrResponseSign) || errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Code >= 500 || statusErr.Code == http.StatusTooManyRequests
+	}
+
+	if s, ok := grpcStatus(err); ok && s.Code() != codes.Unknown {
+		switch s.Code() {
+		case codes.Unavailable, codes.ResourceExhausted, codes.Aborted:
+			return true
+		}
+		return false
+	}
+
+	// *url.Error is a net.Error itself, check the underlying error
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		err = urlErr.Err
+	}
+	var netError net.Error
+	return errors.As(err, &netError)
+}
+
+// grpcStatus returns gRPC status of err, unlike status.FromError it unwraps err
+func grpcStatus(err error) (*status.Status, bool) {
+	var grpcErr interface{ GRPCStatus() *status.Status }
+	if errors.As(err, &grpcErr) {
+		return grpcErr.GRPCStatus(), true
+	}
+	return nil, false
+}
+
+// isRejected returns true if server answered with permanent error, so batch is dropped instead of resending:
+// sign and forbidden errors, HTTP 4xx statuses except 429 and not retryable gRPC codes.
+// Joined errors of destinations are rejected if all of them are rejected.
+func isRejected(err error) bool {
+	var partial *PartialError
+	if errors.As(err, &partial) {
+		return isRejected(partial.Err)
+	}
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		errs := joined.Unwrap()
+		for _, e := range errs {
+			if !isRejected(e) {
+				return false
+			}
+		}
+		return len(errs) > 0
+	}
+	if errors.Is(err, ErrResponseSign) {
+		return true
+	}
+
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return !isRetryable(statusErr)
+	}
+	if s, ok := grpcStatus(err); ok {
+		switch s.Code() {
+		case codes.OK, codes.Unknown, codes.Canceled, codes.DeadlineExceeded:
+			return false
+		}
+		return !isRetryable(err)
+	}
+	return false
+}
+
+// newRetryPolicy returns retry policy of clients
+func newRetryPolicy() retry.Policy {
+	p := retry.DefaultPolicy()
+	p.Retryable = isRetryable
+	return p
 }
 
 // HTTPApiClient ...
 type HTTPApiClient struct {
 	client  *http.Client
+	policy  retry.Policy
+	breaker *breaker
 	sURL    string
 	localIP string
 }
@@ -37,44 +202,88 @@ type HTTPApiClient struct {
 func NewHTTPApiClient(sURL, localIP string) *HTTPApiClient {
 	return &HTTPApiClient{
 		client:  &http.Client{},
+		policy:  newRetryPolicy(),
+		breaker: newBreaker(sURL),
 		sURL:    sURL,
 		localIP: localIP,
 	}
 }
 
 // Do sends metrics to server
-func (c *HTTPApiClient) Do(m metrics.CollectionItem) error {
-	url := fmt.Sprintf("%s/update/", c.sURL)
+func (c *HTTPApiClient) Do(ctx context.Context, m metrics.CollectionItem) error {
+	data, err := json.Marshal(toMetrics(m))
+	if err != nil {
+		logger.Error("metrics encode error", zap.Error(err))
+		return err
+	}
 
-	item := metrics.Metrics{
-		ID:    m.Name,
-		MType: m.Type,
+	if err = c.send(ctx, "/update/", data); err != nil {
+		logger.Error("send metrics error", zap.Error(err))
+		return err
 	}
+	return nil
+}
 
-	switch m.Type {
-	case "gauge":
-		item.Value = &m.Value
-	case "counter":
-		tmp := int64(m.Value)
-		item.Delta = &tmp
+// Close closes idle connections
+func (c *HTTPApiClient) Close() error {
+	c.client.CloseIdleConnections()
+	return nil
+}
+
+// DoBatch sends metrics to server
+func (c *HTTPApiClient) DoBatch(ctx context.Context, collections []metrics.Collection) error {
+	batch := make([]metrics.Metrics, 0, 200)
+	// collect batch of metrics.Metrics
+	for _, c := range collections {
+		for _, m := range c {
+			batch = append(batch, toMetrics(m))
+		}
 	}
 
-	data, err := json.Marshal(item)
+	if len(batch) == 0 {
+		return nil
+	}
+
+	data, err := json.Marshal(batch)
 	if err != nil {
 		logger.Error("metrics encode error", zap.Error(err))
 		return err
 	}
 
+	if err = c.send(ctx, "/updates/", data); err != nil {
+		logger.Error("send metrics in batch error", zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// send posts data to server with retries
+func (c *HTTPApiClient) send(ctx context.Context, path string, data []byte) error {
+	// compress once, request body is recreated for every attempt
 	var b bytes.Buffer
 	gw := gzip.NewWriter(&b)
-	if _, err = gw.Write(data); err != nil {
+	if _, err := gw.Write(data); err != nil {
+		logger.Error("gzipped write data error", zap.Error(err))
 		return err
 	}
-	if err = gw.Close(); err != nil {
+	if err := gw.Close(); err != nil {
 		return err
 	}
-	req, err := http.NewRequest("POST", url, &b)
+	body := b.Bytes()
+	endpoint := c.sURL + path
+
+	return retryLimited(ctx, c.policy, func(ctx context.Context) error {
+		return c.breaker.call(func() error {
+			return c.post(ctx, endpoint, body)
+		})
+	})
+}
+
+// post does single request
+func (c *HTTPApiClient) post(ctx context.Context, endpoint string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
 	if err != nil {
+		logger.Error("request object creation error", zap.Error(err))
 		return err
 	}
 	req.Header.Set("Content-Type", "application/json")
@@ -83,200 +292,172 @@ func (c *HTTPApiClient) Do(m metrics.CollectionItem) error {
 
 	r, err := c.client.Do(req)
 	if err != nil {
-		var netError net.Error
-		if errors.As(err, &netError) {
-			err = retry.WithBackoff(func() error {
-				retrResp, retrErr := c.client.Do(req)
-				if retrErr != nil {
-					_, retrErr = io.Copy(io.Discard, retrResp.Body)
-					if retrErr != nil {
-						return retrErr
-					}
-				}
-				defer retrResp.Body.Close()
-				return retrErr
-			}, 3)
-			return err
-		}
-		logger.Error("send metrics error", zap.Error(err))
 		return err
 	}
 	defer r.Body.Close()
+	telemetry.BytesSent(len(body))
 
-	_, err = io.Copy(io.Discard, r.Body)
-	if err != nil {
-		return err
+	if r.StatusCode < 200 || r.StatusCode > 299 {
+		// keep beginning of body to explain error
+		snippet, _ := io.ReadAll(io.LimitReader(r.Body, maxErrorBodySize))
+		_, _ = io.Copy(io.Discard, r.Body)
+		return &StatusError{Code: r.StatusCode, Body: strings.TrimSpace(string(snippet))}
 	}
 
+	if _, err = io.Copy(io.Discard, r.Body); err != nil {
+		logger.Error("io.Copy error", zap.Error(err))
+		return err
+	}
 	return nil
 }
 
-// DoBatch sends metrics to server
-func (c *HTTPApiClient) DoBatch(collections []metrics.Collection) error {
-	batch := make([]metrics.Metrics, 0, 200)
-	// collect batch of metrics.Metrics
-	for _, c := range collections {
-		for _, m := range c {
-			item := metrics.Metrics{
-				ID:    m.Name,
-				MType: m.Type,
-			}
-
-			switch m.Type {
-			case "gauge":
-				value := m.Value
-				item.Value = &value
-			case "counter":
-				tmp := int64(m.Value)
-				item.Delta = &tmp
-			}
-
-			batch = append(batch, item)
-		}
+// toMetrics converts collection item to JSON-request format
+func toMetrics(m metrics.CollectionItem) metrics.Metrics {
+	item := metrics.Metrics{
+		ID:    m.Name,
+		MType: m.Type,
 	}
 
-	if len(batch) > 0 {
-		url := fmt.Sprintf("%s/updates/", c.sURL)
-		data, err := json.Marshal(batch)
-		if err != nil {
-			logger.Error("metrics encode error", zap.Error(err))
-			return err
-		}
-
-		var b bytes.Buffer
-		gw := gzip.NewWriter(&b)
-		if _, err = gw.Write(data); err != nil {
-			logger.Error("gzipped write data error", zap.Error(err))
-			return err
-		}
-		if err = gw.Close(); err != nil {
-			return err
-		}
-
-		req, err := http.NewRequest("POST", url, &b)
-		if err != nil {
-			logger.Error("request object creation error", zap.Error(err))
-			return err
-		}
-		req.Header.Set("Content-Type", "application/json")
-		req.Header.Set("Content-Encoding", "gzip")
-		req.Header.Set("X-Real-IP", c.localIP)
-
-		r, err := c.client.Do(req)
-		if err != nil {
-
-			logger.Error("first request error", zap.Error(err))
-			var netError net.Error
-			if errors.As(err, &netError) {
-				err = retry.WithBackoff(func() error {
-					retrResp, retrErr := c.client.Do(req)
-					if retrErr != nil {
-						return retrErr
-					}
-					_, retrErr = io.Copy(io.Discard, retrResp.Body)
-					if retrErr != nil {
-						logger.Error("io.Copy error", zap.Error(retrErr))
-					}
-					defer retrResp.Body.Close()
-					return retrErr
-				}, 3)
-				return err
-			}
-			logger.Error("send metrics in batch error", zap.Error(err))
-			return err
-		}
-		defer r.Body.Close()
-
-		_, err = io.Copy(io.Discard, r.Body)
-		if err != nil {
-			logger.Error("io.Copy error", zap.Error(err))
-			return err
-		}
+	switch m.Type {
+	case "gauge":
+		value := m.Value
+		item.Value = &value
+	case "counter":
+		tmp := int64(m.Value)
+		item.Delta = &tmp
 	}
-
-	return nil
-
+	return item
 }
 
 // GRPCApiClient ...
 type GRPCApiClient struct {
-	conn   *grpc.ClientConn
-	client pb.MetricsServiceClient
+	conn    *grpc.ClientConn
+	client  pb.MetricsServiceClient
+	policy  retry.Policy
+	breaker *breaker
 }
 
-// NewGRPCApiClient creates ApiClient
-func NewGRPCApiClient(a string) (*GRPCApiClient, error) {
-	conn, err := grpc.Dial(a, grpc.WithTransportCredentials(insecure.NewCredentials()))
+// NewGRPCApiClient creates ApiClient, requests are signed with hashKey if it is set
+// and encrypted with pubKey if it is set. Connection is insecure unless TLS is set by WithTLS.
+func NewGRPCApiClient(a, hashKey string, pubKey []byte, opts ...ClientOption) (*GRPCApiClient, error) {
+	var cfg clientConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	creds := insecure.NewCredentials()
+	if cfg.tls != nil {
+		creds = credentials.NewTLS(cfg.tls)
+	}
+	dialOpts := []grpc.DialOption{grpc.WithTransportCredentials(creds)}
+
+	interceptors := make([]grpc.UnaryClientInterceptor, 0, 3)
+	// agent IP is sent as X-Real-IP header of HTTP client, proxies between agent and server can use it
+	if ip, err := getLocalIP(); err != nil {
+		logger.Warn("can't get local IP", zap.Error(err))
+	} else {
+		interceptors = append(interceptors, realIPInterceptor(ip))
+	}
+	if hashKey != "" {
+		interceptors = append(interceptors, signInterceptor(hashKey, cfg.agentID))
+	}
+	if cfg.token != "" {
+		interceptors = append(interceptors, tokenInterceptor(cfg.token))
+	}
+	dialOpts = append(dialOpts, grpc.WithChainUnaryInterceptor(interceptors...))
+	if len(pubKey) != 0 {
+		pub, err := middlewares.ParsePublicKey(pubKey)
+		if err != nil {
+			logger.Error("parsing public key error", zap.Error(err))
+			return nil, err
+		}
+		dialOpts = append(dialOpts, grpc.WithDefaultCallOptions(grpc.ForceCodec(cryptoCodec{pub: pub})))
+	}
+	conn, err := grpc.Dial(a, dialOpts...)
 	if err != nil {
 		logger.Error("grpc.Dial error", zap.Error(err))
 		return nil, err
 	}
 	return &GRPCApiClient{
-		conn:   conn,
-		client: pb.NewMetricsServiceClient(conn),
+		conn:    conn,
+		client:  pb.NewMetricsServiceClient(conn),
+		policy:  newRetryPolicy(),
+		breaker: newBreaker("grpc://" + a),
 	}, nil
 }
 
-// Do sends metrics to server
-func (c *GRPCApiClient) Do(m metrics.CollectionItem) error {
-	item := &pb.Metrics{
-		Id: m.Name,
-	}
+// Close closes connection to server
+func (c *GRPCApiClient) Close() error {
+	return c.conn.Close()
+}
 
-	switch m.Type {
-	case "gauge":
-		item.Value = m.Value
-		item.Type = pb.Metrics_GAUGE
-	case "counter":
-		tmp := int64(m.Value)
-		item.Delta = tmp
-		item.Type = pb.Metrics_COUNTER
-	}
+// call calls server with retries
+func (c *GRPCApiClient) call(ctx context.Context, fn func(context.Context) error) error {
+	return retryLimited(ctx, c.policy, func(ctx context.Context) error {
+		return c.breaker.call(func() error {
+			return fn(ctx)
+		})
+	})
+}
 
-	resp, err := c.client.Update(context.TODO(), &pb.UpdateRequest{Metrics: item})
-	if err != nil {
-		return fmt.Errorf("grps Update error: %w", err)
-	}
-	if resp.Error != "" {
-		return fmt.Errorf("grps Update error: %w", errors.New(resp.Error))
-	}
+// Do sends metrics to server
+func (c *GRPCApiClient) Do(ctx context.Context, m metrics.CollectionItem) error {
+	item := toProto(m)
 
-	return nil
+	return c.call(ctx, func(ctx context.Context) error {
+		req := &pb.UpdateRequest{Metrics: item}
+		resp, err := c.client.Update(ctx, req)
+		if err != nil {
+			return fmt.Errorf("grps Update error: %w", grpcError(err))
+		}
+		telemetry.BytesSent(proto.Size(req))
+		if resp.Error != "" {
+			return retry.Permanent(fmt.Errorf("grps Update error: %w", errors.New(resp.Error)))
+		}
+		return nil
+	})
 }
 
 // DoBatch sends metrics to server
-func (c *GRPCApiClient) DoBatch(collections []metrics.Collection) error {
+func (c *GRPCApiClient) DoBatch(ctx context.Context, collections []metrics.Collection) error {
 	batch := make([]*pb.Metrics, 0, 200)
 	// collect batch of metrics.Metrics
 	for _, c := range collections {
 		for _, m := range c {
-			item := &pb.Metrics{
-				Id: m.Name,
-			}
-
-			switch m.Type {
-			case "gauge":
-				item.Value = m.Value
-				item.Type = pb.Metrics_GAUGE
-			case "counter":
-				tmp := int64(m.Value)
-				item.Delta = tmp
-				item.Type = pb.Metrics_COUNTER
-			}
-
-			batch = append(batch, item)
+			batch = append(batch, toProto(m))
 		}
 	}
 
-	if len(batch) > 0 {
-		resp, err := c.client.BatchUpdate(context.TODO(), &pb.BatchUpdateRequest{Metrics: batch})
+	if len(batch) == 0 {
+		return nil
+	}
+
+	return c.call(ctx, func(ctx context.Context) error {
+		req := &pb.BatchUpdateRequest{Metrics: batch}
+		resp, err := c.client.BatchUpdate(ctx, req)
 		if err != nil {
-			return fmt.Errorf("grps BatchUpdate error: %w", err)
+			return fmt.Errorf("grps BatchUpdate error: %w", grpcError(err))
 		}
+		telemetry.BytesSent(proto.Size(req))
 		if resp.Error != "" {
-			return fmt.Errorf("grps BatchUpdate error: %w", errors.New(resp.Error))
+			return retry.Permanent(fmt.Errorf("grps BatchUpdate error: %w", errors.New(resp.Error)))
 		}
+		return nil
+	})
+}
+
+// toProto converts collection item to protobuf message
+func toProto(m metrics.CollectionItem) *pb.Metrics {
+	item := &pb.Metrics{
+		Id: m.Name,
 	}
 
-	return nil
+	switch m.Type {
+	case "gauge":
+		item.Value = m.Value
+		item.Type = pb.Metrics_GAUGE
+	case "counter":
+		item.Delta = int64(m.Value)
+		item.Type = pb.Metrics_COUNTER
+	}
+	return item
 }
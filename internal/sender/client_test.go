@@ -0,0 +1,286 @@
+package sender
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/SerjRamone/metrius/internal/metrics"
+	"github.com/SerjRamone/metrius/internal/middlewares"
+	pb "github.com/SerjRamone/metrius/pkg/metrius_v1"
+)
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "Test#1. HTTP 503", err: &StatusError{Code: http.StatusServiceUnavailable}, want: true},
+		{name: "Test#2. HTTP 429", err: &StatusError{Code: http.StatusTooManyRequests}, want: true},
+		{name: "Test#3. HTTP 400", err: &StatusError{Code: http.StatusBadRequest}, want: false},
+		{name: "Test#4. gRPC Unavailable", err: status.Error(codes.Unavailable, "unavailable"), want: true},
+		{name: "Test#5. gRPC InvalidArgument", err: status.Error(codes.InvalidArgument, "invalid"), want: false},
+		{name: "Test#6. network error", err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}, want: true},
+		{name: "Test#7. circuit open", err: ErrCircuitOpen, want: false},
+		{name: "Test#8. other error", err: errors.New("encode error"), want: false},
+		{name: "Test#9. wrapped gRPC Unavailable", err: fmt.Errorf("grps BatchUpdate error: %w", status.Error(codes.Unavailable, "unavailable")), want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, isRetryable(tt.err))
+		})
+	}
+}
+
+func TestIsRejected(t *testing.T) {
+	badRequest := fmt.Errorf("destination prod: %w", &StatusError{Code: http.StatusBadRequest})
+	unavailable := fmt.Errorf("destination staging: %w", &StatusError{Code: http.StatusServiceUnavailable})
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "Test#1. HTTP 400", err: &StatusError{Code: http.StatusBadRequest}, want: true},
+		{name: "Test#2. HTTP 403", err: &StatusError{Code: http.StatusForbidden}, want: true},
+		{name: "Test#3. HTTP 429", err: &StatusError{Code: http.StatusTooManyRequests}, want: false},
+		{name: "Test#4. HTTP 503", err: &StatusError{Code: http.StatusServiceUnavailable}, want: false},
+		{name: "Test#5. response sign", err: ErrResponseSign, want: true},
+		{name: "Test#6. gRPC PermissionDenied", err: status.Error(codes.PermissionDenied, "denied"), want: true},
+		{name: "Test#7. gRPC DeadlineExceeded", err: status.Error(codes.DeadlineExceeded, "deadline"), want: false},
+		{name: "Test#8. network error", err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}, want: false},
+		{name: "Test#9. circuit open", err: ErrCircuitOpen, want: false},
+		{name: "Test#10. all destinations rejected", err: errors.Join(badRequest, badRequest), want: true},
+		{name: "Test#11. some destinations unavailable", err: errors.Join(badRequest, unavailable), want: false},
+		{name: "Test#12. partial failure", err: &PartialError{Destinations: []string{"prod"}, Err: errors.Join(badRequest)}, want: true},
+		{name: "Test#13. wrapped gRPC PermissionDenied", err: fmt.Errorf("grps BatchUpdate error: %w", status.Error(codes.PermissionDenied, "denied")), want: true},
+		{name: "Test#14. wrapped gRPC Unavailable", err: fmt.Errorf("grps BatchUpdate error: %w", status.Error(codes.Unavailable, "unavailable")), want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, isRejected(tt.err))
+		})
+	}
+}
+
+// failingServer answers every BatchUpdate with status error
+type failingServer struct {
+	pb.UnimplementedMetricsServiceServer
+	code  codes.Code
+	msg   string
+	calls atomic.Int32
+}
+
+func (s *failingServer) BatchUpdate(context.Context, *pb.BatchUpdateRequest) (*pb.BatchUpdateResponse, error) {
+	s.calls.Add(1)
+	return nil, status.Error(s.code, s.msg)
+}
+
+func TestGRPCApiClient_DoBatch(t *testing.T) {
+	tests := []struct {
+		name      string
+		code      codes.Code
+		msg       string
+		wantCalls int32
+		wantOpen  bool
+		rejected  bool
+		wantErr   error
+	}{
+		{name: "Test#1. Unavailable is retried and opens circuit", code: codes.Unavailable, wantCalls: 4, wantOpen: true},
+		{name: "Test#2. ResourceExhausted is retried", code: codes.ResourceExhausted, wantCalls: 4, wantOpen: true},
+		{name: "Test#3. InvalidArgument is not retried", code: codes.InvalidArgument, wantCalls: 1, rejected: true},
+		{name: "Test#4. Forbidden subnet", code: codes.PermissionDenied, msg: "Forbidden", wantCalls: 1, rejected: true, wantErr: ErrForbidden},
+		{name: "Test#5. Insufficient scope", code: codes.PermissionDenied, msg: "insufficient scope", wantCalls: 1, rejected: true, wantErr: ErrInsufficientScope},
+		{name: "Test#6. Series limit", code: codes.FailedPrecondition, msg: "tenant series limit exceeded: tenant <a>, limit 1", wantCalls: 1, rejected: true, wantErr: ErrSeriesLimit},
+		{name: "Test#7. Invalid sign", code: codes.InvalidArgument, msg: "invalid sign", wantCalls: 1, rejected: true, wantErr: ErrInvalidSign},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			require.NoError(t, err)
+			srv := &failingServer{code: tt.code, msg: tt.msg}
+			s := grpc.NewServer()
+			pb.RegisterMetricsServiceServer(s, srv)
+			go func() { _ = s.Serve(l) }()
+			defer s.Stop()
+
+			c, err := NewGRPCApiClient(l.Addr().String(), "", nil)
+			require.NoError(t, err)
+			defer c.Close()
+			c.policy.InitialInterval = time.Millisecond
+			c.policy.MaxInterval = time.Millisecond
+			c.breaker.threshold = 4
+
+			err = c.DoBatch(context.Background(), []metrics.Collection{{{Name: "Alloc", Type: "gauge", Value: 1}}})
+			require.Error(t, err)
+			st, ok := grpcStatus(err)
+			require.True(t, ok)
+			assert.Equal(t, tt.code, st.Code())
+			assert.Equal(t, tt.wantCalls, srv.calls.Load())
+			assert.Equal(t, tt.wantOpen, c.breaker.state == stateOpen)
+			assert.Equal(t, tt.rejected, isRejected(err))
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestHTTPApiClient_Retry(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		// body is sent again on every attempt
+		assert.NotEmpty(t, body)
+		if calls < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	c := NewHTTPApiClient(server.URL, "127.0.0.1")
+	c.policy.InitialInterval = time.Millisecond
+	c.policy.MaxInterval = time.Millisecond
+
+	err := c.Do(context.Background(), metrics.CollectionItem{Name: "Alloc", Type: "gauge", Value: 1})
+	require.NoError(t, err)
+	assert.Equal(t, 3, calls)
+
+	// client error is not retried
+	calls = 0
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusBadRequest)
+	})
+	err = c.Do(context.Background(), metrics.CollectionItem{Name: "Alloc", Type: "gauge", Value: 1})
+	var statusErr *StatusError
+	require.ErrorAs(t, err, &statusErr)
+	assert.Equal(t, http.StatusBadRequest, statusErr.Code)
+	assert.Equal(t, 1, calls)
+}
+
+func TestHTTPApiClient_Verify(t *testing.T) {
+	tests := []struct {
+		name      string
+		serverKey string
+		handler   http.HandlerFunc
+		// unsigned handler is not wrapped with server middlewares
+		unsigned bool
+		wantErr  error
+	}{
+		{
+			name:      "Test#1. Valid response sign",
+			serverKey: "key",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+				_, _ = w.Write([]byte(`{"status":"ok"}`))
+			},
+		},
+		{
+			name:      "Test#2. Server rejects sign",
+			serverKey: "other",
+			wantErr:   ErrInvalidSign,
+		},
+		{
+			name:      "Test#3. Forbidden",
+			serverKey: "key",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+			},
+			wantErr: ErrForbidden,
+		},
+		{
+			name:      "Test#4. Insufficient scope",
+			serverKey: "key",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, "insufficient scope", http.StatusForbidden)
+			},
+			wantErr: ErrInsufficientScope,
+		},
+		{
+			name:      "Test#5. Series limit",
+			serverKey: "key",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, "tenant series limit exceeded: tenant <a>, limit 1", http.StatusForbidden)
+			},
+			wantErr: ErrSeriesLimit,
+		},
+		{
+			name:      "Test#6. Forged response sign",
+			serverKey: "key",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("HashSHA256", "forged")
+				w.WriteHeader(http.StatusOK)
+			},
+			unsigned: true,
+			wantErr:  ErrResponseSign,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var handler http.Handler = tt.handler
+			if !tt.unsigned {
+				handler = middlewares.Signer(tt.serverKey, nil)(middlewares.GzipCompressor(handler))
+			}
+			server := httptest.NewServer(handler)
+			defer server.Close()
+
+			c := NewHTTPApiClient(server.URL, "127.0.0.1")
+			c.client.Transport = chain(nil, hasher("key", ""))
+			c.policy.InitialInterval = time.Millisecond
+
+			err := c.DoBatch(context.Background(), []metrics.Collection{{{Name: "Alloc", Type: "gauge", Value: 1}}})
+			if tt.wantErr == nil {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestHTTPApiClient_SignedEncrypted(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	pubKey := pem.EncodeToMemory(&pem.Block{Type: "RSA PUBLIC KEY", Bytes: x509.MarshalPKCS1PublicKey(&key.PublicKey)})
+
+	var got []metrics.Metrics
+	h := middlewares.NewSecurityHolder(middlewares.Security{HashKey: "key", PrivKey: key, SignEnforce: true})
+	handler := middlewares.Secure(h, "")(middlewares.GzipCompressor(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&got))
+		w.WriteHeader(http.StatusOK)
+	})))
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	c, err := NewAPIClient(strings.TrimPrefix(server.URL, "http://"), "key", pubKey, "http")
+	require.NoError(t, err)
+
+	err = c.DoBatch(context.Background(), []metrics.Collection{{{Name: "Alloc", Type: "gauge", Value: 1}}})
+	require.NoError(t, err)
+	require.Len(t, got, 1)
+	assert.Equal(t, "Alloc", got[0].ID)
+}
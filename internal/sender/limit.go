@@ -0,0 +1,146 @@
+package sender
+
+import (
+	"context"
+	"encoding/json"
+
+	"golang.org/x/time/rate"
+
+	"github.com/SerjRamone/metrius/internal/metrics"
+	"github.com/SerjRamone/metrius/pkg/retry"
+)
+
+// WithRateLimit limits sending by requests and bytes per second, the limits are shared by all workers.
+// Zero value means no limit.
+func WithRateLimit(requestsPerSecond float64, bytesPerSecond int) Option {
+	return func(sender *metricsSender) {
+		sender.requests, sender.bytes = nil, nil
+		if requestsPerSecond > 0 {
+			sender.requests = rate.NewLimiter(rate.Limit(requestsPerSecond), 1)
+		}
+		if bytesPerSecond > 0 {
+			sender.bytes = rate.NewLimiter(rate.Limit(bytesPerSecond), bytesPerSecond)
+		}
+	}
+}
+
+// WithMaxPayload splits batches so that JSON payload of every request does not exceed maxBytes
+func WithMaxPayload(maxBytes int) Option {
+	return func(sender *metricsSender) {
+		sender.maxPayload = maxBytes
+	}
+}
+
+// wait blocks until request with payload of size bytes is allowed
+func (s *snapshot) wait(ctx context.Context, size int) error {
+	if s.requests != nil {
+		if err := s.requests.Wait(ctx); err != nil {
+			return err
+		}
+	}
+	if s.bytes != nil {
+		// payload bigger than burst takes the whole bucket
+		if burst := s.bytes.Burst(); size > burst {
+			size = burst
+		}
+		if err := s.bytes.WaitN(ctx, size); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type retryWaitKey struct{}
+
+// withRetryWait returns context with wait of sender limits for retries of request with payload of size bytes
+func (s *snapshot) withRetryWait(ctx context.Context, size int) context.Context {
+	if s.requests == nil && s.bytes == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, retryWaitKey{}, func(ctx context.Context) error {
+		return s.wait(ctx, size)
+	})
+}
+
+// waitRetry blocks until retry is allowed by limits of sender set in ctx
+func waitRetry(ctx context.Context) error {
+	if wait, ok := ctx.Value(retryWaitKey{}).(func(context.Context) error); ok {
+		return wait(ctx)
+	}
+	return nil
+}
+
+// retryLimited calls fn with retries by policy p, every retry is a request counted by limits of sender
+func retryLimited(ctx context.Context, p retry.Policy, fn func(context.Context) error) error {
+	first := true
+	return p.Do(ctx, func(ctx context.Context) error {
+		if !first {
+			if err := waitRetry(ctx); err != nil {
+				return retry.Permanent(err)
+			}
+		}
+		first = false
+		return fn(ctx)
+	})
+}
+
+// splitBatch splits collections into parts with payload not bigger than maxBytes.
+// Item bigger than maxBytes is sent in its own part.
+func splitBatch(collections []metrics.Collection, maxBytes int) ([][]metrics.Collection, []int) {
+	parts := make([][]metrics.Collection, 0, 1)
+	sizes := make([]int, 0, 1)
+
+	var (
+		part    []metrics.Collection
+		current metrics.Collection
+		size    = 2 // brackets of JSON array
+	)
+	flush := func() {
+		if len(current) > 0 {
+			part = append(part, current)
+			current = nil
+		}
+		if len(part) > 0 {
+			parts = append(parts, part)
+			sizes = append(sizes, size)
+			part, size = nil, 2
+		}
+	}
+
+	for _, c := range collections {
+		for _, item := range c {
+			itemSize := payloadSize(item) + 1 // comma
+			if maxBytes > 0 && size+itemSize > maxBytes && size > 2 {
+				flush()
+			}
+			current = append(current, item)
+			size += itemSize
+		}
+		if len(current) > 0 {
+			part = append(part, current)
+			current = nil
+		}
+	}
+	flush()
+	return parts, sizes
+}
+
+// batchSize returns size of collections in JSON request
+func batchSize(collections []metrics.Collection) int {
+	size := 2
+	for _, c := range collections {
+		for _, item := range c {
+			size += payloadSize(item) + 1
+		}
+	}
+	return size
+}
+
+// payloadSize returns size of item in JSON request
+func payloadSize(item metrics.CollectionItem) int {
+	data, err := json.Marshal(toMetrics(item))
+	if err != nil {
+		return 0
+	}
+	return len(data)
+}
@@ -0,0 +1,238 @@
+package sender
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/SerjRamone/metrius/internal/metrics"
+	"github.com/SerjRamone/metrius/internal/spool"
+	"github.com/SerjRamone/metrius/internal/telemetry"
+)
+
+// recordClient records batches and fails after limit of successful calls
+type recordClient struct {
+	batches [][]metrics.Collection
+	limit   int
+}
+
+func (c *recordClient) Do(ctx context.Context, m metrics.CollectionItem) error {
+	return c.DoBatch(ctx, []metrics.Collection{{m}})
+}
+
+func (c *recordClient) DoBatch(_ context.Context, collections []metrics.Collection) error {
+	if c.limit >= 0 && len(c.batches) >= c.limit {
+		return errors.New("unavailable")
+	}
+	c.batches = append(c.batches, collections)
+	return nil
+}
+
+func gauges(n int) metrics.Collection {
+	c := make(metrics.Collection, 0, n)
+	for i := 0; i < n; i++ {
+		c = append(c, metrics.CollectionItem{Name: "Gauge", Type: "gauge", Value: 1})
+	}
+	return c
+}
+
+func TestSplitBatch(t *testing.T) {
+	itemSize := payloadSize(metrics.CollectionItem{Name: "Gauge", Type: "gauge", Value: 1}) + 1
+	collections := []metrics.Collection{gauges(3), gauges(4)}
+
+	tests := []struct {
+		name     string
+		maxBytes int
+		want     [][]int
+	}{
+		{name: "Test#1. No limit", maxBytes: 0, want: [][]int{{3, 4}}},
+		{name: "Test#2. Three items per part", maxBytes: 2 + 3*itemSize, want: [][]int{{3}, {3}, {1}}},
+		{name: "Test#3. Five items per part", maxBytes: 2 + 5*itemSize, want: [][]int{{3, 2}, {2}}},
+		{name: "Test#4. Item bigger than limit", maxBytes: 1, want: [][]int{{1}, {1}, {1}, {1}, {1}, {1}, {1}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parts, sizes := splitBatch(collections, tt.maxBytes)
+			got := make([][]int, 0, len(parts))
+			for i, part := range parts {
+				lens := make([]int, 0, len(part))
+				for _, c := range part {
+					lens = append(lens, len(c))
+				}
+				got = append(got, lens)
+				assert.Equal(t, batchSize(part), sizes[i])
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestSendBatch_Limits(t *testing.T) {
+	itemSize := payloadSize(metrics.CollectionItem{Name: "Gauge", Type: "gauge", Value: 1}) + 1
+	client := &recordClient{limit: -1}
+	sender := New(client, WithRateLimit(20, 0), WithMaxPayload(2+2*itemSize))
+
+	start := time.Now()
+	sender.sendBatch(context.Background(), []metrics.Collection{gauges(6)})
+	assert.Len(t, client.batches, 3)
+	// the first request uses burst, the next ones wait 50ms each
+	assert.GreaterOrEqual(t, time.Since(start), 90*time.Millisecond)
+}
+
+func TestSendBatch_RetryLimits(t *testing.T) {
+	var calls []time.Time
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls = append(calls, time.Now())
+		if len(calls) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	client := NewHTTPApiClient(server.URL, "127.0.0.1")
+	client.policy.InitialInterval = time.Millisecond
+	client.policy.MaxInterval = time.Millisecond
+	sender := New(client, WithRateLimit(10, 0))
+
+	sender.sendBatch(context.Background(), []metrics.Collection{gauges(1)})
+	require.Len(t, calls, 2)
+	// retry waits for limiter instead of retry interval only
+	assert.GreaterOrEqual(t, calls[1].Sub(calls[0]), 90*time.Millisecond)
+}
+
+func TestSendBatch_SpoolRest(t *testing.T) {
+	itemSize := payloadSize(metrics.CollectionItem{Name: "Gauge", Type: "gauge", Value: 1}) + 1
+	s, err := spool.New(t.TempDir(), 1<<20, time.Hour)
+	require.NoError(t, err)
+
+	client := &recordClient{limit: 1}
+	sender := New(client, WithSpool(s), WithMaxPayload(2+2*itemSize))
+	sender.sendBatch(context.Background(), []metrics.Collection{gauges(6)})
+	assert.Len(t, client.batches, 1)
+	// parts which failed are spooled separately
+	assert.Equal(t, 2, s.Len())
+
+	client.limit = -1
+	sender.sendBatch(context.Background(), nil)
+	assert.Len(t, client.batches, 3)
+	assert.Equal(t, 0, s.Len())
+}
+
+func TestSendBatch_DropRejected(t *testing.T) {
+	s, err := spool.New(t.TempDir(), 1<<20, time.Hour)
+	require.NoError(t, err)
+	require.NoError(t, s.Push([]metrics.Collection{gauges(1)}))
+
+	_, _ = telemetry.Source().Collect()
+	client := &stubClient{err: &StatusError{Code: http.StatusBadRequest}}
+	sender := New(client, WithSpool(s))
+	sender.sendBatch(context.Background(), []metrics.Collection{gauges(2)})
+	// neither spooled nor new batch is kept
+	assert.Equal(t, 2, client.calls)
+	assert.Equal(t, 0, s.Len())
+	dropped, err := telemetry.Source().Collect()
+	require.NoError(t, err)
+	assert.Contains(t, dropped, metrics.CollectionItem{Name: "AgentSpoolBatchesDropped", Type: "counter", Value: 1})
+	c, err := sender.Collect()
+	require.NoError(t, err)
+	assert.Contains(t, c, metrics.CollectionItem{Name: "SendRejected", Type: "counter", Value: 2})
+
+	// zero increments are not reported
+	c, err = sender.Collect()
+	require.NoError(t, err)
+	assert.Empty(t, c)
+}
+
+func TestSendBatch_SpoolFailedDestinations(t *testing.T) {
+	s, err := spool.New(t.TempDir(), 1<<20, time.Hour)
+	require.NoError(t, err)
+
+	prod, staging := &recordClient{limit: -1}, &recordClient{limit: 0}
+	client := &fanoutClient{clients: []APIClient{prod, staging}, names: []string{"prod", "staging"}}
+	sender := New(client, WithSpool(s))
+	sender.sendBatch(context.Background(), []metrics.Collection{gauges(1)})
+	assert.Len(t, prod.batches, 1)
+	// batch is spooled for staging only
+	assert.Equal(t, 1, s.Len())
+
+	staging.limit = -1
+	sender.sendBatch(context.Background(), []metrics.Collection{gauges(2)})
+	assert.Len(t, prod.batches, 2)
+	assert.Equal(t, [][]metrics.Collection{{gauges(1)}, {gauges(2)}}, staging.batches)
+	assert.Equal(t, 0, s.Len())
+}
+
+func TestReconfigure(t *testing.T) {
+	itemSize := payloadSize(metrics.CollectionItem{Name: "Gauge", Type: "gauge", Value: 1}) + 1
+	old := &recordClient{limit: -1}
+	sender := New(old, WithMaxPayload(2+2*itemSize))
+
+	next := &recordClient{limit: -1}
+	sender.Reconfigure(next, WithMaxPayload(0))
+
+	sender.sendBatch(context.Background(), []metrics.Collection{gauges(6)})
+	assert.Empty(t, old.batches)
+	// payload is not split anymore
+	assert.Len(t, next.batches, 1)
+}
+
+// blockingClient blocks sending until release is closed
+type blockingClient struct {
+	started chan struct{}
+	release chan struct{}
+	closed  chan struct{}
+}
+
+func (c *blockingClient) Do(ctx context.Context, m metrics.CollectionItem) error {
+	return c.DoBatch(ctx, []metrics.Collection{{m}})
+}
+
+func (c *blockingClient) DoBatch(context.Context, []metrics.Collection) error {
+	close(c.started)
+	<-c.release
+	return nil
+}
+
+func (c *blockingClient) Close() error {
+	close(c.closed)
+	return nil
+}
+
+func TestReconfigure_InFlight(t *testing.T) {
+	old := &blockingClient{started: make(chan struct{}), release: make(chan struct{}), closed: make(chan struct{})}
+	sender := New(old)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		sender.sendBatch(context.Background(), []metrics.Collection{gauges(1)})
+	}()
+	<-old.started
+
+	// client is replaced without waiting for batch in flight
+	next := &recordClient{limit: -1}
+	sender.Reconfigure(next)
+	sender.sendBatch(context.Background(), []metrics.Collection{gauges(1)})
+	assert.Len(t, next.batches, 1)
+
+	// previous client is closed after its batch is sent
+	select {
+	case <-old.closed:
+		t.Fatal("client closed while sending")
+	case <-time.After(10 * time.Millisecond):
+	}
+	close(old.release)
+	<-done
+	select {
+	case <-old.closed:
+	case <-time.After(time.Second):
+		t.Fatal("previous client not closed")
+	}
+}
@@ -2,22 +2,27 @@ package sender
 
 import (
 	"bytes"
-	"crypto/rand"
+	"compress/gzip"
+	"context"
+	"crypto/hmac"
 	"crypto/rsa"
-	"crypto/sha512"
-	"crypto/x509"
-	"encoding/pem"
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
 
 	"github.com/SerjRamone/metrius/internal/middlewares"
 	"github.com/SerjRamone/metrius/pkg/logger"
 	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
 )
 
-// Adds HashSHA256 header to request headers
-func hasher(hashKey string) middleware {
+// Adds HashSHA256 header to request headers. Sign covers timestamp and nonce headers,
+// they are new for every attempt, so server can reject replayed requests.
+// Agent ID header selects key of agent on server if it is set.
+func hasher(hashKey, agentID string) middleware {
 	return func(rt http.RoundTripper) http.RoundTripper {
 		return internalRoundTripper(func(req *http.Request) (*http.Response, error) {
 			var buf bytes.Buffer
@@ -31,73 +36,181 @@ func hasher(hashKey string) middleware {
 			b := buf.Bytes()
 			// set new body
 			req.Body = io.NopCloser(bytes.NewReader(b))
+			timestamp, nonce, err := middlewares.NewNonce()
+			if err != nil {
+				logger.Error("nonce generation error", zap.Error(err))
+				return nil, err
+			}
+			req.Header.Set(middlewares.TimestampHeader, timestamp)
+			req.Header.Set(middlewares.NonceHeader, nonce)
+			if agentID != "" {
+				req.Header.Set(middlewares.AgentIDHeader, agentID)
+			}
 			// calculate hash string
-			b64Hash := middlewares.CalcHash(b, []byte(hashKey))
+			b64Hash := middlewares.CalcHash(middlewares.SignPayload(timestamp, nonce, b), []byte(hashKey))
 			req.Header.Set("HashSHA256", b64Hash)
 			logger.Info("calculated body hash", zap.String("hash", b64Hash))
+			// get raw response body, server signs it as is
+			if req.Header.Get("Accept-Encoding") == "" {
+				req.Header.Set("Accept-Encoding", "gzip")
+			}
 
-			return rt.RoundTrip(req)
+			resp, err := rt.RoundTrip(req)
+			if err != nil {
+				return nil, err
+			}
+			if err = verifyResponse(resp, []byte(hashKey)); err != nil {
+				resp.Body.Close()
+				return nil, err
+			}
+			return resp, nil
 		})
 	}
 }
 
-// encrypt requst body
-func crypto(pubKey []byte) middleware {
+// verifyResponse checks HashSHA256 header of successful response
+// and replaces body with uncompressed one
+func verifyResponse(resp *http.Response, hashKey []byte) error {
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return fmt.Errorf("response body read error: %w", err)
+	}
+
+	if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+		headerHash := resp.Header.Get("HashSHA256")
+		if headerHash == "" {
+			logger.Warn("response without 'HashSHA256' header")
+		} else if !hmac.Equal([]byte(headerHash), []byte(middlewares.CalcHash(body, hashKey))) {
+			return ErrResponseSign
+		}
+	}
+
+	if resp.Header.Get("Content-Encoding") == "gzip" {
+		zr, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return fmt.Errorf("response body decompress error: %w", err)
+		}
+		if body, err = io.ReadAll(zr); err != nil {
+			return fmt.Errorf("response body decompress error: %w", err)
+		}
+		resp.Header.Del("Content-Encoding")
+		resp.Uncompressed = true
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	return nil
+}
+
+// encrypt request body with random AES-GCM key, the key is encrypted by pub and sent in header
+func crypto(pub *rsa.PublicKey) middleware {
 	return func(rt http.RoundTripper) http.RoundTripper {
 		return internalRoundTripper(func(req *http.Request) (*http.Response, error) {
-			// parse pem-encoded key
-			block, _ := pem.Decode(pubKey)
-			if block == nil {
-				logger.Error("parsing public key error", zap.String("reason", "invalid PEM format"))
-				return nil, fmt.Errorf("invalid PEM format")
-			}
-
-			// convert key to *rsa.PublicKey
-			pub, err := x509.ParsePKCS1PublicKey(block.Bytes)
+			// read request body
+			var buf bytes.Buffer
+			_, err := io.Copy(&buf, req.Body)
 			if err != nil {
-				logger.Error("parsing public key error", zap.Error(err))
+				logger.Error("request body copy error", zap.Error(err))
 				return nil, err
 			}
 
-			// read request body
-			var buf bytes.Buffer
-			_, err = io.Copy(&buf, req.Body)
+			envelope, encKey, err := middlewares.Encrypt(pub, buf.Bytes())
 			if err != nil {
-				logger.Error("request body copy error", zap.Error(err))
+				logger.Error("encrypting body error", zap.Error(err))
 				return nil, err
 			}
 
-			// hash func
-			hash := sha512.New()
+			// set encoded request body
+			req.Body = io.NopCloser(bytes.NewReader(envelope))
+			req.ContentLength = int64(len(envelope))
+			req.Header.Set(middlewares.EncryptedKeyHeader, encKey)
 
-			bodyBytes := buf.Bytes()
-			msgLen := len(bodyBytes)
+			return rt.RoundTrip(req)
+		})
+	}
+}
 
-			// chunk size
-			step := pub.Size() - 2*hash.Size() - 2
-			var encryptedBytes []byte
+// adds Authorization header with bearer token
+func bearer(token string) middleware {
+	return func(rt http.RoundTripper) http.RoundTripper {
+		return internalRoundTripper(func(req *http.Request) (*http.Response, error) {
+			req.Header.Set("Authorization", "Bearer "+token)
+			return rt.RoundTrip(req)
+		})
+	}
+}
 
-			// encode body by chunks
-			for start := 0; start < msgLen; start += step {
-				finish := start + step
-				if finish > msgLen {
-					finish = msgLen
-				}
+// tokenInterceptor adds bearer token to gRPC metadata
+func tokenInterceptor(token string) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+token)
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
 
-				encryptedBlockBytes, err := rsa.EncryptOAEP(hash, rand.Reader, pub, bodyBytes[start:finish], nil)
-				if err != nil {
-					logger.Error("encrypting chunk error", zap.Error(err))
-					return nil, err
-				}
+// realIPInterceptor adds agent IP to gRPC metadata
+func realIPInterceptor(ip string) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		ctx = metadata.AppendToOutgoingContext(ctx, "x-real-ip", ip)
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
 
-				encryptedBytes = append(encryptedBytes, encryptedBlockBytes...)
-			}
+// cryptoCodec is a proto codec encrypting gRPC requests with pub, responses are not encrypted
+type cryptoCodec struct {
+	pub *rsa.PublicKey
+}
 
-			// set encoded request body
-			req.Body = io.NopCloser(bytes.NewReader(encryptedBytes))
-			req.ContentLength = int64(len(encryptedBytes))
+// Marshal encrypts serialized message
+func (c cryptoCodec) Marshal(v any) ([]byte, error) {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("can't marshal message of type %T", v)
+	}
+	data, err := proto.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	return middlewares.SealMessage(c.pub, data)
+}
 
-			return rt.RoundTrip(req)
-		})
+// Unmarshal ...
+func (c cryptoCodec) Unmarshal(data []byte, v any) error {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("can't unmarshal message of type %T", v)
+	}
+	return proto.Unmarshal(data, msg)
+}
+
+// Name is the name of default proto codec, so server needs no content subtype
+func (c cryptoCodec) Name() string {
+	return "proto"
+}
+
+// signInterceptor adds sign of serialized request, timestamp, nonce and agent ID to gRPC metadata
+func signInterceptor(hashKey, agentID string) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		msg, ok := req.(proto.Message)
+		if !ok {
+			return fmt.Errorf("can't sign request of type %T", req)
+		}
+		data, err := proto.MarshalOptions{Deterministic: true}.Marshal(msg)
+		if err != nil {
+			return fmt.Errorf("request encode error: %w", err)
+		}
+		timestamp, nonce, err := middlewares.NewNonce()
+		if err != nil {
+			return fmt.Errorf("nonce generation error: %w", err)
+		}
+		ctx = metadata.AppendToOutgoingContext(ctx,
+			"hashsha256", middlewares.CalcHash(middlewares.SignPayload(timestamp, nonce, data), []byte(hashKey)),
+			strings.ToLower(middlewares.TimestampHeader), timestamp,
+			strings.ToLower(middlewares.NonceHeader), nonce,
+		)
+		if agentID != "" {
+			ctx = metadata.AppendToOutgoingContext(ctx, strings.ToLower(middlewares.AgentIDHeader), agentID)
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
 	}
 }
@@ -0,0 +1,279 @@
+package sender
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/SerjRamone/metrius/internal/metrics"
+	"github.com/SerjRamone/metrius/pkg/logger"
+)
+
+// Modes of sending to multiple destinations
+const (
+	// ModeFanout sends every batch to all destinations
+	ModeFanout = "fanout"
+	// ModeFailover sends batch to the first healthy destination
+	ModeFailover = "failover"
+)
+
+// destination is unhealthy for this period after failure
+const failoverCooldown = 30 * time.Second
+
+var (
+	_ APIClient      = (*fanoutClient)(nil)
+	_ targetedClient = (*fanoutClient)(nil)
+	_ APIClient      = (*failoverClient)(nil)
+)
+
+// Destination describes metrics server with its own keys
+type Destination struct {
+	Address string
+	Type    string
+	HashKey string
+	PubKey  []byte
+}
+
+// String returns destination name for logging
+func (d Destination) String() string {
+	return d.Type + "://" + d.Address
+}
+
+// newAPIClient creates client of one destination, replaced in tests
+var newAPIClient = NewAPIClient
+
+// NewMultiClient creates client sending to several destinations in mode, opts are applied to all clients
+func NewMultiClient(mode string, destinations []Destination, opts ...ClientOption) (APIClient, error) {
+	if len(destinations) == 0 {
+		return nil, errors.New("destinations not set")
+	}
+
+	if mode != ModeFanout && mode != ModeFailover {
+		return nil, fmt.Errorf("destinations mode %s not supported", mode)
+	}
+
+	clients := make([]APIClient, 0, len(destinations))
+	names := make([]string, 0, len(destinations))
+	for _, d := range destinations {
+		c, err := newAPIClient(d.Address, d.HashKey, d.PubKey, d.Type, opts...)
+		if err != nil {
+			// connections of created clients mustn't leak on failed reload
+			_ = closeAll(clients)
+			return nil, fmt.Errorf("destination %s error: %w", d, err)
+		}
+		clients = append(clients, c)
+		names = append(names, d.String())
+	}
+
+	if mode == ModeFanout {
+		return &fanoutClient{clients: clients, names: names}, nil
+	}
+	return &failoverClient{
+		clients:        clients,
+		names:          names,
+		unhealthyUntil: make([]time.Time, len(clients)),
+		cooldown:       failoverCooldown,
+	}, nil
+}
+
+// PartialError is returned by fan-out client if some destinations failed
+type PartialError struct {
+	// Destinations are names of failed destinations
+	Destinations []string
+	Err          error
+}
+
+// Error ...
+func (e *PartialError) Error() string {
+	return fmt.Sprintf("%d destinations failed: %v", len(e.Destinations), e.Err)
+}
+
+// Unwrap ...
+func (e *PartialError) Unwrap() error {
+	return e.Err
+}
+
+// FailedDestinations returns names of failed destinations, spool keeps batch for them only
+func (e *PartialError) FailedDestinations() []string {
+	return e.Destinations
+}
+
+// targetedClient sends batch to destinations by their names
+type targetedClient interface {
+	DoBatchTo(ctx context.Context, destinations []string, collections []metrics.Collection) error
+}
+
+// closeAll closes clients implementing io.Closer
+func closeAll(clients []APIClient) error {
+	var errs []error
+	for _, c := range clients {
+		if closer, ok := c.(io.Closer); ok {
+			errs = append(errs, closer.Close())
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// fanoutClient sends to all destinations concurrently.
+// PartialError is returned if only some destinations failed, so batch is
+// resent to failed destinations only and counters are not doubled on others.
+type fanoutClient struct {
+	clients []APIClient
+	names   []string
+}
+
+// send calls fn for clients with indexes idx concurrently
+func (c *fanoutClient) send(idx []int, fn func(APIClient) error) error {
+	errs := make([]error, len(idx))
+	var wg sync.WaitGroup
+	for j, i := range idx {
+		wg.Add(1)
+		go func(j int, client APIClient) {
+			defer wg.Done()
+			errs[j] = fn(client)
+		}(j, c.clients[i])
+	}
+	wg.Wait()
+
+	var failed []string
+	for j, err := range errs {
+		if err != nil {
+			name := c.names[idx[j]]
+			failed = append(failed, name)
+			errs[j] = fmt.Errorf("destination %s: %w", name, err)
+			logger.Error("fanout send error", zap.String("destination", name), zap.Error(err))
+		}
+	}
+	switch {
+	case len(failed) == 0:
+		return nil
+	case len(failed) == len(idx):
+		return errors.Join(errs...)
+	default:
+		return &PartialError{Destinations: failed, Err: errors.Join(errs...)}
+	}
+}
+
+// all returns indexes of all clients
+func (c *fanoutClient) all() []int {
+	idx := make([]int, len(c.clients))
+	for i := range idx {
+		idx[i] = i
+	}
+	return idx
+}
+
+// Do sends metrics to all destinations
+func (c *fanoutClient) Do(ctx context.Context, m metrics.CollectionItem) error {
+	return c.send(c.all(), func(client APIClient) error { return client.Do(ctx, m) })
+}
+
+// DoBatch sends metrics to all destinations
+func (c *fanoutClient) DoBatch(ctx context.Context, collections []metrics.Collection) error {
+	return c.send(c.all(), func(client APIClient) error { return client.DoBatch(ctx, collections) })
+}
+
+// DoBatchTo sends metrics to destinations by names, unknown names are skipped
+func (c *fanoutClient) DoBatchTo(ctx context.Context, destinations []string, collections []metrics.Collection) error {
+	var idx []int
+	for i, name := range c.names {
+		for _, d := range destinations {
+			if name == d {
+				idx = append(idx, i)
+				break
+			}
+		}
+	}
+	if len(idx) == 0 {
+		logger.Warn("batch destinations are not configured, batch dropped", zap.Strings("destinations", destinations))
+		return nil
+	}
+	return c.send(idx, func(client APIClient) error { return client.DoBatch(ctx, collections) })
+}
+
+// Close closes all clients
+func (c *fanoutClient) Close() error {
+	return closeAll(c.clients)
+}
+
+// failoverClient sends to destinations in order of priority.
+// Failed destination is skipped during cooldown period.
+type failoverClient struct {
+	clients  []APIClient
+	names    []string
+	cooldown time.Duration
+
+	mu             sync.Mutex
+	unhealthyUntil []time.Time
+}
+
+// order returns indexes of healthy destinations first, then unhealthy ones
+func (c *failoverClient) order() []int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	healthy := make([]int, 0, len(c.clients))
+	unhealthy := make([]int, 0, len(c.clients))
+	for i, until := range c.unhealthyUntil {
+		if now.Before(until) {
+			unhealthy = append(unhealthy, i)
+		} else {
+			healthy = append(healthy, i)
+		}
+	}
+	return append(healthy, unhealthy...)
+}
+
+// markHealth updates health of destination i
+func (c *failoverClient) markHealth(i int, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	wasHealthy := !time.Now().Before(c.unhealthyUntil[i])
+	if err != nil {
+		c.unhealthyUntil[i] = time.Now().Add(c.cooldown)
+		if wasHealthy {
+			logger.Warn("destination marked unhealthy", zap.String("destination", c.names[i]), zap.Error(err))
+		}
+		return
+	}
+	if !c.unhealthyUntil[i].IsZero() {
+		logger.Info("destination recovered", zap.String("destination", c.names[i]))
+	}
+	c.unhealthyUntil[i] = time.Time{}
+}
+
+// send calls fn for destinations in order until the first success
+func (c *failoverClient) send(fn func(APIClient) error) error {
+	var errs []error
+	for _, i := range c.order() {
+		err := fn(c.clients[i])
+		c.markHealth(i, err)
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, fmt.Errorf("destination %s: %w", c.names[i], err))
+	}
+	return errors.Join(errs...)
+}
+
+// Do sends metrics to the first available destination
+func (c *failoverClient) Do(ctx context.Context, m metrics.CollectionItem) error {
+	return c.send(func(client APIClient) error { return client.Do(ctx, m) })
+}
+
+// DoBatch sends metrics to the first available destination
+func (c *failoverClient) DoBatch(ctx context.Context, collections []metrics.Collection) error {
+	return c.send(func(client APIClient) error { return client.DoBatch(ctx, collections) })
+}
+
+// Close closes all clients
+func (c *failoverClient) Close() error {
+	return closeAll(c.clients)
+}
@@ -0,0 +1,147 @@
+package sender
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/SerjRamone/metrius/internal/metrics"
+)
+
+// stubClient counts calls and returns err
+type stubClient struct {
+	mu    sync.Mutex
+	calls int
+	err   error
+}
+
+func (c *stubClient) Do(ctx context.Context, _ metrics.CollectionItem) error {
+	return c.DoBatch(ctx, nil)
+}
+
+func (c *stubClient) DoBatch(context.Context, []metrics.Collection) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calls++
+	return c.err
+}
+
+// closerClient records Close calls
+type closerClient struct {
+	stubClient
+	closed bool
+}
+
+func (c *closerClient) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestFanoutClient(t *testing.T) {
+	prod, staging := &stubClient{}, &stubClient{err: errors.New("unavailable")}
+	c := &fanoutClient{clients: []APIClient{prod, staging}, names: []string{"prod", "staging"}}
+
+	// failed destinations are reported
+	var partial *PartialError
+	require.ErrorAs(t, c.DoBatch(context.Background(), nil), &partial)
+	assert.Equal(t, []string{"staging"}, partial.Destinations)
+	assert.Equal(t, 1, prod.calls)
+	assert.Equal(t, 1, staging.calls)
+
+	// batch is resent to failed destinations only
+	staging.err = nil
+	assert.NoError(t, c.DoBatchTo(context.Background(), []string{"staging"}, nil))
+	assert.Equal(t, 1, prod.calls)
+	assert.Equal(t, 2, staging.calls)
+	assert.NoError(t, c.DoBatchTo(context.Background(), []string{"removed"}, nil))
+
+	prod.err = errors.New("unavailable")
+	staging.err = errors.New("unavailable")
+	err := c.DoBatch(context.Background(), nil)
+	assert.Error(t, err)
+	assert.False(t, errors.As(err, &partial))
+}
+
+func TestFailoverClient(t *testing.T) {
+	primary, secondary := &stubClient{}, &stubClient{}
+	c := &failoverClient{
+		clients:        []APIClient{primary, secondary},
+		names:          []string{"primary", "secondary"},
+		unhealthyUntil: make([]time.Time, 2),
+		cooldown:       time.Hour,
+	}
+
+	require.NoError(t, c.DoBatch(context.Background(), nil))
+	assert.Equal(t, 1, primary.calls)
+	assert.Equal(t, 0, secondary.calls)
+
+	// primary fails, batch goes to secondary
+	primary.err = errors.New("unavailable")
+	require.NoError(t, c.DoBatch(context.Background(), nil))
+	assert.Equal(t, 2, primary.calls)
+	assert.Equal(t, 1, secondary.calls)
+
+	// primary is skipped during cooldown
+	require.NoError(t, c.DoBatch(context.Background(), nil))
+	assert.Equal(t, 2, primary.calls)
+	assert.Equal(t, 2, secondary.calls)
+
+	// all destinations failed, unhealthy ones are tried too
+	secondary.err = errors.New("unavailable")
+	assert.Error(t, c.DoBatch(context.Background(), nil))
+	assert.Equal(t, 3, primary.calls)
+	assert.Equal(t, 3, secondary.calls)
+
+	// primary recovers after cooldown
+	primary.err = nil
+	c.unhealthyUntil[0] = time.Time{}
+	require.NoError(t, c.DoBatch(context.Background(), nil))
+	assert.Equal(t, 4, primary.calls)
+	assert.Equal(t, 3, secondary.calls)
+}
+
+func TestNewMultiClient(t *testing.T) {
+	d := []Destination{{Address: "localhost:3200", Type: "grpc"}}
+
+	c, err := NewMultiClient(ModeFailover, d)
+	require.NoError(t, err)
+	assert.IsType(t, &failoverClient{}, c)
+
+	_, err = NewMultiClient("roundrobin", d)
+	assert.Error(t, err)
+
+	_, err = NewMultiClient(ModeFanout, nil)
+	assert.Error(t, err)
+
+	_, err = NewMultiClient(ModeFanout, []Destination{{Address: "localhost:8080", Type: "udp"}})
+	assert.Error(t, err)
+}
+
+func TestNewMultiClient_CloseOnError(t *testing.T) {
+	created := make([]*closerClient, 0, 2)
+	newAPIClient = func(sURL, _ string, _ []byte, _ string, _ ...ClientOption) (APIClient, error) {
+		if sURL == "invalid" {
+			return nil, errors.New("invalid address")
+		}
+		c := &closerClient{}
+		created = append(created, c)
+		return c, nil
+	}
+	defer func() { newAPIClient = NewAPIClient }()
+
+	_, err := NewMultiClient(ModeFanout, []Destination{
+		{Address: "localhost:3200", Type: "grpc"},
+		{Address: "localhost:3201", Type: "grpc"},
+		{Address: "invalid", Type: "grpc"},
+	})
+	require.Error(t, err)
+	require.Len(t, created, 2)
+	for _, c := range created {
+		assert.True(t, c.closed)
+	}
+}
@@ -2,40 +2,199 @@
 package sender
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"io"
 	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"go.uber.org/zap"
+	"golang.org/x/time/rate"
 
 	"github.com/SerjRamone/metrius/internal/metrics"
+	"github.com/SerjRamone/metrius/internal/middlewares"
+	"github.com/SerjRamone/metrius/internal/spool"
+	"github.com/SerjRamone/metrius/internal/telemetry"
 	"github.com/SerjRamone/metrius/pkg/logger"
 )
 
 // metricsSender ...
 type metricsSender struct {
+	// mu serializes Reconfigure, it guards configured client and limits.
+	// Batches are sent with current snapshot of them, so Reconfigure doesn't wait for sending.
+	mu      sync.Mutex
 	client  APIClient
+	current atomic.Pointer[snapshot]
+	spool   *spool.Spool
 	sURL    string
 	hashKey string
 	pubKey  []byte
 	localIP string
+
+	// limits of sending, nil if not set
+	requests   *rate.Limiter
+	bytes      *rate.Limiter
+	maxPayload int
+
+	// self-metrics, reset on every Collect
+	sendErrors atomic.Int64
+	rejected   atomic.Int64
+	signErrors atomic.Int64
+	forbidden  atomic.Int64
+}
+
+// Option configures metricsSender
+type Option func(*metricsSender)
+
+// WithSpool persists batches which failed to send to s and replays them later
+func WithSpool(s *spool.Spool) Option {
+	return func(sender *metricsSender) {
+		sender.spool = s
+	}
 }
 
 // NewMetricsSender crates MetricsSender
-func NewMetricsSender(sURL, hashKey string, pubKey []byte, serverType string) (*metricsSender, error) {
+func NewMetricsSender(sURL, hashKey string, pubKey []byte, serverType string, opts ...Option) (*metricsSender, error) {
+	client, err := NewAPIClient(sURL, hashKey, pubKey, serverType)
+	if err != nil {
+		return nil, err
+	}
+
+	sender := New(client, opts...)
+	sender.sURL = sURL
+	sender.hashKey = hashKey
+	sender.pubKey = pubKey
+	if httpClient, ok := client.(*HTTPApiClient); ok {
+		sender.sURL = httpClient.sURL
+		sender.localIP = httpClient.localIP
+	}
+
+	return sender, nil
+}
+
+// New creates MetricsSender with client, f.e. created by NewMultiClient
+func New(client APIClient, opts ...Option) *metricsSender {
+	sender := &metricsSender{
+		client: client,
+	}
+	for _, opt := range opts {
+		opt(sender)
+	}
+	sender.current.Store(sender.newSnapshot())
+	return sender
+}
+
+// snapshot is a client with limits which batches are sent with
+type snapshot struct {
+	client     APIClient
+	requests   *rate.Limiter
+	bytes      *rate.Limiter
+	maxPayload int
+
+	// mu is read locked while batch is sent, retired snapshot is not used anymore
+	mu      sync.RWMutex
+	retired bool
+}
+
+// newSnapshot returns snapshot of configured client and limits, sender.mu must be held
+func (sender *metricsSender) newSnapshot() *snapshot {
+	return &snapshot{
+		client:     sender.client,
+		requests:   sender.requests,
+		bytes:      sender.bytes,
+		maxPayload: sender.maxPayload,
+	}
+}
+
+// acquire returns current snapshot, it must be released after sending
+func (sender *metricsSender) acquire() *snapshot {
+	for {
+		s := sender.current.Load()
+		s.mu.RLock()
+		if !s.retired {
+			return s
+		}
+		// snapshot was replaced after loading
+		s.mu.RUnlock()
+	}
+}
+
+// release ...
+func (s *snapshot) release() {
+	s.mu.RUnlock()
+}
+
+// retire waits for batches in flight and closes client of snapshot if it differs from next one
+func (s *snapshot) retire(next APIClient) {
+	s.mu.Lock()
+	s.retired = true
+	s.mu.Unlock()
+
+	if s.client == next {
+		return
+	}
+	if closer, ok := s.client.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			logger.Error("previous client closing error", zap.Error(err))
+		}
+	}
+}
+
+// ClientOption configures client created by NewAPIClient
+type ClientOption func(*clientConfig)
+
+// clientConfig is a config of API client
+type clientConfig struct {
+	agentID string
+	tls     *tls.Config
+	token   string
+}
+
+// WithAgentID sends agent ID with signed requests, server checks sign with key of the agent
+func WithAgentID(id string) ClientOption {
+	return func(c *clientConfig) {
+		c.agentID = id
+	}
+}
+
+// WithTLS connects to server by TLS with config cfg
+func WithTLS(cfg *tls.Config) ClientOption {
+	return func(c *clientConfig) {
+		c.tls = cfg
+	}
+}
+
+// WithToken sends bearer token with requests
+func WithToken(token string) ClientOption {
+	return func(c *clientConfig) {
+		c.token = token
+	}
+}
+
+// NewAPIClient creates HTTP or gRPC client for server with address sURL.
+// Requests are signed with hashKey if it is set and encrypted with pubKey if it is set.
+func NewAPIClient(sURL, hashKey string, pubKey []byte, serverType string, opts ...ClientOption) (APIClient, error) {
+	var cfg clientConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
 	if serverType != "grpc" && serverType != "http" {
 		return nil, fmt.Errorf("server type %s not supported", serverType)
 	}
 
 	if serverType == "grpc" {
-		gRPCClient, err := NewGRPCApiClient(sURL)
+		gRPCClient, err := NewGRPCApiClient(sURL, hashKey, pubKey, opts...)
 		if err != nil {
 			logger.Error("can't create gRPC client", zap.Error(err))
 			return nil, err
 		}
-		return &metricsSender{
-			client: gRPCClient,
-		}, nil
+		return gRPCClient, nil
 	}
 
 	ip, err := getLocalIP()
@@ -45,25 +204,37 @@ func NewMetricsSender(sURL, hashKey string, pubKey []byte, serverType string) (*
 	}
 	logger.Info("client local IP", zap.String("IP", ip))
 
-	httpClient := NewHTTPApiClient("http://"+sURL, ip)
-	middlewares := make([]middleware, 0, 3)
-	if hashKey != "" {
-		middlewares = append(middlewares, hasher(hashKey))
+	scheme := "http://"
+	if cfg.tls != nil {
+		scheme = "https://"
+	}
+	httpClient := NewHTTPApiClient(scheme+sURL, ip)
+	if cfg.tls != nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = cfg.tls
+		httpClient.client.Transport = transport
 	}
+	// the last middleware is called first: body is signed before it's encrypted,
+	// server decrypts body before checking sign as gRPC server does
+	mws := make([]middleware, 0, 4)
 	if len(pubKey) != 0 {
-		middlewares = append(middlewares, crypto(pubKey))
+		// key is parsed once, not on every request
+		pub, err := middlewares.ParsePublicKey(pubKey)
+		if err != nil {
+			logger.Error("parsing public key error", zap.Error(err))
+			return nil, err
+		}
+		mws = append(mws, crypto(pub))
 	}
-	httpClient.client.Transport = chain(httpClient.client.Transport, middlewares...)
-
-	sender := metricsSender{
-		sURL:    "http://" + sURL,
-		hashKey: hashKey,
-		pubKey:  pubKey,
-		client:  httpClient,
-		localIP: ip,
+	if cfg.token != "" {
+		mws = append(mws, bearer(cfg.token))
 	}
+	if hashKey != "" {
+		mws = append(mws, hasher(hashKey, cfg.agentID))
+	}
+	httpClient.client.Transport = chain(httpClient.client.Transport, mws...)
 
-	return &sender, nil
+	return httpClient, nil
 }
 
 // Send whole Collection
@@ -85,11 +256,29 @@ func NewMetricsSender(sURL, hashKey string, pubKey []byte, serverType string) (*
 // 	return nil
 // }
 
-func (sender *metricsSender) Send(collections []metrics.Collection) error {
+// Reconfigure replaces client and applies opts, new batches are sent with them at once.
+// Batches in flight are sent by previous client, then it is closed if it implements io.Closer.
+func (sender *metricsSender) Reconfigure(client APIClient, opts ...Option) {
+	sender.mu.Lock()
+	defer sender.mu.Unlock()
+
+	sender.client = client
+	for _, opt := range opts {
+		opt(sender)
+	}
+	prev := sender.current.Swap(sender.newSnapshot())
+	go prev.retire(client)
+}
+
+// Send sends metrics one by one
+func (sender *metricsSender) Send(ctx context.Context, collections []metrics.Collection) error {
+	s := sender.acquire()
+	defer s.release()
+
 	for _, c := range collections {
 
 		for _, m := range c {
-			err := sender.client.Do(m)
+			err := s.client.Do(ctx, m)
 			if err != nil {
 				return err
 			}
@@ -101,29 +290,161 @@ func (sender *metricsSender) Send(collections []metrics.Collection) error {
 	return nil
 }
 
-// Worker is a async sender
-func (sender *metricsSender) Worker(doneCh chan struct{}, jobCh chan []metrics.Collection) {
+// Worker is a async sender. It sends batches from jobCh until the channel is closed,
+// ctx limits sending, f.e. on shutdown deadline.
+func (sender *metricsSender) Worker(ctx context.Context, jobCh <-chan []metrics.Collection) {
 	logger.Info("worker started")
-	for {
-		select {
-		case collections := <-jobCh:
-			logger.Info("worker recived new collections")
-			err := sender.client.DoBatch(collections)
-			if err != nil {
-				logger.Error("async SendBatch error", zap.Error(err))
+	for collections := range jobCh {
+		logger.Info("worker recived new collections")
+		sender.sendBatch(ctx, collections)
+	}
+	logger.Info("worker stopped")
+}
+
+// sendBatch sends batch. If spool is set, previously failed batches are sent first
+// and batch is persisted to spool on failure. Batches rejected by server are dropped.
+func (sender *metricsSender) sendBatch(ctx context.Context, collections []metrics.Collection) {
+	s := sender.acquire()
+	defer s.release()
+
+	if sender.spool != nil && sender.spool.Len() > 0 {
+		sent, err := sender.spool.Replay(func(b spool.Batch) error {
+			size := batchSize(b.Collections)
+			if err := s.wait(ctx, size); err != nil {
+				return err
 			}
-		case <-doneCh:
-			logger.Info("worker recived done signal")
-			collections := <-jobCh
-			if len(collections) > 0 {
-				err := sender.client.DoBatch(collections)
-				if err != nil {
-					logger.Error("async SendBatch error", zap.Error(err))
-				}
+			err := s.doBatch(s.withRetryWait(ctx, size), b)
+			if err != nil && isRejected(err) {
+				// resending won't help, batch must not block the next ones
+				sender.countError(err)
+				return fmt.Errorf("%w: %w", spool.ErrRejected, err)
 			}
+			return err
+		})
+		if sent > 0 {
+			logger.Info("spooled batches sent", zap.Int("count", sent))
+		}
+		if err != nil {
+			// server is still unavailable, keep order of batches
+			logger.Error("spool replay error", zap.Error(err))
+			sender.persist(spool.Batch{Collections: collections})
 			return
 		}
 	}
+
+	parts, sizes := splitBatch(collections, s.maxPayload)
+	for i, part := range parts {
+		err := s.wait(ctx, sizes[i])
+		if err == nil {
+			err = s.doBatch(s.withRetryWait(ctx, sizes[i]), spool.Batch{Collections: part})
+		}
+		if err != nil {
+			sender.countError(err)
+			if isRejected(err) {
+				telemetry.ItemsDropped(countItems(part))
+				continue
+			}
+			var partial *PartialError
+			if errors.As(err, &partial) {
+				// destinations which received part must not get it again
+				sender.persist(spool.Batch{Collections: part, Destinations: partial.Destinations})
+				continue
+			}
+			// keep the rest of batch in order, parts are spooled separately to respect max payload
+			for _, p := range parts[i:] {
+				sender.persist(spool.Batch{Collections: p})
+			}
+			return
+		}
+	}
+}
+
+// doBatch sends batch to its destinations and records telemetry
+func (s *snapshot) doBatch(ctx context.Context, b spool.Batch) error {
+	start := time.Now()
+	var err error
+	if len(b.Destinations) == 0 {
+		err = s.client.DoBatch(ctx, b.Collections)
+	} else if client, ok := s.client.(targetedClient); ok {
+		err = client.DoBatchTo(ctx, b.Destinations, b.Collections)
+	} else {
+		// destinations were reconfigured
+		logger.Warn("batch destinations are not configured, batch dropped", zap.Strings("destinations", b.Destinations))
+		telemetry.ItemsDropped(countItems(b.Collections))
+		return nil
+	}
+	if err != nil {
+		telemetry.BatchFailed(time.Since(start))
+		return err
+	}
+	telemetry.BatchSent(time.Since(start))
+	return nil
+}
+
+// countError logs send error and updates self-metrics
+func (sender *metricsSender) countError(err error) {
+	sender.sendErrors.Add(1)
+
+	switch {
+	case errors.Is(err, ErrInvalidSign), errors.Is(err, ErrResponseSign):
+		sender.signErrors.Add(1)
+		logger.Error("async SendBatch sign error, check hash key of agent and server", zap.Error(err))
+	case errors.Is(err, ErrForbidden):
+		sender.forbidden.Add(1)
+		logger.Error("async SendBatch forbidden, check trusted subnet of server", zap.Error(err))
+	case errors.Is(err, ErrInsufficientScope):
+		sender.forbidden.Add(1)
+		logger.Error("async SendBatch forbidden, check scopes of agent token", zap.Error(err))
+	case errors.Is(err, ErrSeriesLimit):
+		sender.forbidden.Add(1)
+		logger.Error("async SendBatch forbidden, series limit of tenant exceeded", zap.Error(err))
+	default:
+		logger.Error("async SendBatch error", zap.Error(err))
+	}
+	if isRejected(err) {
+		sender.rejected.Add(1)
+	}
+}
+
+// Collect returns send errors counters increments since the previous call, zero increments are skipped
+func (sender *metricsSender) Collect() (metrics.Collection, error) {
+	counters := []struct {
+		name  string
+		value *atomic.Int64
+	}{
+		{name: "SendErrors", value: &sender.sendErrors},
+		{name: "SendRejected", value: &sender.rejected},
+		{name: "SendSignErrors", value: &sender.signErrors},
+		{name: "SendForbidden", value: &sender.forbidden},
+	}
+	var collection metrics.Collection
+	for _, counter := range counters {
+		if n := counter.value.Swap(0); n > 0 {
+			collection = append(collection, metrics.CollectionItem{Name: counter.name, Type: "counter", Value: float64(n)})
+		}
+	}
+	return collection, nil
+}
+
+// persist puts batch to spool
+func (sender *metricsSender) persist(b spool.Batch) {
+	if sender.spool == nil {
+		telemetry.ItemsDropped(countItems(b.Collections))
+		return
+	}
+	if err := sender.spool.PushBatch(b); err != nil {
+		logger.Error("spool push error", zap.Error(err))
+		telemetry.ItemsDropped(countItems(b.Collections))
+	}
+}
+
+// countItems returns number of metrics items in batch
+func countItems(collections []metrics.Collection) int {
+	n := 0
+	for _, c := range collections {
+		n += len(c)
+	}
+	return n
 }
 
 // SendBatch sends metrics in batches
@@ -2,6 +2,7 @@ package sender
 
 import (
 	"compress/gzip"
+	"context"
 	"io"
 	"log"
 	"net/http"
@@ -47,7 +48,7 @@ func TestSend(t *testing.T) {
 	if err != nil {
 		log.Fatal("can't create sender", err)
 	}
-	err = sender.Send(c)
+	err = sender.Send(context.Background(), c)
 	assert.NoError(t, err)
 	// assert.Equal(t, http.StatusOK, resp.StatusCode)
 }
@@ -0,0 +1,203 @@
+package sender
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/SerjRamone/metrius/internal/metrics"
+)
+
+// Formats of writerClient output
+const (
+	// FormatJSON is a JSON array of metrics in request format per line
+	FormatJSON = "json"
+	// FormatPrometheus is a Prometheus text exposition format
+	FormatPrometheus = "prometheus"
+	// FormatInflux is an InfluxDB line protocol
+	FormatInflux = "influx"
+)
+
+var _ APIClient = (*writerClient)(nil)
+
+// writerClient writes batches to w instead of sending them to server
+type writerClient struct {
+	mu     sync.Mutex
+	w      io.Writer
+	format string
+	// counters totals for Prometheus format, it expects cumulative values
+	totals map[string]float64
+}
+
+// NewWriterClient creates client writing batches to w in format
+func NewWriterClient(w io.Writer, format string) (*writerClient, error) {
+	switch format {
+	case FormatJSON, FormatPrometheus, FormatInflux:
+	default:
+		return nil, fmt.Errorf("output format %s not supported", format)
+	}
+	return &writerClient{
+		w:      w,
+		format: format,
+		totals: make(map[string]float64),
+	}, nil
+}
+
+// Do writes metrics
+func (c *writerClient) Do(ctx context.Context, m metrics.CollectionItem) error {
+	return c.DoBatch(ctx, []metrics.Collection{{m}})
+}
+
+// DoBatch writes batch of metrics
+func (c *writerClient) DoBatch(_ context.Context, collections []metrics.Collection) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var b strings.Builder
+	switch c.format {
+	case FormatJSON:
+		batch := make([]metrics.Metrics, 0, 200)
+		for _, collection := range collections {
+			for _, m := range collection {
+				batch = append(batch, toMetrics(m))
+			}
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+		data, err := json.Marshal(batch)
+		if err != nil {
+			return fmt.Errorf("metrics encode error: %w", err)
+		}
+		b.Write(data)
+		b.WriteByte('\n')
+	case FormatPrometheus:
+		c.writePrometheus(&b, collections)
+	case FormatInflux:
+		if err := writeInflux(&b, collections, time.Now()); err != nil {
+			return err
+		}
+	}
+
+	if _, err := io.WriteString(c.w, b.String()); err != nil {
+		return fmt.Errorf("write batch error: %w", err)
+	}
+	return nil
+}
+
+// Close closes w if it is io.Closer except stdout
+func (c *writerClient) Close() error {
+	if c.w == os.Stdout {
+		return nil
+	}
+	if closer, ok := c.w.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// writePrometheus writes every series once: the last value of gauge
+// and total of counter since agent start
+func (c *writerClient) writePrometheus(b *strings.Builder, collections []metrics.Collection) {
+	gauges := make(map[string]float64)
+	types := make(map[string]string)
+	for _, collection := range collections {
+		for _, m := range collection {
+			switch m.Type {
+			case "gauge":
+				gauges[m.Name] = m.Value
+			case "counter":
+				c.totals[m.Name] += m.Value
+			default:
+				continue
+			}
+			types[m.Name] = m.Type
+		}
+	}
+	if len(types) == 0 {
+		return
+	}
+
+	names := make([]string, 0, len(types))
+	for name := range types {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	typed := make(map[string]bool)
+	for _, name := range names {
+		series := promName(name)
+		base := series
+		if i := strings.IndexByte(series, '{'); i >= 0 {
+			base = series[:i]
+		}
+		if !typed[base] {
+			fmt.Fprintf(b, "# TYPE %s %s\n", base, types[name])
+			typed[base] = true
+		}
+
+		value := gauges[name]
+		if types[name] == "counter" {
+			value = c.totals[name]
+		}
+		fmt.Fprintf(b, "%s %s\n", series, strconv.FormatFloat(value, 'g', -1, 64))
+	}
+}
+
+// promName replaces characters not allowed in Prometheus metrics name with underscore.
+// Labels are kept as is.
+func promName(name string) string {
+	base, labels := name, ""
+	if i := strings.IndexByte(name, '{'); i >= 0 {
+		base, labels = name[:i], name[i:]
+	}
+	return strings.Map(func(r rune) rune {
+		if r == '_' || r == ':' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, base) + labels
+}
+
+// influxEscaper escapes measurement, tag keys and values
+var influxEscaper = strings.NewReplacer(",", `\,`, " ", `\ `, "=", `\=`)
+
+// writeInflux writes batch in line protocol, labels become tags
+func writeInflux(b *strings.Builder, collections []metrics.Collection, ts time.Time) error {
+	for _, collection := range collections {
+		for _, m := range collection {
+			name, labels, err := metrics.SplitLabels(m.Name)
+			if err != nil {
+				return err
+			}
+
+			b.WriteString(influxEscaper.Replace(name))
+			keys := make([]string, 0, len(labels))
+			for k := range labels {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				fmt.Fprintf(b, ",%s=%s", influxEscaper.Replace(k), influxEscaper.Replace(labels[k]))
+			}
+
+			switch m.Type {
+			case "gauge":
+				fmt.Fprintf(b, " gauge=%s", strconv.FormatFloat(m.Value, 'g', -1, 64))
+			case "counter":
+				fmt.Fprintf(b, " counter=%di", int64(m.Value))
+			default:
+				return fmt.Errorf("metrics <%s>: type %s unknown", m.Name, m.Type)
+			}
+			fmt.Fprintf(b, " %d\n", ts.UnixNano())
+		}
+	}
+	return nil
+}
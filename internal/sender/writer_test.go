@@ -0,0 +1,90 @@
+package sender
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/SerjRamone/metrius/internal/metrics"
+)
+
+func TestWriterClient(t *testing.T) {
+	batch := []metrics.Collection{
+		{
+			{Name: "Alloc", Type: "gauge", Value: 1.5},
+			{Name: "PollCount", Type: "counter", Value: 1},
+		},
+		{
+			{Name: "Alloc", Type: "gauge", Value: 2.5},
+			{Name: "PollCount", Type: "counter", Value: 1},
+			{Name: `AgentBuildInfo{commit="abc",version="1.0 beta"}`, Type: "gauge", Value: 1},
+		},
+	}
+
+	tests := []struct {
+		name   string
+		format string
+		want   string
+	}{
+		{
+			name:   "Test#1. JSON",
+			format: FormatJSON,
+			want: `[{"id":"Alloc","type":"gauge","value":1.5},{"id":"PollCount","type":"counter","delta":1},` +
+				`{"id":"Alloc","type":"gauge","value":2.5},{"id":"PollCount","type":"counter","delta":1},` +
+				`{"id":"AgentBuildInfo{commit=\"abc\",version=\"1.0 beta\"}","type":"gauge","value":1}]` + "\n",
+		},
+		{
+			name:   "Test#2. Prometheus",
+			format: FormatPrometheus,
+			want: "# TYPE AgentBuildInfo gauge\n" +
+				`AgentBuildInfo{commit="abc",version="1.0 beta"} 1` + "\n" +
+				"# TYPE Alloc gauge\n" +
+				"Alloc 2.5\n" +
+				"# TYPE PollCount counter\n" +
+				"PollCount 2\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			c, err := NewWriterClient(&buf, tt.format)
+			require.NoError(t, err)
+			require.NoError(t, c.DoBatch(context.Background(), batch))
+			assert.Equal(t, tt.want, buf.String())
+		})
+	}
+
+	_, err := NewWriterClient(&bytes.Buffer{}, "xml")
+	assert.Error(t, err)
+}
+
+func TestWriterClient_PrometheusTotals(t *testing.T) {
+	var buf bytes.Buffer
+	c, err := NewWriterClient(&buf, FormatPrometheus)
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, c.Do(context.Background(), metrics.CollectionItem{Name: "Requests", Type: "counter", Value: 2}))
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Equal(t, "Requests 6", lines[len(lines)-1])
+}
+
+func TestWriteInflux(t *testing.T) {
+	var b strings.Builder
+	ts := time.Unix(0, 42)
+	err := writeInflux(&b, []metrics.Collection{{
+		{Name: "Alloc", Type: "gauge", Value: 1.5},
+		{Name: "PollCount", Type: "counter", Value: 3},
+		{Name: `AgentBuildInfo{commit="abc",version="1.0 beta"}`, Type: "gauge", Value: 1},
+	}}, ts)
+	require.NoError(t, err)
+	assert.Equal(t, "Alloc gauge=1.5 42\n"+
+		"PollCount counter=3i 42\n"+
+		`AgentBuildInfo,commit=abc,version=1.0\ beta gauge=1 42`+"\n", b.String())
+}
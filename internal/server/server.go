@@ -3,16 +3,19 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
 	"net"
 	"net/http"
 
 	server "github.com/SerjRamone/metrius/internal/grpc"
+	"github.com/SerjRamone/metrius/internal/middlewares"
 	"github.com/SerjRamone/metrius/internal/storage"
 	"github.com/SerjRamone/metrius/pkg/logger"
 	pb "github.com/SerjRamone/metrius/pkg/metrius_v1"
 	"github.com/go-chi/chi/v5"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 )
 
 // Server ...
@@ -26,11 +29,12 @@ type HTTPServer struct {
 	server *http.Server
 }
 
-// NewHTTPServer ...
-func NewHTTPServer(a string, r chi.Router) *HTTPServer {
+// NewHTTPServer ... tlsConf enables TLS, nil means plain HTTP
+func NewHTTPServer(a string, r chi.Router, tlsConf *tls.Config) *HTTPServer {
 	s := &http.Server{
-		Addr:    a,
-		Handler: r,
+		Addr:      a,
+		Handler:   r,
+		TLSConfig: tlsConf,
 	}
 	return &HTTPServer{
 		server: s,
@@ -39,6 +43,10 @@ func NewHTTPServer(a string, r chi.Router) *HTTPServer {
 
 // Up ...
 func (s *HTTPServer) Up() error {
+	if s.server.TLSConfig != nil {
+		// certificate is taken from TLSConfig
+		return s.server.ListenAndServeTLS("", "")
+	}
 	return s.server.ListenAndServe()
 }
 
@@ -53,9 +61,28 @@ type GRPCServer struct {
 	server  *grpc.Server
 }
 
-// NewGRPCServer ...
-func NewGRPCServer(a string, store storage.Storage) *GRPCServer {
-	s := grpc.NewServer()
+// NewGRPCServer ... tlsConf enables TLS, nil means insecure connections
+func NewGRPCServer(a string, store storage.Storage, sec *middlewares.SecurityHolder, tlsConf *tls.Config) *GRPCServer {
+	opts := []grpc.ServerOption{
+		// requests are decrypted after client checks, sign covers decrypted message
+		grpc.ForceServerCodec(server.NewCryptoCodec(sec)),
+		grpc.ChainUnaryInterceptor(
+			server.SubnetInterceptor(sec),
+			server.AuthInterceptor(sec),
+			server.OpenInterceptor(sec),
+			server.SignInterceptor(sec),
+		),
+		grpc.ChainStreamInterceptor(
+			server.SubnetStreamInterceptor(sec),
+			server.AuthStreamInterceptor(sec),
+			server.OpenStreamInterceptor(sec),
+			server.SignStreamInterceptor(sec),
+		),
+	}
+	if tlsConf != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConf)))
+	}
+	s := grpc.NewServer(opts...)
 	server := server.NewMetricsServer(store)
 	pb.RegisterMetricsServiceServer(s, server)
 
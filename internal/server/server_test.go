@@ -0,0 +1,183 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/SerjRamone/metrius/internal/auth"
+	"github.com/SerjRamone/metrius/internal/metrics"
+	"github.com/SerjRamone/metrius/internal/middlewares"
+	"github.com/SerjRamone/metrius/internal/sender"
+	"github.com/SerjRamone/metrius/internal/storage"
+	pb "github.com/SerjRamone/metrius/pkg/metrius_v1"
+)
+
+// startGRPC runs gRPC server with security on loopback and returns its address
+func startGRPC(t *testing.T, store storage.Storage, s middlewares.Security) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := NewGRPCServer(l.Addr().String(), store, middlewares.NewSecurityHolder(s), nil)
+	go func() { _ = srv.server.Serve(l) }()
+	t.Cleanup(srv.server.Stop)
+	return l.Addr().String()
+}
+
+// dial connects to server without sign and encryption
+func dial(t *testing.T, addr string) pb.MetricsServiceClient {
+	t.Helper()
+	conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return pb.NewMetricsServiceClient(conn)
+}
+
+func TestGRPCServer_Crypto(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	privKey := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	pubKey := pem.EncodeToMemory(&pem.Block{Type: "RSA PUBLIC KEY", Bytes: x509.MarshalPKCS1PublicKey(&key.PublicKey)})
+	s, err := middlewares.NewSecurity("secret", privKey, nil)
+	require.NoError(t, err)
+	store := storage.NewMemStorage(300, nil)
+	addr := startGRPC(t, store, s)
+
+	// signed and encrypted request of agent
+	client, err := sender.NewGRPCApiClient(addr, "secret", pubKey)
+	require.NoError(t, err)
+	defer client.Close()
+	require.NoError(t, client.Do(context.Background(), metrics.CollectionItem{Name: "Alloc", Type: "gauge", Value: 42}))
+	v, ok := store.Gauge(context.Background(), "Alloc")
+	require.True(t, ok)
+	assert.Equal(t, metrics.Gauge(42), v)
+
+	// plain request is rejected
+	_, err = dial(t, addr).BatchUpdate(context.Background(), &pb.BatchUpdateRequest{})
+	assert.Error(t, err)
+}
+
+func TestGRPCServer_CryptoAfterAuth(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	privKey := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	pubKey := pem.EncodeToMemory(&pem.Block{Type: "RSA PUBLIC KEY", Bytes: x509.MarshalPKCS1PublicKey(&key.PublicKey)})
+	s, err := middlewares.NewSecurity("", privKey, nil)
+	require.NoError(t, err)
+	s.Auth, err = auth.NewAuthenticator([]auth.Token{{Token: "writer", Scopes: []string{auth.ScopeWrite}}}, "", nil)
+	require.NoError(t, err)
+	addr := startGRPC(t, storage.NewMemStorage(300, nil), s)
+
+	tests := []struct {
+		name     string
+		token    string
+		pubKey   []byte
+		wantCode codes.Code
+	}{
+		{name: "Test#1. Encrypted request", token: "writer", pubKey: pubKey, wantCode: codes.OK},
+		// request is not decrypted before authentication
+		{name: "Test#2. Plain request without token", wantCode: codes.Unauthenticated},
+		{name: "Test#3. Encrypted request without token", pubKey: pubKey, wantCode: codes.Unauthenticated},
+		{name: "Test#4. Plain request", token: "writer", wantCode: codes.InvalidArgument},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := sender.NewGRPCApiClient(addr, "", tt.pubKey, sender.WithToken(tt.token))
+			require.NoError(t, err)
+			defer client.Close()
+			err = client.Do(context.Background(), metrics.CollectionItem{Name: "Alloc", Type: "gauge", Value: 42})
+			if tt.wantCode == codes.OK {
+				assert.NoError(t, err)
+				return
+			}
+			// client wraps status error, it's checked by text
+			assert.ErrorContains(t, err, "code = "+tt.wantCode.String())
+		})
+	}
+}
+
+func TestGRPCServer_Subnet(t *testing.T) {
+	// test client connects from 127.0.0.1, which is a trusted proxy for proxied filter
+	direct, err := middlewares.NewIPFilter("10.0.0.0/8", "", "")
+	require.NoError(t, err)
+	proxied, err := middlewares.NewIPFilter("10.0.0.0/8", "10.0.0.13/32", "127.0.0.0/8")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name         string
+		filter       *middlewares.IPFilter
+		realIP       string
+		forwardedFor string
+		wantCode     codes.Code
+	}{
+		{name: "Test#1. Peer address out of subnet", filter: direct, wantCode: codes.PermissionDenied},
+		{name: "Test#2. Spoofed metadata", filter: direct, realIP: "10.0.0.1", wantCode: codes.PermissionDenied},
+		{name: "Test#3. Real IP from proxy", filter: proxied, realIP: "10.0.0.1", wantCode: codes.OK},
+		{name: "Test#4. Forwarded from proxy", filter: proxied, forwardedFor: "10.0.0.1", wantCode: codes.OK},
+		{name: "Test#5. Denied IP from proxy", filter: proxied, forwardedFor: "10.0.0.13", wantCode: codes.PermissionDenied},
+		{name: "Test#6. Invalid IP from proxy", filter: proxied, realIP: "localhost", wantCode: codes.InvalidArgument},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := middlewares.NewSecurity("", nil, tt.filter)
+			require.NoError(t, err)
+			client := dial(t, startGRPC(t, storage.NewMemStorage(300, nil), s))
+
+			ctx := context.Background()
+			if tt.realIP != "" {
+				ctx = metadata.AppendToOutgoingContext(ctx, "x-real-ip", tt.realIP)
+			}
+			if tt.forwardedFor != "" {
+				ctx = metadata.AppendToOutgoingContext(ctx, "x-forwarded-for", tt.forwardedFor)
+			}
+			_, err = client.BatchUpdate(ctx, &pb.BatchUpdateRequest{})
+			assert.Equal(t, tt.wantCode, status.Code(err))
+		})
+	}
+}
+
+func TestGRPCServer_Auth(t *testing.T) {
+	a, err := auth.NewAuthenticator([]auth.Token{
+		{Token: "reader", Scopes: []string{auth.ScopeRead}},
+		{Token: "writer", Scopes: []string{auth.ScopeWrite}},
+	}, "", nil)
+	require.NoError(t, err)
+	addr := startGRPC(t, storage.NewMemStorage(300, nil), middlewares.Security{Auth: a})
+
+	tests := []struct {
+		name     string
+		token    string
+		wantCode codes.Code
+	}{
+		{name: "Test#1. Token with scope", token: "writer", wantCode: codes.OK},
+		{name: "Test#2. Token without scope", token: "reader", wantCode: codes.PermissionDenied},
+		{name: "Test#3. Unknown token", token: "unknown", wantCode: codes.Unauthenticated},
+		{name: "Test#4. No token", wantCode: codes.Unauthenticated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := sender.NewGRPCApiClient(addr, "", nil, sender.WithToken(tt.token))
+			require.NoError(t, err)
+			defer client.Close()
+			err = client.Do(context.Background(), metrics.CollectionItem{Name: "Alloc", Type: "gauge", Value: 42})
+			if tt.wantCode == codes.OK {
+				assert.NoError(t, err)
+				return
+			}
+			// client wraps status error, it's checked by text
+			assert.ErrorContains(t, err, "code = "+tt.wantCode.String())
+		})
+	}
+}
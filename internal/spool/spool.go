@@ -0,0 +1,313 @@
+// Package spool persists batches which agent failed to send
+// and replays them in order when the server recovers
+package spool
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/SerjRamone/metrius/internal/metrics"
+	"github.com/SerjRamone/metrius/internal/telemetry"
+	"github.com/SerjRamone/metrius/pkg/logger"
+)
+
+const fileExt = ".json"
+
+// ErrRejected is returned by send of Replay when receiver rejected batch, such batch is dropped
+var ErrRejected = errors.New("batch rejected")
+
+// Batch is a spooled batch. Destinations are names of destinations which must receive it,
+// empty list means all destinations.
+type Batch struct {
+	Collections  []metrics.Collection `json:"collections"`
+	Destinations []string             `json:"destinations,omitempty"`
+}
+
+// entry is a spooled batch file
+type entry struct {
+	name    string
+	created time.Time
+	size    int64
+}
+
+// Spool is a bounded on-disk queue of batches
+type Spool struct {
+	dir      string
+	maxBytes int64
+	maxAge   time.Duration
+
+	// protects entries and files
+	mu      sync.Mutex
+	entries []entry
+	size    int64
+	seq     uint64
+
+	// serializes replays
+	replayMu sync.Mutex
+}
+
+// New opens spool directory and loads batches left by previous runs.
+// Zero maxBytes or maxAge disables the limit.
+func New(dir string, maxBytes int64, maxAge time.Duration) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("creating spool dir <%s> error: %w", dir, err)
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("reading spool dir <%s> error: %w", dir, err)
+	}
+
+	s := &Spool{
+		dir:      dir,
+		maxBytes: maxBytes,
+		maxAge:   maxAge,
+	}
+	for _, f := range files {
+		// remove partially written file
+		if strings.HasSuffix(f.Name(), fileExt+".tmp") {
+			if err = os.Remove(filepath.Join(dir, f.Name())); err != nil {
+				logger.Error("removing temporary spool file error", zap.String("name", f.Name()), zap.Error(err))
+			}
+			continue
+		}
+		if f.IsDir() || !strings.HasSuffix(f.Name(), fileExt) {
+			continue
+		}
+		created, err := parseName(f.Name())
+		if err != nil {
+			logger.Warn("skip unknown spool file", zap.String("name", f.Name()))
+			continue
+		}
+		info, err := f.Info()
+		if err != nil {
+			return nil, err
+		}
+		s.entries = append(s.entries, entry{name: f.Name(), created: created, size: info.Size()})
+		s.size += info.Size()
+	}
+	// file names start with creation time
+	sort.Slice(s.entries, func(i, j int) bool { return s.entries[i].name < s.entries[j].name })
+
+	logger.Info("spool opened", zap.String("dir", dir), zap.Int("batches", len(s.entries)), zap.Int64("size", s.size))
+	return s, nil
+}
+
+// parseName returns batch creation time from file name
+func parseName(name string) (time.Time, error) {
+	ts, _, ok := strings.Cut(strings.TrimSuffix(name, fileExt), "-")
+	if !ok {
+		return time.Time{}, errors.New("invalid spool file name")
+	}
+	nsec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(0, nsec), nil
+}
+
+// Push persists batch for all destinations
+func (s *Spool) Push(collections []metrics.Collection) error {
+	return s.PushBatch(Batch{Collections: collections})
+}
+
+// PushBatch persists batch. The oldest batches are dropped if size limit is exceeded.
+func (s *Spool) PushBatch(b Batch) error {
+	// batch for all destinations is stored as array of collections
+	var v any = b.Collections
+	if len(b.Destinations) > 0 {
+		v = b
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("batch encode error: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.seq++
+	name := fmt.Sprintf("%020d-%010d%s", now.UnixNano(), s.seq, fileExt)
+
+	// write to temporary file and rename it, so batch is never read partially
+	tmp := filepath.Join(s.dir, name+".tmp")
+	if err = os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("writing spool file error: %w", err)
+	}
+	if err = os.Rename(tmp, filepath.Join(s.dir, name)); err != nil {
+		return fmt.Errorf("renaming spool file error: %w", err)
+	}
+
+	s.entries = append(s.entries, entry{name: name, created: now, size: int64(len(data))})
+	s.size += int64(len(data))
+
+	for s.maxBytes > 0 && s.size > s.maxBytes && len(s.entries) > 0 {
+		s.dropLocked(s.entries[0], "size limit exceeded")
+	}
+	return nil
+}
+
+// Replay sends persisted batches from the oldest one and removes sent batches.
+// Replay stops on the first send error and returns it. If error has FailedDestinations() []string
+// method, batch is kept for failed destinations only. Batch is dropped if error is ErrRejected.
+// Batches older than max age are dropped.
+func (s *Spool) Replay(send func(Batch) error) (int, error) {
+	s.replayMu.Lock()
+	defer s.replayMu.Unlock()
+
+	sent := 0
+	for {
+		s.mu.Lock()
+		if len(s.entries) == 0 {
+			s.mu.Unlock()
+			return sent, nil
+		}
+		e := s.entries[0]
+		if s.maxAge > 0 && time.Since(e.created) > s.maxAge {
+			s.dropLocked(e, "max age exceeded")
+			s.mu.Unlock()
+			continue
+		}
+		s.mu.Unlock()
+
+		b, err := s.read(e)
+		if err != nil {
+			logger.Error("reading spool file error", zap.String("name", e.name), zap.Error(err))
+			s.mu.Lock()
+			s.dropLocked(e, "corrupted file")
+			s.mu.Unlock()
+			continue
+		}
+
+		err = send(b)
+		if errors.Is(err, ErrRejected) {
+			logger.Error("spooled batch rejected", zap.String("name", e.name), zap.Error(err))
+			s.mu.Lock()
+			s.dropLocked(e, "rejected")
+			s.mu.Unlock()
+			continue
+		}
+		if err != nil {
+			var partial interface{ FailedDestinations() []string }
+			if errors.As(err, &partial) {
+				b.Destinations = partial.FailedDestinations()
+				s.mu.Lock()
+				s.rewriteLocked(e, b)
+				s.mu.Unlock()
+			}
+			return sent, err
+		}
+		sent++
+
+		s.mu.Lock()
+		s.removeLocked(e)
+		s.mu.Unlock()
+	}
+}
+
+// read reads batch from file
+func (s *Spool) read(e entry) (Batch, error) {
+	var b Batch
+	data, err := os.ReadFile(filepath.Join(s.dir, e.name))
+	if err != nil {
+		return b, err
+	}
+	if len(data) > 0 && data[0] == '[' {
+		err = json.Unmarshal(data, &b.Collections)
+	} else {
+		err = json.Unmarshal(data, &b)
+	}
+	return b, err
+}
+
+// rewriteLocked replaces batch of entry keeping its place in order
+func (s *Spool) rewriteLocked(e entry, b Batch) {
+	idx := -1
+	for i := range s.entries {
+		if s.entries[i].name == e.name {
+			idx = i
+			break
+		}
+	}
+	if idx == -1 {
+		return
+	}
+	data, err := json.Marshal(b)
+	if err != nil {
+		logger.Error("batch encode error", zap.String("name", e.name), zap.Error(err))
+		return
+	}
+	tmp := filepath.Join(s.dir, e.name+".tmp")
+	if err = os.WriteFile(tmp, data, 0o600); err != nil {
+		logger.Error("writing spool file error", zap.String("name", e.name), zap.Error(err))
+		return
+	}
+	if err = os.Rename(tmp, filepath.Join(s.dir, e.name)); err != nil {
+		logger.Error("renaming spool file error", zap.String("name", e.name), zap.Error(err))
+		return
+	}
+	s.size += int64(len(data)) - s.entries[idx].size
+	s.entries[idx].size = int64(len(data))
+}
+
+// dropLocked removes batch which was not sent and counts dropped data
+func (s *Spool) dropLocked(e entry, reason string) {
+	items := 0
+	if b, err := s.read(e); err == nil {
+		for _, c := range b.Collections {
+			items += len(c)
+		}
+	}
+	if !s.removeLocked(e) {
+		return
+	}
+	telemetry.SpoolDropped(items)
+	logger.Warn("spooled batch dropped", zap.String("name", e.name), zap.String("reason", reason), zap.Int("items", items))
+}
+
+// removeLocked removes batch entry and file.
+// Returns false if entry was already removed.
+func (s *Spool) removeLocked(e entry) bool {
+	idx := -1
+	for i := range s.entries {
+		if s.entries[i].name == e.name {
+			idx = i
+			break
+		}
+	}
+	if idx == -1 {
+		return false
+	}
+	if err := os.Remove(filepath.Join(s.dir, e.name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		logger.Error("removing spool file error", zap.String("name", e.name), zap.Error(err))
+	}
+	// size of entry could be changed by rewriteLocked after e was copied
+	s.size -= s.entries[idx].size
+	s.entries = append(s.entries[:idx], s.entries[idx+1:]...)
+	return true
+}
+
+// Len returns number of spooled batches
+func (s *Spool) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.entries)
+}
+
+// Size returns size of spooled batches in bytes
+func (s *Spool) Size() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.size
+}
@@ -0,0 +1,214 @@
+package spool
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/SerjRamone/metrius/internal/metrics"
+	"github.com/SerjRamone/metrius/internal/telemetry"
+)
+
+func batch(name string, value float64) []metrics.Collection {
+	return []metrics.Collection{{{Name: name, Type: "gauge", Value: value}}}
+}
+
+// dropped returns dropped batches and items reported to telemetry since the previous call
+func dropped(t *testing.T) (float64, float64) {
+	c, err := telemetry.Source().Collect()
+	require.NoError(t, err)
+	var batches, items float64
+	for _, item := range c {
+		switch item.Name {
+		case "AgentSpoolBatchesDropped":
+			batches = item.Value
+		case "AgentItemsDropped":
+			items = item.Value
+		}
+	}
+	return batches, items
+}
+
+func TestSpool_PushReplay(t *testing.T) {
+	dir := t.TempDir()
+	s, err := New(dir, 0, 0)
+	require.NoError(t, err)
+
+	require.NoError(t, s.Push(batch("First", 1)))
+	require.NoError(t, s.Push(batch("Second", 2)))
+	require.NoError(t, s.Push(batch("Third", 3)))
+	assert.Equal(t, 3, s.Len())
+
+	// server is still down
+	var received []string
+	sendErr := errors.New("connection refused")
+	sent, err := s.Replay(func(b Batch) error {
+		if len(received) == 1 {
+			return sendErr
+		}
+		received = append(received, b.Collections[0][0].Name)
+		return nil
+	})
+	assert.ErrorIs(t, err, sendErr)
+	assert.Equal(t, 1, sent)
+	assert.Equal(t, 2, s.Len())
+
+	// batches survive restart
+	s, err = New(dir, 0, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 2, s.Len())
+
+	sent, err = s.Replay(func(b Batch) error {
+		received = append(received, b.Collections[0][0].Name)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 2, sent)
+	assert.Equal(t, []string{"First", "Second", "Third"}, received)
+	assert.Equal(t, 0, s.Len())
+	assert.Equal(t, int64(0), s.Size())
+
+	files, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, files)
+}
+
+func TestSpool_MaxSize(t *testing.T) {
+	dropped(t)
+	s, err := New(t.TempDir(), 1, 0)
+	require.NoError(t, err)
+	require.NoError(t, s.Push(batch("First", 1)))
+
+	// limit is less than one batch, so the only one is dropped too
+	assert.Equal(t, 0, s.Len())
+	batches, items := dropped(t)
+	assert.Equal(t, 1.0, batches)
+	assert.Equal(t, 1.0, items)
+
+	s, err = New(t.TempDir(), 200, 0)
+	require.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		require.NoError(t, s.Push(batch("Value", float64(i))))
+	}
+	assert.LessOrEqual(t, s.Size(), int64(200))
+	batches, _ = dropped(t)
+	assert.Equal(t, float64(10-s.Len()), batches)
+
+	// the newest batches are kept
+	var last float64
+	_, err = s.Replay(func(b Batch) error {
+		last = b.Collections[0][0].Value
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, float64(9), last)
+}
+
+func TestSpool_MaxAge(t *testing.T) {
+	dropped(t)
+	dir := t.TempDir()
+	old := time.Now().Add(-2 * time.Hour).UnixNano()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, fmt.Sprintf("%020d-%010d.json", old, 1)), []byte("[]"), 0o600))
+
+	s, err := New(dir, 0, time.Hour)
+	require.NoError(t, err)
+	require.NoError(t, s.Push(batch("Fresh", 1)))
+	assert.Equal(t, 2, s.Len())
+
+	var received []string
+	sent, err := s.Replay(func(b Batch) error {
+		received = append(received, b.Collections[0][0].Name)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 1, sent)
+	assert.Equal(t, []string{"Fresh"}, received)
+	batches, _ := dropped(t)
+	assert.Equal(t, 1.0, batches)
+}
+
+func TestSpool_CorruptedFile(t *testing.T) {
+	dropped(t)
+	dir := t.TempDir()
+	s, err := New(dir, 0, 0)
+	require.NoError(t, err)
+	require.NoError(t, s.Push(batch("First", 1)))
+
+	files, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, files[0].Name()), []byte("{"), 0o600))
+
+	sent, err := s.Replay(func(Batch) error { return nil })
+	require.NoError(t, err)
+	assert.Equal(t, 0, sent)
+	batches, _ := dropped(t)
+	assert.Equal(t, 1.0, batches)
+}
+
+// partialError reports destinations which didn't receive batch
+type partialError []string
+
+func (e partialError) Error() string                { return "partial failure" }
+func (e partialError) FailedDestinations() []string { return e }
+
+func TestSpool_PartialReplay(t *testing.T) {
+	dir := t.TempDir()
+	s, err := New(dir, 0, 0)
+	require.NoError(t, err)
+	require.NoError(t, s.PushBatch(Batch{Collections: batch("First", 1), Destinations: []string{"prod", "staging"}}))
+	require.NoError(t, s.Push(batch("Second", 2)))
+
+	// staging is down, batch is kept for it only
+	sent, err := s.Replay(func(Batch) error { return partialError{"staging"} })
+	assert.Error(t, err)
+	assert.Equal(t, 0, sent)
+	assert.Equal(t, 2, s.Len())
+
+	// order and narrowed destinations survive restart
+	s, err = New(dir, 0, 0)
+	require.NoError(t, err)
+	var received []Batch
+	sent, err = s.Replay(func(b Batch) error {
+		received = append(received, b)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 2, sent)
+	assert.Equal(t, []Batch{
+		{Collections: batch("First", 1), Destinations: []string{"staging"}},
+		{Collections: batch("Second", 2)},
+	}, received)
+	assert.Equal(t, int64(0), s.Size())
+}
+
+func TestSpool_RejectedReplay(t *testing.T) {
+	dropped(t)
+	s, err := New(t.TempDir(), 0, 0)
+	require.NoError(t, err)
+	require.NoError(t, s.Push(batch("Poison", 1)))
+	require.NoError(t, s.Push(batch("Valid", 2)))
+
+	// rejected batch doesn't block the next ones
+	var received []string
+	sent, err := s.Replay(func(b Batch) error {
+		if b.Collections[0][0].Name == "Poison" {
+			return fmt.Errorf("%w: 400 Bad Request", ErrRejected)
+		}
+		received = append(received, b.Collections[0][0].Name)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 1, sent)
+	assert.Equal(t, []string{"Valid"}, received)
+	assert.Equal(t, 0, s.Len())
+	batches, items := dropped(t)
+	assert.Equal(t, 1.0, batches)
+	assert.Equal(t, 1.0, items)
+}
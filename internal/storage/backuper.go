@@ -8,17 +8,28 @@ import (
 	"go.uber.org/zap"
 
 	"github.com/SerjRamone/metrius/internal/metrics"
+	"github.com/SerjRamone/metrius/internal/tenant"
 	"github.com/SerjRamone/metrius/pkg/logger"
 )
 
-// BackupRestorer different types of persistent storages
+// BackupRestorer different types of persistent storages, metrics are passed by tenant and name
 type BackupRestorer interface {
-	Backup(map[string]metrics.Gauge, map[string]metrics.Counter) error
-	Restore(map[string]metrics.Gauge, map[string]metrics.Counter) error
+	Backup(map[string]map[string]metrics.Gauge, map[string]map[string]metrics.Counter) error
+	Restore(map[string]map[string]metrics.Gauge, map[string]map[string]metrics.Counter) error
 }
 
 var _ BackupRestorer = (*FileBackuper)(nil)
 
+// backupItem is metrics in backup file, tenant is omitted for the default tenant,
+// so backups of single-tenant server are restored to the default tenant
+type backupItem struct {
+	Delta  *int64   `json:"delta,omitempty"`
+	Value  *float64 `json:"value,omitempty"`
+	ID     string   `json:"id"`
+	MType  string   `json:"type"`
+	Tenant string   `json:"tenant,omitempty"`
+}
+
 // FileBackuper file backuper struct
 type FileBackuper struct {
 	file *os.File
@@ -32,8 +43,8 @@ func NewFileBackuper(f *os.File) FileBackuper {
 }
 
 // Backup put metrics to file
-func (fb FileBackuper) Backup(gauges map[string]metrics.Gauge, counters map[string]metrics.Counter) error {
-	var structs []metrics.Metrics
+func (fb FileBackuper) Backup(gauges map[string]map[string]metrics.Gauge, counters map[string]map[string]metrics.Counter) error {
+	var structs []backupItem
 	// clear file content
 	if _, err := fb.file.Seek(0, 0); err != nil {
 		logger.Info("seek file error")
@@ -43,22 +54,28 @@ func (fb FileBackuper) Backup(gauges map[string]metrics.Gauge, counters map[stri
 		return err
 	}
 
-	for mName, mValue := range gauges {
-		fValue := float64(mValue)
-		structs = append(structs, metrics.Metrics{
-			ID:    mName,
-			MType: "gauge",
-			Value: &fValue,
-		})
+	for t, tGauges := range gauges {
+		for mName, mValue := range tGauges {
+			fValue := float64(mValue)
+			structs = append(structs, backupItem{
+				ID:     mName,
+				MType:  "gauge",
+				Value:  &fValue,
+				Tenant: backupTenant(t),
+			})
+		}
 	}
 
-	for mName, mValue := range counters {
-		iValue := int64(mValue)
-		structs = append(structs, metrics.Metrics{
-			ID:    mName,
-			MType: "counter",
-			Delta: &iValue,
-		})
+	for t, tCounters := range counters {
+		for mName, mValue := range tCounters {
+			iValue := int64(mValue)
+			structs = append(structs, backupItem{
+				ID:     mName,
+				MType:  "counter",
+				Delta:  &iValue,
+				Tenant: backupTenant(t),
+			})
+		}
 	}
 
 	bytes, err := json.Marshal(structs)
@@ -76,20 +93,32 @@ func (fb FileBackuper) Backup(gauges map[string]metrics.Gauge, counters map[stri
 }
 
 // Restore get metrics from file backup
-func (fb FileBackuper) Restore(gauges map[string]metrics.Gauge, counters map[string]metrics.Counter) error {
-	var structs []metrics.Metrics
+func (fb FileBackuper) Restore(gauges map[string]map[string]metrics.Gauge, counters map[string]map[string]metrics.Counter) error {
+	var structs []backupItem
 	decoder := json.NewDecoder(fb.file)
 	if err := decoder.Decode(&structs); err != nil && err != io.EOF {
 		return err
 	}
 	for _, v := range structs {
+		t := v.Tenant
+		if t == "" {
+			t = tenant.Default
+		}
 		switch v.MType {
 		case "gauge":
-			gauges[v.ID] = metrics.Gauge(*v.Value)
+			tenantGauges(gauges, t)[v.ID] = metrics.Gauge(*v.Value)
 		case "counter":
-			counters[v.ID] = metrics.Counter(*v.Delta)
+			tenantCounters(counters, t)[v.ID] = metrics.Counter(*v.Delta)
 		}
 	}
 	logger.Info("success restored", zap.Int("metrics count", len(structs)))
 	return nil
 }
+
+// backupTenant returns tenant of backup item
+func backupTenant(t string) string {
+	if t == tenant.Default {
+		return ""
+	}
+	return t
+}
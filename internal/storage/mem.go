@@ -4,10 +4,12 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"sync"
 
 	"go.uber.org/zap"
 
 	"github.com/SerjRamone/metrius/internal/metrics"
+	"github.com/SerjRamone/metrius/internal/tenant"
 	"github.com/SerjRamone/metrius/pkg/logger"
 )
 
@@ -16,43 +18,48 @@ var (
 	_                   Storage = (*MemStorage)(nil)
 )
 
-// MemStorage is a in-memory storage
+// MemStorage is a in-memory storage, metrics are kept by tenant of context
 type MemStorage struct {
 	backuper      BackupRestorer
-	gauges        map[string]metrics.Gauge
-	counters      map[string]metrics.Counter
+	mu            *sync.RWMutex
+	gauges        map[string]map[string]metrics.Gauge
+	counters      map[string]map[string]metrics.Counter
 	storeInterval int
 }
 
 // NewMemStorage is a constructor of MemStorage storage
 func NewMemStorage(storeInterval int, backuper BackupRestorer) MemStorage {
 	return MemStorage{
-		gauges:        map[string]metrics.Gauge{},
-		counters:      map[string]metrics.Counter{},
+		mu:            &sync.RWMutex{},
+		gauges:        map[string]map[string]metrics.Gauge{},
+		counters:      map[string]map[string]metrics.Counter{},
 		storeInterval: storeInterval,
 		backuper:      backuper,
 	}
 }
 
-// Backup persist store for MemStorage
-func (s MemStorage) Backup(ctx context.Context) error {
+// Backup persist store for MemStorage, metrics of all tenants are stored
+func (s MemStorage) Backup(_ context.Context) error {
 	// make local copies of maps
-	// @todo mutex in future
-	gauges := make(map[string]metrics.Gauge)
-	counters := make(map[string]metrics.Counter)
-	for k, v := range s.Gauges(ctx) {
-		gauges[k] = v
+	s.mu.RLock()
+	gauges := make(map[string]map[string]metrics.Gauge, len(s.gauges))
+	counters := make(map[string]map[string]metrics.Counter, len(s.counters))
+	for t, m := range s.gauges {
+		gauges[t] = copyMap(m)
 	}
-	for k, v := range s.Counters(ctx) {
-		counters[k] = v
+	for t, m := range s.counters {
+		counters[t] = copyMap(m)
 	}
+	s.mu.RUnlock()
 
 	return s.backuper.Backup(gauges, counters)
 }
 
 // Restore ...
-func (s MemStorage) Restore(ctx context.Context) error {
-	return s.backuper.Restore(s.Gauges(ctx), s.Counters(ctx))
+func (s MemStorage) Restore(_ context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.backuper.Restore(s.gauges, s.counters)
 }
 
 // SetGauge insert or update metrics value of type gauge
@@ -60,7 +67,9 @@ func (s MemStorage) SetGauge(ctx context.Context, name string, value metrics.Gau
 	if s.gauges == nil {
 		return fmt.Errorf("%w", errorStorageNotInit)
 	}
-	s.gauges[name] = value
+	s.mu.Lock()
+	tenantGauges(s.gauges, tenant.FromContext(ctx))[name] = value
+	s.mu.Unlock()
 	if s.storeInterval == 0 {
 		if err := s.Backup(ctx); err != nil {
 			return err
@@ -70,8 +79,10 @@ func (s MemStorage) SetGauge(ctx context.Context, name string, value metrics.Gau
 }
 
 // Gauge returns value of type gauge by name
-func (s MemStorage) Gauge(_ context.Context, name string) (v metrics.Gauge, ok bool) {
-	v, ok = s.gauges[name]
+func (s MemStorage) Gauge(ctx context.Context, name string) (v metrics.Gauge, ok bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	v, ok = s.gauges[tenant.FromContext(ctx)][name]
 	return
 }
 
@@ -80,7 +91,9 @@ func (s MemStorage) SetCounter(ctx context.Context, name string, value metrics.C
 	if s.counters == nil {
 		return fmt.Errorf("%w", errorStorageNotInit)
 	}
-	s.counters[name] += value
+	s.mu.Lock()
+	tenantCounters(s.counters, tenant.FromContext(ctx))[name] += value
+	s.mu.Unlock()
 	if s.storeInterval == 0 {
 		if err := s.Backup(ctx); err != nil {
 			return err
@@ -92,18 +105,24 @@ func (s MemStorage) SetCounter(ctx context.Context, name string, value metrics.C
 
 // Counter returns value of type counter by name
 func (s MemStorage) Counter(ctx context.Context, name string) (v metrics.Counter, ok bool) {
-	v, ok = s.counters[name]
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	v, ok = s.counters[tenant.FromContext(ctx)][name]
 	return
 }
 
-// Gauges returns map of all setted gauges
+// Gauges returns copy of all setted gauges
 func (s MemStorage) Gauges(ctx context.Context) map[string]metrics.Gauge {
-	return s.gauges
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return copyMap(s.gauges[tenant.FromContext(ctx)])
 }
 
-// Counters returns map of all setted counters
+// Counters returns copy of all setted counters
 func (s MemStorage) Counters(ctx context.Context) map[string]metrics.Counter {
-	return s.counters
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return copyMap(s.counters[tenant.FromContext(ctx)])
 }
 
 // BatchUpsert insert or updates metrics in batches
@@ -127,3 +146,32 @@ func (s MemStorage) BatchUpsert(ctx context.Context, batch []metrics.Metrics) er
 
 	return nil
 }
+
+// tenantGauges returns gauges of tenant, map is created if it doesn't exist
+func tenantGauges(all map[string]map[string]metrics.Gauge, t string) map[string]metrics.Gauge {
+	m, ok := all[t]
+	if !ok {
+		m = map[string]metrics.Gauge{}
+		all[t] = m
+	}
+	return m
+}
+
+// tenantCounters returns counters of tenant, map is created if it doesn't exist
+func tenantCounters(all map[string]map[string]metrics.Counter, t string) map[string]metrics.Counter {
+	m, ok := all[t]
+	if !ok {
+		m = map[string]metrics.Counter{}
+		all[t] = m
+	}
+	return m
+}
+
+// copyMap returns copy of map, it's not nil
+func copyMap[V any](m map[string]V) map[string]V {
+	c := make(map[string]V, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
@@ -0,0 +1,159 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/SerjRamone/metrius/internal/metrics"
+	"github.com/SerjRamone/metrius/internal/tenant"
+)
+
+var _ Storage = (*QuotaStorage)(nil)
+
+// QuotaStorage enforces quotas of tenants on writes to storage.
+// Series of tenant are loaded from storage on its first write and are tracked in memory then.
+type QuotaStorage struct {
+	Storage
+	quotas *tenant.Quotas
+
+	// mu guards series, storage is not called under it
+	mu     sync.Mutex
+	series map[string]map[string]struct{}
+}
+
+// NewQuotaStorage wraps storage s with quotas q
+func NewQuotaStorage(s Storage, q *tenant.Quotas) *QuotaStorage {
+	return &QuotaStorage{
+		Storage: s,
+		quotas:  q,
+		series:  map[string]map[string]struct{}{},
+	}
+}
+
+// Unwrap returns wrapped storage
+func (s *QuotaStorage) Unwrap() Storage {
+	return s.Storage
+}
+
+// SetGauge checks quotas and sets gauge
+func (s *QuotaStorage) SetGauge(ctx context.Context, name string, value metrics.Gauge) error {
+	added, err := s.admit(ctx, seriesKey("gauge", name))
+	if err != nil {
+		return err
+	}
+	return s.forgetOnError(ctx, added, s.Storage.SetGauge(ctx, name, value))
+}
+
+// SetCounter checks quotas and increases counter
+func (s *QuotaStorage) SetCounter(ctx context.Context, name string, value metrics.Counter) error {
+	added, err := s.admit(ctx, seriesKey("counter", name))
+	if err != nil {
+		return err
+	}
+	return s.forgetOnError(ctx, added, s.Storage.SetCounter(ctx, name, value))
+}
+
+// BatchUpsert checks quotas for the whole batch and upserts it
+func (s *QuotaStorage) BatchUpsert(ctx context.Context, batch []metrics.Metrics) error {
+	keys := make([]string, 0, len(batch))
+	for _, m := range batch {
+		keys = append(keys, seriesKey(m.MType, m.ID))
+	}
+	added, err := s.admit(ctx, keys...)
+	if err != nil {
+		return err
+	}
+	return s.forgetOnError(ctx, added, s.Storage.BatchUpsert(ctx, batch))
+}
+
+// admit checks series limit and ingestion rate of tenant of context and returns new series.
+// New series are reserved before write, so concurrent writes can't exceed limit.
+// Series are checked first, so batch rejected by series limit doesn't spend rate.
+func (s *QuotaStorage) admit(ctx context.Context, keys ...string) ([]string, error) {
+	t := tenant.FromContext(ctx)
+	limit := s.quotas.Get(t).MaxSeries
+	if limit <= 0 {
+		if !s.quotas.AllowN(t, len(keys)) {
+			return nil, fmt.Errorf("%w: tenant <%s>, batch of %d metrics", tenant.ErrRateLimit, t, len(keys))
+		}
+		return nil, nil
+	}
+
+	known := s.known(ctx, t)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var added []string
+	for _, k := range keys {
+		if _, ok := known[k]; !ok {
+			known[k] = struct{}{}
+			added = append(added, k)
+		}
+	}
+	var err error
+	if len(known) > limit {
+		err = fmt.Errorf("%w: tenant <%s>, limit %d", tenant.ErrSeriesLimit, t, limit)
+	} else if !s.quotas.AllowN(t, len(keys)) {
+		err = fmt.Errorf("%w: tenant <%s>, batch of %d metrics", tenant.ErrRateLimit, t, len(keys))
+	}
+	if err != nil {
+		// batch is rejected entirely, so its new series are forgotten
+		s.forgetLocked(t, added)
+		return nil, err
+	}
+	return added, nil
+}
+
+// forgetOnError forgets new series if write failed, so they don't take quota of tenant
+func (s *QuotaStorage) forgetOnError(ctx context.Context, added []string, err error) error {
+	if err != nil && len(added) > 0 {
+		s.mu.Lock()
+		s.forgetLocked(tenant.FromContext(ctx), added)
+		s.mu.Unlock()
+	}
+	return err
+}
+
+// forgetLocked removes series of tenant t
+func (s *QuotaStorage) forgetLocked(t string, keys []string) {
+	for _, k := range keys {
+		delete(s.series[t], k)
+	}
+}
+
+// known returns series of tenant t, they are loaded from storage without lock on the first write
+func (s *QuotaStorage) known(ctx context.Context, t string) map[string]struct{} {
+	s.mu.Lock()
+	known, ok := s.series[t]
+	s.mu.Unlock()
+	if ok {
+		return known
+	}
+
+	loaded := s.load(ctx)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// series could be loaded by concurrent write
+	if known, ok = s.series[t]; ok {
+		return known
+	}
+	s.series[t] = loaded
+	return loaded
+}
+
+// load returns series of tenant of context kept in storage
+func (s *QuotaStorage) load(ctx context.Context) map[string]struct{} {
+	known := map[string]struct{}{}
+	for name := range s.Storage.Gauges(ctx) {
+		known[seriesKey("gauge", name)] = struct{}{}
+	}
+	for name := range s.Storage.Counters(ctx) {
+		known[seriesKey("counter", name)] = struct{}{}
+	}
+	return known
+}
+
+// seriesKey returns key of series by metrics type and name
+func seriesKey(mType, name string) string {
+	return mType + "/" + name
+}
@@ -0,0 +1,134 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/SerjRamone/metrius/internal/metrics"
+	"github.com/SerjRamone/metrius/internal/tenant"
+)
+
+func TestMemStorage_Tenants(t *testing.T) {
+	f, err := os.CreateTemp(t.TempDir(), "backup")
+	require.NoError(t, err)
+	defer f.Close()
+	s := NewMemStorage(300, NewFileBackuper(f))
+
+	teamA := tenant.NewContext(context.Background(), "team-a")
+	teamB := tenant.NewContext(context.Background(), "team-b")
+	require.NoError(t, s.SetGauge(teamA, "Alloc", 1))
+	require.NoError(t, s.SetGauge(teamB, "Alloc", 2))
+	require.NoError(t, s.SetCounter(context.Background(), "PollCount", 3))
+
+	v, ok := s.Gauge(teamA, "Alloc")
+	assert.True(t, ok)
+	assert.Equal(t, metrics.Gauge(1), v)
+	_, ok = s.Gauge(context.Background(), "Alloc")
+	assert.False(t, ok)
+	_, ok = s.Counter(teamA, "PollCount")
+	assert.False(t, ok)
+	assert.Equal(t, map[string]metrics.Gauge{"Alloc": 2}, s.Gauges(teamB))
+
+	// tenants are kept in backup
+	require.NoError(t, s.Backup(context.Background()))
+	_, err = f.Seek(0, 0)
+	require.NoError(t, err)
+	restored := NewMemStorage(300, NewFileBackuper(f))
+	require.NoError(t, restored.Restore(context.Background()))
+	assert.Equal(t, map[string]metrics.Gauge{"Alloc": 1}, restored.Gauges(teamA))
+	assert.Equal(t, map[string]metrics.Gauge{"Alloc": 2}, restored.Gauges(teamB))
+	assert.Equal(t, map[string]metrics.Counter{"PollCount": 3}, restored.Counters(context.Background()))
+}
+
+func TestQuotaStorage(t *testing.T) {
+	quotas := tenant.NewQuotas(tenant.Quota{MaxSeries: 2}, map[string]tenant.Quota{
+		"limited": {Rate: 1, Burst: 2},
+		"both":    {MaxSeries: 1, Rate: 1, Burst: 2},
+		"batch":   {Rate: 100, Burst: 2},
+	})
+	mem := NewMemStorage(300, nil)
+	ctx := context.Background()
+	require.NoError(t, mem.SetGauge(ctx, "Alloc", 1))
+	s := NewQuotaStorage(mem, quotas)
+
+	// series kept in storage are counted
+	value := 1.0
+	require.NoError(t, s.SetGauge(ctx, "Alloc", 2))
+	require.NoError(t, s.SetCounter(ctx, "PollCount", 1))
+	assert.ErrorIs(t, s.SetGauge(ctx, "HeapAlloc", 1), tenant.ErrSeriesLimit)
+	assert.ErrorIs(t, s.BatchUpsert(ctx, []metrics.Metrics{
+		{ID: "Alloc", MType: "gauge", Value: &value},
+		{ID: "HeapAlloc", MType: "gauge", Value: &value},
+	}), tenant.ErrSeriesLimit)
+	require.NoError(t, s.BatchUpsert(ctx, []metrics.Metrics{{ID: "Alloc", MType: "gauge", Value: &value}}))
+	assert.Equal(t, metrics.Gauge(1), s.Gauges(ctx)["Alloc"])
+	_, ok := s.Gauge(ctx, "HeapAlloc")
+	assert.False(t, ok)
+
+	// series of other tenant are counted separately
+	other := tenant.NewContext(ctx, "other")
+	require.NoError(t, s.SetGauge(other, "HeapAlloc", 1))
+
+	// ingestion rate
+	limited := tenant.NewContext(ctx, "limited")
+	require.NoError(t, s.SetGauge(limited, "Alloc", 1))
+	require.NoError(t, s.SetGauge(limited, "Alloc", 1))
+	assert.ErrorIs(t, s.SetGauge(limited, "Alloc", 1), tenant.ErrRateLimit)
+
+	// batch bigger than burst is rejected even if bucket is full
+	assert.ErrorIs(t, s.BatchUpsert(tenant.NewContext(ctx, "batch"), []metrics.Metrics{
+		{ID: "Alloc", MType: "gauge", Value: &value},
+		{ID: "HeapAlloc", MType: "gauge", Value: &value},
+		{ID: "PollCount", MType: "gauge", Value: &value},
+	}), tenant.ErrRateLimit)
+
+	// batch rejected by series limit doesn't spend rate
+	both := tenant.NewContext(ctx, "both")
+	require.NoError(t, s.SetGauge(both, "Alloc", 1))
+	assert.ErrorIs(t, s.SetGauge(both, "HeapAlloc", 1), tenant.ErrSeriesLimit)
+	require.NoError(t, s.SetGauge(both, "Alloc", 1))
+	assert.ErrorIs(t, s.SetGauge(both, "Alloc", 1), tenant.ErrRateLimit)
+
+	assert.Equal(t, mem, s.Unwrap())
+}
+
+// failingStorage fails writes while err is set
+type failingStorage struct {
+	Storage
+	err error
+}
+
+func (s *failingStorage) SetGauge(ctx context.Context, name string, value metrics.Gauge) error {
+	if s.err != nil {
+		return s.err
+	}
+	return s.Storage.SetGauge(ctx, name, value)
+}
+
+func (s *failingStorage) BatchUpsert(ctx context.Context, batch []metrics.Metrics) error {
+	if s.err != nil {
+		return s.err
+	}
+	return s.Storage.BatchUpsert(ctx, batch)
+}
+
+func TestQuotaStorage_FailedWrite(t *testing.T) {
+	quotas := tenant.NewQuotas(tenant.Quota{MaxSeries: 1}, nil)
+	failing := &failingStorage{Storage: NewMemStorage(300, nil), err: errors.New("connection lost")}
+	s := NewQuotaStorage(failing, quotas)
+	ctx := context.Background()
+	value := 1.0
+
+	// series of failed writes don't take quota
+	assert.ErrorIs(t, s.SetGauge(ctx, "Alloc", 1), failing.err)
+	assert.ErrorIs(t, s.BatchUpsert(ctx, []metrics.Metrics{{ID: "HeapAlloc", MType: "gauge", Value: &value}}), failing.err)
+
+	failing.err = nil
+	require.NoError(t, s.SetGauge(ctx, "PollCount", 1))
+	assert.ErrorIs(t, s.SetGauge(ctx, "Alloc", 1), tenant.ErrSeriesLimit)
+}
@@ -12,13 +12,14 @@ import (
 	"go.uber.org/zap"
 
 	"github.com/SerjRamone/metrius/internal/metrics"
+	"github.com/SerjRamone/metrius/internal/tenant"
 	"github.com/SerjRamone/metrius/pkg/logger"
 	"github.com/SerjRamone/metrius/pkg/retry"
 )
 
 var _ Storage = (*SQLStorage)(nil)
 
-// SQLStorage is a database storage
+// SQLStorage is a database storage, metrics are kept by tenant of context
 type SQLStorage struct {
 	db *sql.DB
 }
@@ -54,19 +55,19 @@ func NewSQLStorage(dsn string) (SQLStorage, error) {
 
 // SetGauge insert or update metrics value of type gauge
 func (dbs SQLStorage) SetGauge(ctx context.Context, name string, value metrics.Gauge) error {
-	stmt, err := dbs.db.PrepareContext(ctx, "INSERT INTO metrics (id, mtype, value) VALUES ($1, $2, $3) ON CONFLICT (id) DO UPDATE SET value = EXCLUDED.value, updated_at = NOW()")
+	stmt, err := dbs.db.PrepareContext(ctx, "INSERT INTO metrics (tenant, id, mtype, value) VALUES ($1, $2, $3, $4) ON CONFLICT (tenant, id) DO UPDATE SET value = EXCLUDED.value, updated_at = NOW()")
 	if err != nil {
 		logger.Error("statement creating error", zap.Error(err))
 		return err
 	}
 	defer stmt.Close()
-	_, err = stmt.ExecContext(context.TODO(), name, "gauge", float64(value))
+	_, err = stmt.ExecContext(context.TODO(), tenant.FromContext(ctx), name, "gauge", float64(value))
 	if err != nil {
 		var pgErr *pgconn.PgError
 		if errors.As(err, &pgErr) {
 			if pgerrcode.IsConnectionException(pgErr.Code) {
 				err = retry.WithBackoff(func() error {
-					_, err = stmt.ExecContext(context.TODO(), name, "gauge", float64(value))
+					_, err = stmt.ExecContext(context.TODO(), tenant.FromContext(ctx), name, "gauge", float64(value))
 					return err
 				}, 3)
 			}
@@ -80,14 +81,14 @@ func (dbs SQLStorage) SetGauge(ctx context.Context, name string, value metrics.G
 
 // Gauge returns value of type gauge by name
 func (dbs SQLStorage) Gauge(ctx context.Context, name string) (metrics.Gauge, bool) {
-	stmt, err := dbs.db.PrepareContext(ctx, "SELECT value FROM metrics WHERE mtype='gauge' AND id=$1")
+	stmt, err := dbs.db.PrepareContext(ctx, "SELECT value FROM metrics WHERE tenant=$1 AND mtype='gauge' AND id=$2")
 	if err != nil {
 		logger.Error("statement creating error", zap.Error(err))
 		return 0, false
 	}
 	defer stmt.Close()
 	var row *sql.Row
-	row = stmt.QueryRowContext(context.TODO(), name)
+	row = stmt.QueryRowContext(context.TODO(), tenant.FromContext(ctx), name)
 	var value float64
 	err = row.Scan(&value)
 	if err != nil {
@@ -95,7 +96,7 @@ func (dbs SQLStorage) Gauge(ctx context.Context, name string) (metrics.Gauge, bo
 		if errors.As(err, &pgErr) {
 			if pgerrcode.IsConnectionException(pgErr.Code) {
 				err = retry.WithBackoff(func() error {
-					row = stmt.QueryRowContext(context.TODO(), name)
+					row = stmt.QueryRowContext(context.TODO(), tenant.FromContext(ctx), name)
 					err = row.Scan(&value)
 					if !errors.Is(err, sql.ErrNoRows) {
 						return err
@@ -113,7 +114,7 @@ func (dbs SQLStorage) Gauge(ctx context.Context, name string) (metrics.Gauge, bo
 // Gauges returns map of all setted gauges
 func (dbs SQLStorage) Gauges(ctx context.Context) map[string]metrics.Gauge {
 	result := map[string]metrics.Gauge{}
-	rows, err := dbs.db.QueryContext(ctx, "SELECT id, delta FROM metrics WHERE mtype='gauge'")
+	rows, err := dbs.db.QueryContext(ctx, "SELECT id, delta FROM metrics WHERE tenant=$1 AND mtype='gauge'", tenant.FromContext(ctx))
 	if err != nil {
 		logger.Error("can't do select query")
 		return result
@@ -143,13 +144,13 @@ func (dbs SQLStorage) Gauges(ctx context.Context) map[string]metrics.Gauge {
 
 // SetCounter increase metrics value of type counter
 func (dbs SQLStorage) SetCounter(ctx context.Context, name string, value metrics.Counter) error {
-	stmt, err := dbs.db.PrepareContext(ctx, "INSERT INTO metrics (id, mtype, delta) VALUES ($1, $2, $3) ON CONFLICT (id) DO UPDATE SET delta = EXCLUDED.delta + metrics.delta, updated_at = NOW()")
+	stmt, err := dbs.db.PrepareContext(ctx, "INSERT INTO metrics (tenant, id, mtype, delta) VALUES ($1, $2, $3, $4) ON CONFLICT (tenant, id) DO UPDATE SET delta = EXCLUDED.delta + metrics.delta, updated_at = NOW()")
 	if err != nil {
 		logger.Error("statement creating error", zap.Error(err))
 		return err
 	}
 	defer stmt.Close()
-	_, err = stmt.ExecContext(context.TODO(), name, "counter", int64(value))
+	_, err = stmt.ExecContext(context.TODO(), tenant.FromContext(ctx), name, "counter", int64(value))
 	if err != nil {
 		logger.Error("db upsert error", zap.String("name", name), zap.Int64("delta", int64(value)), zap.Error(err))
 		return err
@@ -160,13 +161,13 @@ func (dbs SQLStorage) SetCounter(ctx context.Context, name string, value metrics
 
 // Counter returns value of type counter by name
 func (dbs SQLStorage) Counter(ctx context.Context, name string) (metrics.Counter, bool) {
-	stmt, err := dbs.db.PrepareContext(ctx, "SELECT delta FROM metrics WHERE mtype='counter' AND id=$1")
+	stmt, err := dbs.db.PrepareContext(ctx, "SELECT delta FROM metrics WHERE tenant=$1 AND mtype='counter' AND id=$2")
 	if err != nil {
 		logger.Error("statement creating error", zap.Error(err))
 		return 0, false
 	}
 	defer stmt.Close()
-	row := stmt.QueryRowContext(context.TODO(), name)
+	row := stmt.QueryRowContext(context.TODO(), tenant.FromContext(ctx), name)
 	var delta int64
 	err = row.Scan(&delta)
 	if err != nil {
@@ -181,7 +182,7 @@ func (dbs SQLStorage) Counter(ctx context.Context, name string) (metrics.Counter
 // Counters returns map of all setted counters
 func (dbs SQLStorage) Counters(ctx context.Context) map[string]metrics.Counter {
 	result := map[string]metrics.Counter{}
-	rows, err := dbs.db.QueryContext(ctx, "SELECT id, delta FROM metrics WHERE mtype='counter'")
+	rows, err := dbs.db.QueryContext(ctx, "SELECT id, delta FROM metrics WHERE tenant=$1 AND mtype='counter'", tenant.FromContext(ctx))
 	if err != nil {
 		logger.Error("can't do select query")
 		return result
@@ -218,7 +219,7 @@ func (dbs SQLStorage) BatchUpsert(ctx context.Context, batch []metrics.Metrics)
 	}
 
 	// gauge statement
-	stmtG, err := dbs.db.PrepareContext(ctx, "INSERT INTO metrics (id, mtype, value) VALUES ($1, $2, $3) ON CONFLICT (id) DO UPDATE SET value = EXCLUDED.value, updated_at = NOW()")
+	stmtG, err := dbs.db.PrepareContext(ctx, "INSERT INTO metrics (tenant, id, mtype, value) VALUES ($1, $2, $3, $4) ON CONFLICT (tenant, id) DO UPDATE SET value = EXCLUDED.value, updated_at = NOW()")
 	if err != nil {
 		logger.Error("gauge statement creating error", zap.Error(err))
 		return err
@@ -226,17 +227,18 @@ func (dbs SQLStorage) BatchUpsert(ctx context.Context, batch []metrics.Metrics)
 	defer stmtG.Close()
 
 	// counter statement
-	stmtC, err := dbs.db.PrepareContext(ctx, "INSERT INTO metrics (id, mtype, delta) VALUES ($1, $2, $3) ON CONFLICT (id) DO UPDATE SET delta = EXCLUDED.delta + metrics.delta, updated_at = NOW()")
+	stmtC, err := dbs.db.PrepareContext(ctx, "INSERT INTO metrics (tenant, id, mtype, delta) VALUES ($1, $2, $3, $4) ON CONFLICT (tenant, id) DO UPDATE SET delta = EXCLUDED.delta + metrics.delta, updated_at = NOW()")
 	if err != nil {
 		logger.Error("counter statement creating error", zap.Error(err))
 		return err
 	}
 	defer stmtC.Close()
 
+	t := tenant.FromContext(ctx)
 	for _, m := range batch {
 		switch m.MType {
 		case "gauge":
-			_, err := stmtG.ExecContext(ctx, m.ID, "gauge", float64(*m.Value))
+			_, err := stmtG.ExecContext(ctx, t, m.ID, "gauge", float64(*m.Value))
 			if err != nil {
 				logger.Error("batch upsert gauge error", zap.Error(err))
 				if err = tx.Rollback(); err != nil {
@@ -245,7 +247,7 @@ func (dbs SQLStorage) BatchUpsert(ctx context.Context, batch []metrics.Metrics)
 				return err
 			}
 		case "counter":
-			_, err := stmtC.ExecContext(ctx, m.ID, "counter", int64(*m.Delta))
+			_, err := stmtC.ExecContext(ctx, t, m.ID, "counter", int64(*m.Delta))
 			if err != nil {
 				logger.Error("batch upsert counter error", zap.Error(err))
 				if err = tx.Rollback(); err != nil {
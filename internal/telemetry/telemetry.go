@@ -0,0 +1,162 @@
+// Package telemetry collects agent self-metrics.
+// Metrics are reported through the usual pipeline as an additional source.
+package telemetry
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/SerjRamone/metrius/internal/metrics"
+)
+
+// t is the default registry, updated by agent components
+var t = newRegistry()
+
+// registry stores self-metrics. Counters and latency are reset on every Collect.
+type registry struct {
+	batchesSent   atomic.Int64
+	batchesFailed atomic.Int64
+	bytesSent     atomic.Int64
+	itemsDropped  atomic.Int64
+	spoolDropped  atomic.Int64
+
+	mu           sync.Mutex
+	latencySum   time.Duration
+	latencyMax   time.Duration
+	latencyCount int
+	durations    map[string]time.Duration
+	queueDepth   func() int
+	buildInfo    map[string]string
+}
+
+func newRegistry() *registry {
+	return &registry{
+		durations: make(map[string]time.Duration),
+	}
+}
+
+// SetBuildInfo sets labels of AgentBuildInfo gauge
+func SetBuildInfo(version, commit string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.buildInfo = map[string]string{"version": version, "commit": commit}
+}
+
+// SetQueueDepth sets function returning number of batches waiting for sending
+func SetQueueDepth(fn func() int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.queueDepth = fn
+}
+
+// BatchSent counts successfully sent batch
+func BatchSent(latency time.Duration) {
+	t.batchesSent.Add(1)
+	t.observeLatency(latency)
+}
+
+// BatchFailed counts batch which failed to send
+func BatchFailed(latency time.Duration) {
+	t.batchesFailed.Add(1)
+	t.observeLatency(latency)
+}
+
+// BytesSent counts bytes of request body sent to server
+func BytesSent(n int) {
+	t.bytesSent.Add(int64(n))
+}
+
+// ItemsDropped counts metrics items which were lost
+func ItemsDropped(n int) {
+	t.itemsDropped.Add(int64(n))
+}
+
+// SpoolDropped counts spooled batch with n items which was dropped without sending
+func SpoolDropped(n int) {
+	t.spoolDropped.Add(1)
+	t.itemsDropped.Add(int64(n))
+}
+
+// CollectDuration records the last collect duration of source
+func CollectDuration(source string, d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.durations[source] = d
+}
+
+func (r *registry) observeLatency(d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.latencySum += d
+	r.latencyCount++
+	if d > r.latencyMax {
+		r.latencyMax = d
+	}
+}
+
+// collect returns self-metrics, durations are in seconds. Zero counter increments are skipped.
+func (r *registry) collect() metrics.Collection {
+	var c metrics.Collection
+	counters := []struct {
+		name  string
+		value *atomic.Int64
+	}{
+		{name: "AgentBatchesSent", value: &r.batchesSent},
+		{name: "AgentBatchesFailed", value: &r.batchesFailed},
+		{name: "AgentBytesSent", value: &r.bytesSent},
+		{name: "AgentItemsDropped", value: &r.itemsDropped},
+		{name: "AgentSpoolBatchesDropped", value: &r.spoolDropped},
+	}
+	for _, counter := range counters {
+		if n := counter.value.Swap(0); n > 0 {
+			c = append(c, metrics.CollectionItem{Name: counter.name, Type: "counter", Value: float64(n)})
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.latencyCount > 0 {
+		c = append(c,
+			metrics.CollectionItem{Name: "AgentSendLatencyAvg", Type: "gauge", Value: (r.latencySum / time.Duration(r.latencyCount)).Seconds()},
+			metrics.CollectionItem{Name: "AgentSendLatencyMax", Type: "gauge", Value: r.latencyMax.Seconds()},
+		)
+		r.latencySum, r.latencyMax, r.latencyCount = 0, 0, 0
+	}
+	if r.queueDepth != nil {
+		c = append(c, metrics.CollectionItem{Name: "AgentQueueDepth", Type: "gauge", Value: float64(r.queueDepth())})
+	}
+
+	sources := make([]string, 0, len(r.durations))
+	for source := range r.durations {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+	for _, source := range sources {
+		c = append(c, metrics.CollectionItem{
+			Name:  metrics.NameWithLabels("AgentCollectDuration", map[string]string{"source": source}),
+			Type:  "gauge",
+			Value: r.durations[source].Seconds(),
+		})
+	}
+
+	if r.buildInfo != nil {
+		c = append(c, metrics.CollectionItem{Name: metrics.NameWithLabels("AgentBuildInfo", r.buildInfo), Type: "gauge", Value: 1})
+	}
+	return c
+}
+
+// source reports self-metrics
+type source struct{}
+
+// Source returns self-metrics source for collector
+func Source() source {
+	return source{}
+}
+
+// Collect returns self-metrics since the previous call
+func (source) Collect() (metrics.Collection, error) {
+	return t.collect(), nil
+}
@@ -0,0 +1,52 @@
+package telemetry
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/SerjRamone/metrius/internal/metrics"
+)
+
+func values(c metrics.Collection) map[string]float64 {
+	m := make(map[string]float64, len(c))
+	for _, item := range c {
+		m[item.Name] = item.Value
+	}
+	return m
+}
+
+func TestSource_Collect(t *testing.T) {
+	SetBuildInfo("1.0.0", "abc")
+	SetQueueDepth(func() int { return 2 })
+	BatchSent(100 * time.Millisecond)
+	BatchSent(300 * time.Millisecond)
+	BatchFailed(200 * time.Millisecond)
+	BytesSent(512)
+	ItemsDropped(3)
+	SpoolDropped(2)
+	CollectDuration("runtime", 10*time.Millisecond)
+
+	c, err := Source().Collect()
+	require.NoError(t, err)
+	v := values(c)
+	assert.Equal(t, 2.0, v["AgentBatchesSent"])
+	assert.Equal(t, 1.0, v["AgentBatchesFailed"])
+	assert.Equal(t, 512.0, v["AgentBytesSent"])
+	assert.Equal(t, 5.0, v["AgentItemsDropped"])
+	assert.Equal(t, 1.0, v["AgentSpoolBatchesDropped"])
+	assert.InDelta(t, 0.2, v["AgentSendLatencyAvg"], 1e-9)
+	assert.InDelta(t, 0.3, v["AgentSendLatencyMax"], 1e-9)
+	assert.Equal(t, 2.0, v["AgentQueueDepth"])
+	assert.InDelta(t, 0.01, v[`AgentCollectDuration{source="runtime"}`], 1e-9)
+	assert.Equal(t, 1.0, v[`AgentBuildInfo{commit="abc",version="1.0.0"}`])
+
+	// counters and latency are reset
+	c, err = Source().Collect()
+	require.NoError(t, err)
+	v = values(c)
+	assert.NotContains(t, v, "AgentBatchesSent")
+	assert.NotContains(t, v, "AgentSendLatencyAvg")
+}
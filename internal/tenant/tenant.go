@@ -0,0 +1,125 @@
+// Package tenant binds requests to tenants and limits metrics of tenants.
+//
+// Tenant of request is set by authentication, requests without it belong to the default tenant.
+// Storages namespace metrics by tenant of context.
+package tenant
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Default is a tenant of unauthenticated clients and of tokens without tenant
+const Default = "default"
+
+// errors of quotas
+var (
+	ErrSeriesLimit = errors.New("tenant series limit exceeded")
+	ErrRateLimit   = errors.New("tenant ingestion rate limit exceeded")
+)
+
+// tenant IDs fit tenant column of metrics table
+var idRegexp = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,50}$`)
+
+// Validate checks tenant ID
+func Validate(id string) error {
+	if !idRegexp.MatchString(id) {
+		return fmt.Errorf("invalid tenant <%s>, it must be 1-50 letters, digits, '_', '.' or '-'", id)
+	}
+	return nil
+}
+
+type tenantKey struct{}
+
+// NewContext returns context with tenant
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, id)
+}
+
+// FromContext returns tenant of context, it's Default if not set
+func FromContext(ctx context.Context) string {
+	if id, ok := ctx.Value(tenantKey{}).(string); ok && id != "" {
+		return id
+	}
+	return Default
+}
+
+// Quota limits metrics of tenant, zero value means no limit
+type Quota struct {
+	// MaxSeries is a max number of series, series is a pair of metrics type and name
+	MaxSeries int
+	// Rate is a max number of ingested metrics per second
+	Rate float64
+	// Burst is a max number of metrics ingested at once, it must cover the largest batch.
+	// It's Rate rounded up by default.
+	Burst int
+}
+
+// Quotas keeps quotas of tenants and their rate limiters
+type Quotas struct {
+	def    Quota
+	quotas map[string]Quota
+
+	mu       sync.Mutex
+	limiters map[string]*rate.Limiter
+}
+
+// NewQuotas creates quotas with default quota of tenants and quotas of particular tenants.
+// It returns nil if nothing is limited.
+func NewQuotas(def Quota, quotas map[string]Quota) *Quotas {
+	limited := def != Quota{}
+	for _, q := range quotas {
+		limited = limited || q != Quota{}
+	}
+	if !limited {
+		return nil
+	}
+	return &Quotas{
+		def:      def,
+		quotas:   quotas,
+		limiters: make(map[string]*rate.Limiter),
+	}
+}
+
+// Get returns quota of tenant
+func (q *Quotas) Get(id string) Quota {
+	if quota, ok := q.quotas[id]; ok {
+		return quota
+	}
+	return q.def
+}
+
+// AllowN returns true if tenant may ingest n metrics now.
+// Batch bigger than burst is never allowed, so Burst must cover the largest batch.
+func (q *Quotas) AllowN(id string, n int) bool {
+	l := q.limiter(id)
+	return l == nil || l.AllowN(time.Now(), n)
+}
+
+// limiter returns rate limiter of tenant, it's nil if rate is not limited
+func (q *Quotas) limiter(id string) *rate.Limiter {
+	quota := q.Get(id)
+	if quota.Rate <= 0 {
+		return nil
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	l, ok := q.limiters[id]
+	if !ok {
+		burst := quota.Burst
+		if burst <= 0 {
+			burst = int(math.Ceil(quota.Rate))
+		}
+		l = rate.NewLimiter(rate.Limit(quota.Rate), burst)
+		q.limiters[id] = l
+	}
+	return l
+}
@@ -0,0 +1,205 @@
+// Package tlsconfig builds TLS configs of server and agent.
+//
+// Certificates are reloaded when their files are changed, so they can be renewed
+// without restart. Changes are checked on handshakes, not more often than once per checkInterval.
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/SerjRamone/metrius/pkg/logger"
+)
+
+// checkInterval is a min period between checks of files modification time
+const checkInterval = 10 * time.Second
+
+// Reloader holds certificate, key and CA bundle loaded from files
+type Reloader struct {
+	certFile string
+	keyFile  string
+	caFile   string
+
+	cert atomic.Pointer[tls.Certificate]
+	pool atomic.Pointer[x509.CertPool]
+
+	// mu guards reloading, modTime is the latest modification time of loaded files
+	mu      sync.Mutex
+	modTime time.Time
+	checked time.Time
+	now     func() time.Time
+}
+
+// NewReloader loads files, empty certFile and keyFile mean no own certificate,
+// empty caFile means system roots for client and no client verification for server
+func NewReloader(certFile, keyFile, caFile string) (*Reloader, error) {
+	if (certFile == "") != (keyFile == "") {
+		return nil, errors.New("TLS certificate and key must be set together")
+	}
+	r := &Reloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		caFile:   caFile,
+		now:      time.Now,
+	}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload loads files again, current certificates are kept on error
+func (r *Reloader) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.load()
+}
+
+// load reads all files and replaces certificates if all of them are valid, r.mu must be held
+func (r *Reloader) load() error {
+	modTime, err := r.lastModified()
+	if err != nil {
+		return err
+	}
+
+	var cert *tls.Certificate
+	if r.certFile != "" {
+		c, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+		if err != nil {
+			return fmt.Errorf("loading TLS certificate <%s> error: %w", r.certFile, err)
+		}
+		cert = &c
+	}
+	var pool *x509.CertPool
+	if r.caFile != "" {
+		data, err := os.ReadFile(r.caFile)
+		if err != nil {
+			return fmt.Errorf("reading CA bundle <%s> error: %w", r.caFile, err)
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return fmt.Errorf("CA bundle <%s> has no PEM certificates", r.caFile)
+		}
+	}
+
+	r.cert.Store(cert)
+	r.pool.Store(pool)
+	r.modTime = modTime
+	r.checked = r.now()
+	return nil
+}
+
+// lastModified returns the latest modification time of files
+func (r *Reloader) lastModified() (time.Time, error) {
+	var latest time.Time
+	for _, path := range []string{r.certFile, r.keyFile, r.caFile} {
+		if path == "" {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return latest, fmt.Errorf("TLS file <%s> error: %w", path, err)
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// check reloads files if they are changed since the last load
+func (r *Reloader) check() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.now()
+	if now.Sub(r.checked) < checkInterval {
+		return
+	}
+	r.checked = now
+	modTime, err := r.lastModified()
+	if err != nil {
+		logger.Warn("TLS files check error", zap.Error(err))
+		return
+	}
+	if !modTime.After(r.modTime) {
+		return
+	}
+	// files can be partially written, they are loaded again on the next check
+	if err = r.load(); err != nil {
+		logger.Error("TLS certificates reload error, keeping current ones", zap.Error(err))
+		return
+	}
+	logger.Info("TLS certificates reloaded")
+}
+
+// certificate returns current certificate
+func (r *Reloader) certificate() (*tls.Certificate, error) {
+	r.check()
+	cert := r.cert.Load()
+	if cert == nil {
+		return nil, errors.New("TLS certificate not set")
+	}
+	return cert, nil
+}
+
+// ServerConfig returns config of server with current certificate.
+// If CA bundle is set, clients must present certificate signed by it (mTLS).
+func (r *Reloader) ServerConfig() *tls.Config {
+	cfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return r.certificate()
+		},
+	}
+	if r.caFile != "" {
+		// client certificate is verified by VerifyConnection with current CA bundle
+		cfg.ClientAuth = tls.RequireAnyClientCert
+		cfg.VerifyConnection = r.verifyClient
+	}
+	return cfg
+}
+
+// verifyClient verifies client certificate chain by current CA bundle
+func (r *Reloader) verifyClient(cs tls.ConnectionState) error {
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("client certificate required")
+	}
+	opts := x509.VerifyOptions{
+		Roots:         r.pool.Load(),
+		Intermediates: x509.NewCertPool(),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	for _, c := range cs.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(c)
+	}
+	if _, err := cs.PeerCertificates[0].Verify(opts); err != nil {
+		return fmt.Errorf("client certificate verification error: %w", err)
+	}
+	return nil
+}
+
+// ClientConfig returns config of client, serverName overrides name of server in its certificate.
+// Client certificate is presented if it is set, CA bundle is used as root CAs
+// at the moment of call, so it is changed with the new client only.
+func (r *Reloader) ClientConfig(serverName string) *tls.Config {
+	cfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: serverName,
+		RootCAs:    r.pool.Load(),
+	}
+	if r.certFile != "" {
+		cfg.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return r.certificate()
+		}
+	}
+	return cfg
+}
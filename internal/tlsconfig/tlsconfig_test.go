@@ -0,0 +1,192 @@
+package tlsconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testCA issues certificates for tests
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue writes certificate and key files with common name to dir and returns their paths
+func (ca *testCA) issue(t *testing.T, dir, name string, usage x509.ExtKeyUsage) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certFile, keyFile := filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+	return certFile, keyFile
+}
+
+// handshake connects client to server over loopback and returns server certificate name
+func handshake(t *testing.T, server, client *tls.Config) (string, error) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+
+	errCh := make(chan error, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			errCh <- err
+			return
+		}
+		defer conn.Close()
+		errCh <- tls.Server(conn, server).Handshake()
+	}()
+
+	conn, err := tls.Dial("tcp", l.Addr().String(), client)
+	if err != nil {
+		<-errCh
+		return "", err
+	}
+	defer conn.Close()
+	// client certificate is verified after client handshake in TLS 1.3
+	if err = <-errCh; err != nil {
+		return "", err
+	}
+	return conn.ConnectionState().PeerCertificates[0].Subject.CommonName, nil
+}
+
+func TestReloader_Handshake(t *testing.T) {
+	dir := t.TempDir()
+	ca, otherCA := newTestCA(t), newTestCA(t)
+	caFile := filepath.Join(dir, "ca.pem")
+	require.NoError(t, os.WriteFile(caFile, ca.pem, 0600))
+	serverCert, serverKey := ca.issue(t, dir, "server", x509.ExtKeyUsageServerAuth)
+	clientCert, clientKey := ca.issue(t, dir, "client", x509.ExtKeyUsageClientAuth)
+	otherCert, otherKey := otherCA.issue(t, dir, "other", x509.ExtKeyUsageClientAuth)
+
+	tests := []struct {
+		name       string
+		serverCA   string
+		clientCert string
+		clientKey  string
+		wantErr    bool
+	}{
+		{name: "Test#1. TLS", wantErr: false},
+		{name: "Test#2. mTLS", serverCA: caFile, clientCert: clientCert, clientKey: clientKey, wantErr: false},
+		{name: "Test#3. mTLS without client certificate", serverCA: caFile, wantErr: true},
+		{name: "Test#4. mTLS with unknown CA", serverCA: caFile, clientCert: otherCert, clientKey: otherKey, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, err := NewReloader(serverCert, serverKey, tt.serverCA)
+			require.NoError(t, err)
+			client, err := NewReloader(tt.clientCert, tt.clientKey, caFile)
+			require.NoError(t, err)
+
+			name, err := handshake(t, server.ServerConfig(), client.ClientConfig("localhost"))
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, "server", name)
+		})
+	}
+}
+
+func TestReloader_Reload(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t)
+	caFile := filepath.Join(dir, "ca.pem")
+	require.NoError(t, os.WriteFile(caFile, ca.pem, 0600))
+	certFile, keyFile := ca.issue(t, dir, "server", x509.ExtKeyUsageServerAuth)
+
+	server, err := NewReloader(certFile, keyFile, "")
+	require.NoError(t, err)
+	now := time.Now()
+	server.now = func() time.Time { return now }
+	client, err := NewReloader("", "", caFile)
+	require.NoError(t, err)
+
+	// renewed certificate is written to the same files
+	newCert, newKey := ca.issue(t, dir, "renewed", x509.ExtKeyUsageServerAuth)
+	require.NoError(t, os.Rename(newCert, certFile))
+	require.NoError(t, os.Rename(newKey, keyFile))
+	future := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(certFile, future, future))
+
+	name, err := handshake(t, server.ServerConfig(), client.ClientConfig("localhost"))
+	require.NoError(t, err)
+	assert.Equal(t, "server", name, "files are not checked before checkInterval")
+
+	now = now.Add(checkInterval)
+	name, err = handshake(t, server.ServerConfig(), client.ClientConfig("localhost"))
+	require.NoError(t, err)
+	assert.Equal(t, "renewed", name)
+
+	// invalid files don't replace current certificate
+	require.NoError(t, os.WriteFile(keyFile, []byte("broken"), 0600))
+	require.NoError(t, os.Chtimes(keyFile, future.Add(time.Minute), future.Add(time.Minute)))
+	now = now.Add(checkInterval)
+	name, err = handshake(t, server.ServerConfig(), client.ClientConfig("localhost"))
+	require.NoError(t, err)
+	assert.Equal(t, "renewed", name)
+	assert.Error(t, server.Reload())
+}
+
+func TestNewReloader_Invalid(t *testing.T) {
+	_, err := NewReloader("cert.pem", "", "")
+	assert.Error(t, err)
+	_, err = NewReloader("", "", filepath.Join(t.TempDir(), "missing.pem"))
+	assert.Error(t, err)
+}
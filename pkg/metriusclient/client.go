@@ -0,0 +1,219 @@
+// Package metriusclient is a client library for instrumenting applications.
+//
+// Values of counters and gauges are aggregated locally and periodically
+// flushed to metrius server in the same wire formats the agent uses:
+// JSON batches over HTTP or BatchUpdate calls over gRPC.
+// Requests are signed with HMAC key and encrypted with RSA public key if they are configured,
+// both transports use TLS if it is configured.
+//
+// Example:
+//
+//	client, err := metriusclient.New(metriusclient.Config{Address: "localhost:8080"})
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	defer client.Close()
+//
+//	requests := client.Counter("Requests")
+//	requests.Inc()
+//	client.Gauge("QueueSize").Set(42)
+package metriusclient
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/SerjRamone/metrius/internal/metrics"
+	"github.com/SerjRamone/metrius/internal/sender"
+	"github.com/SerjRamone/metrius/pkg/logger"
+)
+
+const (
+	// TransportHTTP sends metrics to HTTP server
+	TransportHTTP = "http"
+	// TransportGRPC sends metrics to gRPC server
+	TransportGRPC = "grpc"
+
+	defaultFlushInterval = 10 * time.Second
+)
+
+// Config contents client settings
+type Config struct {
+	// Address is a host and port of metrius server
+	Address string
+	// Transport is TransportHTTP (default) or TransportGRPC
+	Transport string
+	// HashKey is a key for HMAC signing of requests
+	HashKey string
+	// AgentID is sent with signed requests, server checks sign with key of this ID
+	AgentID string
+	// PublicKey is a PEM-encoded RSA public key for request body encryption
+	PublicKey []byte
+	// Token is a bearer token, server requires write scope for updates
+	Token string
+	// TLS enables TLS connection to server, nil means plain connection
+	TLS *tls.Config
+	// FlushInterval is a period of sending aggregated values, 10 seconds by default
+	FlushInterval time.Duration
+}
+
+// Client aggregates metrics values and sends them to server
+type Client struct {
+	api      sender.APIClient
+	mu       sync.Mutex
+	counters map[string]*Counter
+	gauges   map[string]*Gauge
+	// serializes flushes
+	flushMu sync.Mutex
+	doneCh  chan struct{}
+	wg      sync.WaitGroup
+	closed  bool
+}
+
+// New creates client and starts periodic flushing
+func New(cfg Config) (*Client, error) {
+	if cfg.Address == "" {
+		return nil, errors.New("server address not set")
+	}
+	if cfg.Transport == "" {
+		cfg.Transport = TransportHTTP
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = defaultFlushInterval
+	}
+
+	opts := []sender.ClientOption{sender.WithAgentID(cfg.AgentID), sender.WithToken(cfg.Token)}
+	if cfg.TLS != nil {
+		opts = append(opts, sender.WithTLS(cfg.TLS))
+	}
+	api, err := sender.NewAPIClient(cfg.Address, cfg.HashKey, cfg.PublicKey, cfg.Transport, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Client{
+		api:      api,
+		counters: map[string]*Counter{},
+		gauges:   map[string]*Gauge{},
+		doneCh:   make(chan struct{}),
+	}
+
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		ticker := time.NewTicker(cfg.FlushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := c.Flush(); err != nil {
+					logger.Error("metriusclient flush error", zap.Error(err))
+				}
+			case <-c.doneCh:
+				return
+			}
+		}
+	}()
+
+	return c, nil
+}
+
+// Counter returns counter handle with name. Handles with the same name share value.
+func (c *Client) Counter(name string) *Counter {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if counter, ok := c.counters[name]; ok {
+		return counter
+	}
+	counter := &Counter{name: name}
+	c.counters[name] = counter
+	return counter
+}
+
+// Gauge returns gauge handle with name. Handles with the same name share value.
+func (c *Client) Gauge(name string) *Gauge {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if gauge, ok := c.gauges[name]; ok {
+		return gauge
+	}
+	gauge := &Gauge{name: name}
+	c.gauges[name] = gauge
+	return gauge
+}
+
+// Flush sends aggregated values to server.
+// Counter increments and gauge updates are kept for the next flush if sending fails.
+func (c *Client) Flush() error {
+	c.flushMu.Lock()
+	defer c.flushMu.Unlock()
+
+	c.mu.Lock()
+	counters := make([]*Counter, 0, len(c.counters))
+	for _, counter := range c.counters {
+		counters = append(counters, counter)
+	}
+	gauges := make([]*Gauge, 0, len(c.gauges))
+	for _, gauge := range c.gauges {
+		gauges = append(gauges, gauge)
+	}
+	c.mu.Unlock()
+
+	collection := make(metrics.Collection, 0, len(counters)+len(gauges))
+	deltas := make(map[*Counter]int64, len(counters))
+	for _, counter := range counters {
+		if delta := counter.delta.Swap(0); delta != 0 {
+			deltas[counter] = delta
+			collection = append(collection, metrics.CollectionItem{Name: counter.name, Type: "counter", Value: float64(delta)})
+		}
+	}
+	updated := make([]*Gauge, 0, len(gauges))
+	for _, gauge := range gauges {
+		if v, ok := gauge.take(); ok {
+			updated = append(updated, gauge)
+			collection = append(collection, metrics.CollectionItem{Name: gauge.name, Type: "gauge", Value: v})
+		}
+	}
+
+	if len(collection) == 0 {
+		return nil
+	}
+
+	if err := c.api.DoBatch(context.Background(), []metrics.Collection{collection}); err != nil {
+		// return values back to keep counters totals exact
+		for counter, delta := range deltas {
+			counter.Add(delta)
+		}
+		for _, gauge := range updated {
+			gauge.markUpdated()
+		}
+		return err
+	}
+	return nil
+}
+
+// Close stops periodic flushing, flushes remaining values and closes connection
+func (c *Client) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	c.mu.Unlock()
+
+	close(c.doneCh)
+	c.wg.Wait()
+
+	err := c.Flush()
+	if closer, ok := c.api.(io.Closer); ok {
+		err = errors.Join(err, closer.Close())
+	}
+	return err
+}
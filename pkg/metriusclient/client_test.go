@@ -0,0 +1,84 @@
+package metriusclient
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/SerjRamone/metrius/internal/metrics"
+)
+
+func TestClient_Flush(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		batches [][]metrics.Metrics
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/updates/", r.URL.Path)
+		assert.NotEmpty(t, r.Header.Get("HashSHA256"))
+
+		gr, err := gzip.NewReader(r.Body)
+		require.NoError(t, err)
+		defer gr.Close()
+
+		var batch []metrics.Metrics
+		require.NoError(t, json.NewDecoder(gr).Decode(&batch))
+
+		mu.Lock()
+		batches = append(batches, batch)
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	u, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	client, err := New(Config{Address: u.Host, HashKey: "testkey", FlushInterval: time.Hour})
+	require.NoError(t, err)
+
+	client.Counter("Requests").Inc()
+	client.Counter("Requests").Add(4)
+	client.Gauge("QueueSize").Set(3)
+	client.Gauge("QueueSize").Set(7)
+
+	require.NoError(t, client.Flush())
+	// nothing changed, nothing to send
+	require.NoError(t, client.Flush())
+
+	client.Counter("Requests").Inc()
+	require.NoError(t, client.Close())
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, batches, 2)
+
+	byID := map[string]metrics.Metrics{}
+	for _, m := range batches[0] {
+		byID[m.ID] = m
+	}
+	require.Contains(t, byID, "Requests")
+	require.Contains(t, byID, "QueueSize")
+	assert.Equal(t, int64(5), *byID["Requests"].Delta)
+	assert.Equal(t, float64(7), *byID["QueueSize"].Value)
+
+	require.Len(t, batches[1], 1)
+	assert.Equal(t, "Requests", batches[1][0].ID)
+	assert.Equal(t, int64(1), *batches[1][0].Delta)
+}
+
+func TestNew_Errors(t *testing.T) {
+	_, err := New(Config{})
+	assert.Error(t, err)
+
+	_, err = New(Config{Address: "localhost:8080", Transport: "udp"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,72 @@
+package metriusclient
+
+import (
+	"math"
+	"sync"
+	"sync/atomic"
+)
+
+// Counter is a metrics which value is increased on server by sent deltas
+type Counter struct {
+	name  string
+	delta atomic.Int64
+}
+
+// Inc increments counter by 1
+func (c *Counter) Inc() {
+	c.delta.Add(1)
+}
+
+// Add increments counter by n
+func (c *Counter) Add(n int64) {
+	c.delta.Add(n)
+}
+
+// Gauge is a metrics which value is replaced on server by the last one
+type Gauge struct {
+	name    string
+	mu      sync.Mutex
+	value   float64
+	updated bool
+}
+
+// Set sets gauge value
+func (g *Gauge) Set(v float64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.value = v
+	g.updated = true
+}
+
+// Add adds v to gauge value, v can be negative
+func (g *Gauge) Add(v float64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.value += v
+	g.updated = true
+}
+
+// Value returns current gauge value
+func (g *Gauge) Value() float64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.value
+}
+
+// take returns value if it was updated since previous flush
+func (g *Gauge) take() (float64, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !g.updated || math.IsNaN(g.value) {
+		return 0, false
+	}
+	g.updated = false
+	return g.value, true
+}
+
+// markUpdated makes value to be sent on the next flush
+func (g *Gauge) markUpdated() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.updated = true
+}
@@ -2,6 +2,9 @@
 package retry
 
 import (
+	"context"
+	"errors"
+	"math/rand"
 	"time"
 
 	"go.uber.org/zap"
@@ -9,22 +12,129 @@ import (
 	"github.com/SerjRamone/metrius/pkg/logger"
 )
 
-// WithBackoff ...
-func WithBackoff(retryable func() error, maxRetries int) error {
+// Policy describes exponential backoff with jitter.
+// Delay before attempt n is InitialInterval * Multiplier^(n-1),
+// limited by MaxInterval and randomized by ±Jitter part.
+type Policy struct {
+	// Retryable classifies errors, all errors are retryable if nil
+	Retryable       func(error) bool
+	InitialInterval time.Duration
+	MaxInterval     time.Duration
+	// MaxElapsedTime limits total time of retries, 0 means no limit
+	MaxElapsedTime time.Duration
+	Multiplier     float64
+	// Jitter is a randomization factor in range [0, 1]
+	Jitter float64
+	// MaxRetries limits number of retries after the first attempt, 0 means no limit
+	MaxRetries int
+}
+
+// DefaultPolicy returns policy with 3 retries, starting from 500ms delay
+func DefaultPolicy() Policy {
+	return Policy{
+		InitialInterval: 500 * time.Millisecond,
+		MaxInterval:     10 * time.Second,
+		MaxElapsedTime:  30 * time.Second,
+		Multiplier:      2,
+		Jitter:          0.5,
+		MaxRetries:      3,
+	}
+}
+
+// permanentError is never retried
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// Permanent wraps err to stop retries
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// Do calls fn until it succeeds, returns not retryable error,
+// limits are exceeded or ctx is done. The last error is returned.
+func (p Policy) Do(ctx context.Context, fn func(context.Context) error) error {
+	start := time.Now()
 	for attempt := 1; ; attempt++ {
-		err := retryable()
+		err := fn(ctx)
 		if err == nil {
 			return nil
 		}
 
-		logger.Error("retryable attempts", zap.Int("attempt num", attempt), zap.Error(err))
+		var permanent *permanentError
+		if errors.As(err, &permanent) {
+			return permanent.err
+		}
+		if ctx.Err() != nil || (p.Retryable != nil && !p.Retryable(err)) {
+			return err
+		}
+		if p.MaxRetries > 0 && attempt > p.MaxRetries {
+			return err
+		}
+
+		delay := p.delay(attempt)
+		if p.MaxElapsedTime > 0 && time.Since(start)+delay > p.MaxElapsedTime {
+			return err
+		}
+
+		logger.Error("retryable attempts", zap.Int("attempt num", attempt), zap.Duration("delay", delay), zap.Error(err))
 
-		if attempt >= maxRetries {
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
 			return err
 		}
+	}
+}
 
-		// increase interval before next attempt
-		delay := time.Duration(attempt*(attempt+1)/2) * time.Second
-		time.Sleep(delay)
+// delay returns randomized delay before next attempt
+func (p Policy) delay(attempt int) time.Duration {
+	d := float64(p.InitialInterval)
+	for i := 1; i < attempt; i++ {
+		d *= p.Multiplier
+		if p.MaxInterval > 0 && d > float64(p.MaxInterval) {
+			break
+		}
+	}
+	if p.MaxInterval > 0 && d > float64(p.MaxInterval) {
+		d = float64(p.MaxInterval)
+	}
+	if p.Jitter > 0 {
+		// random value in range [d - Jitter*d, d + Jitter*d]
+		delta := p.Jitter * d
+		d = d - delta + rand.Float64()*2*delta
+	}
+	return time.Duration(d)
+}
+
+// WithBackoff calls retryable up to maxRetries times with increasing delays.
+//
+// Deprecated: use Policy.Do, it is context-aware and supports errors classification.
+func WithBackoff(retryable func() error, maxRetries int) error {
+	p := Policy{
+		InitialInterval: time.Second,
+		MaxInterval:     10 * time.Second,
+		Multiplier:      2,
+		Jitter:          0.2,
+		MaxRetries:      maxRetries - 1,
+	}
+	if maxRetries <= 1 {
+		return retryable()
 	}
+	return p.Do(context.Background(), func(context.Context) error {
+		return retryable()
+	})
 }
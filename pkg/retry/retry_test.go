@@ -0,0 +1,100 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testPolicy() Policy {
+	return Policy{
+		InitialInterval: time.Millisecond,
+		MaxInterval:     5 * time.Millisecond,
+		Multiplier:      2,
+		MaxRetries:      3,
+	}
+}
+
+func TestPolicy_Do(t *testing.T) {
+	errTemporary := errors.New("temporary")
+	errFatal := errors.New("fatal")
+
+	tests := []struct {
+		name      string
+		errs      []error
+		retryable func(error) bool
+		wantErr   error
+		wantCalls int
+	}{
+		{
+			name:      "Test#1. Success after retries",
+			errs:      []error{errTemporary, errTemporary, nil},
+			wantCalls: 3,
+		},
+		{
+			name:      "Test#2. Retries are exhausted",
+			errs:      []error{errTemporary, errTemporary, errTemporary, errTemporary, errTemporary},
+			wantErr:   errTemporary,
+			wantCalls: 4,
+		},
+		{
+			name:      "Test#3. Not retryable error",
+			errs:      []error{errFatal, nil},
+			retryable: func(err error) bool { return !errors.Is(err, errFatal) },
+			wantErr:   errFatal,
+			wantCalls: 1,
+		},
+		{
+			name:      "Test#4. Permanent error",
+			errs:      []error{Permanent(errFatal), nil},
+			wantErr:   errFatal,
+			wantCalls: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := testPolicy()
+			p.Retryable = tt.retryable
+
+			calls := 0
+			err := p.Do(context.Background(), func(context.Context) error {
+				err := tt.errs[calls]
+				calls++
+				return err
+			})
+			assert.ErrorIs(t, err, tt.wantErr)
+			assert.Equal(t, tt.wantCalls, calls)
+		})
+	}
+}
+
+func TestPolicy_DoCanceled(t *testing.T) {
+	p := testPolicy()
+	p.InitialInterval = time.Hour
+	p.MaxInterval = time.Hour
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err := p.Do(ctx, func(context.Context) error { return errors.New("temporary") })
+	assert.Error(t, err)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestPolicy_delay(t *testing.T) {
+	p := Policy{InitialInterval: 100 * time.Millisecond, MaxInterval: time.Second, Multiplier: 2}
+	assert.Equal(t, 100*time.Millisecond, p.delay(1))
+	assert.Equal(t, 400*time.Millisecond, p.delay(3))
+	assert.Equal(t, time.Second, p.delay(10))
+
+	p.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		d := p.delay(1)
+		assert.GreaterOrEqual(t, d, 50*time.Millisecond)
+		assert.LessOrEqual(t, d, 150*time.Millisecond)
+	}
+}